@@ -0,0 +1,340 @@
+// Package cache provides a phi middleware caching responses of idempotent
+// routes.
+//
+// Responses are stored in a pluggable Cache, like the provided in-memory
+// LRU, keyed by method, path, query string and the request headers the
+// responses vary on. Cache-Control is honored on both requests and
+// responses, concurrent misses for the same key are collapsed into a
+// single handler call, and entries can be purged by route pattern.
+// Requests with credentials bypass the cache, unless their Authorization
+// or Cookie header is part of the key:
+//
+//  rc := cache.New(cache.Config{
+//    Cache: cache.NewLRU(64 << 20),
+//    TTL:   time.Minute,
+//    Vary:  []string{"Accept-Language"},
+//  })
+//  r.With(rc.Handler).Get("/articles/{id}", getArticle)
+//  r.Put("/articles/{id}", func(ctx *fasthttp.RequestCtx) {
+//    updateArticle(ctx)
+//    rc.Purge("/articles/{id}")
+//  })
+package cache
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+// Entry is a cached response.
+type Entry struct {
+	Status  int
+	Header  [][2]string
+	Body    []byte
+	Created time.Time
+	Expires time.Time
+
+	// Pattern is the routing pattern which served the response.
+	Pattern string
+}
+
+func (e *Entry) size() int64 {
+	n := int64(len(e.Body) + len(e.Pattern))
+	for _, kv := range e.Header {
+		n += int64(len(kv[0]) + len(kv[1]))
+	}
+	return n
+}
+
+// Config configures a ResponseCache.
+type Config struct {
+	// Cache stores the responses. Required.
+	Cache Cache
+
+	// TTL applies to responses without a max-age directive. When zero,
+	// only responses with a max-age are cached.
+	TTL time.Duration
+
+	// Vary lists the request headers the responses vary on, their values
+	// are part of the cache key. Responses with a Vary header on anything
+	// else aren't cached.
+	//
+	// Requests with an Authorization or Cookie header are neither served
+	// from the cache nor cached, as their responses may be specific to a
+	// user, unless the header is listed here.
+	Vary []string
+
+	// Statuses lists the cacheable response status codes, only 200 by
+	// default.
+	Statuses []int
+
+	// Now returns the current time, time.Now by default.
+	Now func() time.Time
+}
+
+// ResponseCache is a response caching middleware, see New.
+type ResponseCache struct {
+	cfg Config
+
+	mu       sync.Mutex
+	inflight map[string]*call
+}
+
+// call is an in-flight handler call other requests for the same key wait on.
+type call struct {
+	done chan struct{}
+}
+
+// New returns a ResponseCache configured by cfg.
+func New(cfg Config) *ResponseCache {
+	if cfg.Cache == nil {
+		panic("cache: New requires a Cache")
+	}
+	if len(cfg.Statuses) == 0 {
+		cfg.Statuses = []int{fasthttp.StatusOK}
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	for i, h := range cfg.Vary {
+		cfg.Vary[i] = strings.ToLower(h)
+	}
+	return &ResponseCache{cfg: cfg, inflight: make(map[string]*call)}
+}
+
+// Purge removes the cached responses of the route `pattern`, as reported
+// by phi's RoutePattern, e.g. "/articles/{id}".
+func (rc *ResponseCache) Purge(pattern string) {
+	rc.cfg.Cache.DeletePattern(pattern)
+}
+
+// Handler is the phi.Middleware of the cache.
+func (rc *ResponseCache) Handler(next phi.HandlerFunc) phi.HandlerFunc {
+	return func(ctx *fasthttp.RequestCtx) {
+		if !ctx.IsGet() && !ctx.IsHead() {
+			next(ctx)
+			return
+		}
+
+		reqCC := parseCacheControl(ctx.Request.Header.Peek(fasthttp.HeaderCacheControl))
+		if reqCC.noStore || rc.hasCredentials(ctx) {
+			next(ctx)
+			return
+		}
+
+		key := rc.key(ctx)
+		revalidate := reqCC.noCache || (reqCC.hasMaxAge && reqCC.maxAge == 0)
+
+		for !revalidate {
+			if e, ok := rc.cfg.Cache.Get(key); ok {
+				age := rc.cfg.Now().Sub(e.Created)
+				if !reqCC.hasMaxAge || age <= reqCC.maxAge {
+					rc.serve(ctx, e, age)
+					return
+				}
+				break
+			}
+
+			// Only the first request of a key runs the handler, the
+			// others wait for its response to be cached.
+			rc.mu.Lock()
+			c, ok := rc.inflight[key]
+			if !ok {
+				c = &call{done: make(chan struct{})}
+				rc.inflight[key] = c
+				rc.mu.Unlock()
+				defer rc.release(key, c)
+				break
+			}
+			rc.mu.Unlock()
+			<-c.done
+
+			if _, ok := rc.cfg.Cache.Get(key); !ok {
+				// The response wasn't cacheable, run the handler.
+				break
+			}
+		}
+
+		next(ctx)
+
+		if ctx.IsGet() {
+			rc.store(ctx, key)
+		}
+		ctx.Response.Header.Set("X-Cache", "MISS")
+	}
+}
+
+// hasCredentials reports whether the request carries credentials which
+// aren't part of the cache key, RFC 9111 section 3.5.
+func (rc *ResponseCache) hasCredentials(ctx *fasthttp.RequestCtx) bool {
+	for _, h := range []string{fasthttp.HeaderAuthorization, fasthttp.HeaderCookie} {
+		if len(ctx.Request.Header.Peek(h)) > 0 && !rc.varies(h) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rc *ResponseCache) varies(header string) bool {
+	header = strings.ToLower(header)
+	for _, v := range rc.cfg.Vary {
+		if v == header {
+			return true
+		}
+	}
+	return false
+}
+
+func (rc *ResponseCache) release(key string, c *call) {
+	rc.mu.Lock()
+	delete(rc.inflight, key)
+	rc.mu.Unlock()
+	close(c.done)
+}
+
+// key builds the cache key of the request. HEAD requests share the GET
+// entries.
+func (rc *ResponseCache) key(ctx *fasthttp.RequestCtx) string {
+	var b bytes.Buffer
+	b.WriteString("GET ")
+	b.Write(ctx.URI().Path())
+	if q := ctx.URI().QueryString(); len(q) > 0 {
+		b.WriteByte('?')
+		b.Write(q)
+	}
+	for _, h := range rc.cfg.Vary {
+		b.WriteByte('\n')
+		b.WriteString(h)
+		b.WriteByte(':')
+		b.Write(ctx.Request.Header.Peek(h))
+	}
+	return b.String()
+}
+
+// store caches the response if it's cacheable.
+func (rc *ResponseCache) store(ctx *fasthttp.RequestCtx, key string) {
+	resp := &ctx.Response
+	if !rc.cacheableStatus(resp.StatusCode()) || resp.IsBodyStream() {
+		return
+	}
+	if hasSetCookie(resp) {
+		return
+	}
+	if !rc.varyAllowed(resp.Header.Peek(fasthttp.HeaderVary)) {
+		return
+	}
+
+	cc := parseCacheControl(resp.Header.Peek(fasthttp.HeaderCacheControl))
+	if cc.noStore || cc.noCache || cc.private {
+		return
+	}
+	ttl := rc.cfg.TTL
+	if cc.hasSMaxAge {
+		ttl = cc.sMaxAge
+	} else if cc.hasMaxAge {
+		ttl = cc.maxAge
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	now := rc.cfg.Now()
+	e := &Entry{
+		Status:  resp.StatusCode(),
+		Body:    append([]byte(nil), resp.Body()...),
+		Created: now,
+		Expires: now.Add(ttl),
+	}
+	resp.Header.VisitAll(func(k, v []byte) {
+		switch string(k) {
+		case fasthttp.HeaderContentLength, fasthttp.HeaderDate, fasthttp.HeaderServer, "X-Cache":
+			return
+		}
+		e.Header = append(e.Header, [2]string{string(k), string(v)})
+	})
+	if rctx, ok := ctx.UserValue(phi.RouteCtxKey).(*phi.Context); ok {
+		e.Pattern = rctx.RoutePattern()
+	}
+	rc.cfg.Cache.Set(key, e)
+}
+
+func (rc *ResponseCache) serve(ctx *fasthttp.RequestCtx, e *Entry, age time.Duration) {
+	ctx.Response.Reset()
+	ctx.SetStatusCode(e.Status)
+	for _, kv := range e.Header {
+		ctx.Response.Header.Add(kv[0], kv[1])
+	}
+	ctx.Response.Header.Set(fasthttp.HeaderAge, strconv.Itoa(int(age/time.Second)))
+	ctx.Response.Header.Set("X-Cache", "HIT")
+	ctx.SetBody(e.Body)
+}
+
+func (rc *ResponseCache) cacheableStatus(status int) bool {
+	for _, s := range rc.cfg.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// varyAllowed reports whether every header of a response Vary header is
+// part of the cache key.
+func (rc *ResponseCache) varyAllowed(vary []byte) bool {
+	for _, h := range strings.Split(string(vary), ",") {
+		h = strings.TrimSpace(h)
+		if h != "" && !rc.varies(h) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasSetCookie(resp *fasthttp.Response) bool {
+	found := false
+	resp.Header.VisitAllCookie(func(key, value []byte) {
+		found = true
+	})
+	return found
+}
+
+// cacheControl holds the Cache-Control directives the cache cares about.
+type cacheControl struct {
+	noStore, noCache, private bool
+	hasMaxAge, hasSMaxAge     bool
+	maxAge, sMaxAge           time.Duration
+}
+
+func parseCacheControl(v []byte) cacheControl {
+	var cc cacheControl
+	for _, directive := range strings.Split(string(v), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		name, value := directive, ""
+		if i := strings.IndexByte(directive, '='); i >= 0 {
+			name, value = directive[:i], strings.Trim(directive[i+1:], `"`)
+		}
+		switch name {
+		case "no-store":
+			cc.noStore = true
+		case "no-cache":
+			cc.noCache = true
+		case "private":
+			cc.private = true
+		case "max-age":
+			if secs, err := strconv.Atoi(value); err == nil {
+				cc.hasMaxAge, cc.maxAge = true, time.Duration(secs)*time.Second
+			}
+		case "s-maxage":
+			if secs, err := strconv.Atoi(value); err == nil {
+				cc.hasSMaxAge, cc.sMaxAge = true, time.Duration(secs)*time.Second
+			}
+		}
+	}
+	return cc
+}
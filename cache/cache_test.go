@@ -0,0 +1,219 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fate-lovely/phi"
+	"github.com/gavv/httpexpect"
+	"github.com/valyala/fasthttp"
+)
+
+func TestResponseCache(t *testing.T) {
+	var calls int32
+	count := func(ctx *fasthttp.RequestCtx) {
+		n := atomic.AddInt32(&calls, 1)
+		ctx.WriteString(strconv.Itoa(int(n)))
+	}
+
+	rc := New(Config{Cache: NewLRU(1 << 20), TTL: time.Minute, Vary: []string{"Accept-Language"}})
+	r := phi.NewRouter()
+	r.Use(rc.Handler)
+	r.Get("/articles/{id}", count)
+	r.Get("/users/{id}", count)
+	r.Get("/private", func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set("Cache-Control", "private")
+		count(ctx)
+	})
+	r.Get("/vary", func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set("Vary", "Cookie")
+		count(ctx)
+	})
+	r.Get("/missing", func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(404)
+		count(ctx)
+	})
+	r.Post("/articles/{id}", count)
+
+	e := newFastHTTPTester(t, r)
+
+	e.GET("/articles/1").Expect().Status(200).Text().Equal("1")
+	res := e.GET("/articles/1").Expect()
+	res.Text().Equal("1")
+	res.Header("X-Cache").Equal("HIT")
+	res.Header("Age").Equal("0")
+	e.HEAD("/articles/1").Expect().Header("X-Cache").Equal("HIT")
+
+	// query and vary headers are part of the key
+	e.GET("/articles/1").WithQuery("page", "2").Expect().Text().Equal("2")
+	e.GET("/articles/1").WithHeader("Accept-Language", "fr").Expect().Text().Equal("3")
+	e.GET("/articles/1").WithHeader("Accept-Language", "fr").Expect().Text().Equal("3")
+
+	// request Cache-Control
+	e.GET("/articles/1").WithHeader("Cache-Control", "no-store").Expect().Text().Equal("4")
+	e.GET("/articles/1").Expect().Text().Equal("1")
+	e.GET("/articles/1").WithHeader("Cache-Control", "no-cache").Expect().Text().Equal("5")
+	e.GET("/articles/1").Expect().Text().Equal("5")
+
+	// uncacheable responses
+	e.GET("/private").Expect().Text().Equal("6")
+	e.GET("/private").Expect().Text().Equal("7")
+	e.GET("/vary").Expect().Text().Equal("8")
+	e.GET("/vary").Expect().Text().Equal("9")
+	e.GET("/missing").Expect().Status(404).Text().Equal("10")
+	e.GET("/missing").Expect().Status(404).Text().Equal("11")
+	e.POST("/articles/1").Expect().Text().Equal("12")
+	e.POST("/articles/1").Expect().Text().Equal("13")
+
+	// purge by route pattern
+	e.GET("/users/1").Expect().Text().Equal("14")
+	rc.Purge("/articles/{id}")
+	e.GET("/articles/1").Expect().Text().Equal("15")
+	e.GET("/users/1").Expect().Text().Equal("14")
+}
+
+func TestResponseCacheCredentials(t *testing.T) {
+	user := func(ctx *fasthttp.RequestCtx) {
+		ctx.Write(ctx.Request.Header.Peek("Authorization"))
+		ctx.Write(ctx.Request.Header.Peek("Cookie"))
+	}
+
+	r := phi.NewRouter()
+	r.With(New(Config{Cache: NewLRU(1 << 20), TTL: time.Minute}).Handler).Get("/me", user)
+	r.With(New(Config{Cache: NewLRU(1 << 20), TTL: time.Minute, Vary: []string{"Authorization"}}).Handler).Get("/vary", user)
+
+	e := newFastHTTPTester(t, r)
+
+	// neither served from nor stored in the shared cache
+	e.GET("/me").WithHeader("Authorization", "Bearer alice").Expect().Text().Equal("Bearer alice")
+	e.GET("/me").WithHeader("Authorization", "Bearer bob").Expect().Text().Equal("Bearer bob")
+	e.GET("/me").WithHeader("Cookie", "session=bob").Expect().Text().Equal("session=bob")
+	res := e.GET("/me").Expect()
+	res.Text().Equal("")
+	res.Header("X-Cache").Equal("MISS")
+	e.GET("/me").WithHeader("Authorization", "Bearer alice").Expect().Text().Equal("Bearer alice")
+
+	// unless the credentials are part of the key
+	e.GET("/vary").WithHeader("Authorization", "Bearer alice").Expect().Text().Equal("Bearer alice")
+	e.GET("/vary").WithHeader("Authorization", "Bearer bob").Expect().Text().Equal("Bearer bob")
+	res = e.GET("/vary").WithHeader("Authorization", "Bearer alice").Expect()
+	res.Text().Equal("Bearer alice")
+	res.Header("X-Cache").Equal("HIT")
+}
+
+func TestResponseCacheMaxAge(t *testing.T) {
+	now := time.Now()
+	var calls int
+	rc := New(Config{Cache: NewLRU(1 << 20), Now: func() time.Time { return now }})
+	lru := rc.cfg.Cache.(*LRU)
+	lru.now = rc.cfg.Now
+
+	r := phi.NewRouter()
+	r.Use(rc.Handler)
+	r.Get("/", func(ctx *fasthttp.RequestCtx) {
+		calls++
+		ctx.Response.Header.Set("Cache-Control", "public, max-age=60")
+		ctx.WriteString(strconv.Itoa(calls))
+	})
+	r.Get("/no-ttl", func(ctx *fasthttp.RequestCtx) {
+		calls++
+		ctx.WriteString(strconv.Itoa(calls))
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.GET("/").Expect().Text().Equal("1")
+	now = now.Add(30 * time.Second)
+	e.GET("/").Expect().Header("Age").Equal("30")
+	e.GET("/").WithHeader("Cache-Control", "max-age=10").Expect().Text().Equal("2")
+	now = now.Add(61 * time.Second)
+	e.GET("/").Expect().Text().Equal("3")
+
+	// without a TTL nor a max-age nothing is cached
+	e.GET("/no-ttl").Expect().Text().Equal("4")
+	e.GET("/no-ttl").Expect().Text().Equal("5")
+}
+
+func TestResponseCacheStampede(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	rc := New(Config{Cache: NewLRU(1 << 20), TTL: time.Minute})
+	h := rc.Handler(func(ctx *fasthttp.RequestCtx) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		ctx.WriteString("slow")
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var ctx fasthttp.RequestCtx
+			ctx.Request.SetRequestURI("/slow")
+			h(&ctx)
+			if string(ctx.Response.Body()) != "slow" {
+				t.Errorf("body = %q", ctx.Response.Body())
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("handler called %d times, want 1", calls)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	c := NewLRU(100)
+	entry := func(pattern string) *Entry {
+		return &Entry{Body: make([]byte, 30), Pattern: pattern, Expires: time.Now().Add(time.Hour)}
+	}
+
+	c.Set("a", entry("/a"))
+	c.Set("b", entry("/b"))
+	c.Set("c", entry("/b"))
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("a should be cached")
+	}
+	// "b" is the least recently used
+	c.Set("d", entry("/d"))
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("b should be evicted")
+	}
+	if c.Len() != 3 {
+		t.Fatalf("len = %d, want 3", c.Len())
+	}
+
+	c.DeletePattern("/b")
+	if _, ok := c.Get("c"); ok {
+		t.Fatal("c should be purged")
+	}
+
+	c.Set("big", &Entry{Body: make([]byte, 200), Expires: time.Now().Add(time.Hour)})
+	if _, ok := c.Get("big"); ok {
+		t.Fatal("entries over budget shouldn't be cached")
+	}
+
+	c.Set("old", &Entry{Expires: time.Now().Add(-time.Second)})
+	if _, ok := c.Get("old"); ok {
+		t.Fatal("expired entries shouldn't be returned")
+	}
+}
+
+func newFastHTTPTester(t *testing.T, h phi.Handler) *httpexpect.Expect {
+	return httpexpect.WithConfig(httpexpect.Config{
+		// Pass requests directly to FastHTTPHandler.
+		Client: &http.Client{
+			Transport: httpexpect.NewFastBinder(fasthttp.RequestHandler(h.ServeFastHTTP)),
+			Jar:       httpexpect.NewJar(),
+		},
+		// Report errors using testify.
+		Reporter: httpexpect.NewAssertReporter(t),
+	})
+}
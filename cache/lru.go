@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache stores cached responses. Implementations must be safe for
+// concurrent use.
+type Cache interface {
+	// Get returns the entry stored under `key`.
+	Get(key string) (*Entry, bool)
+
+	// Set stores the entry under `key`, replacing any previous one.
+	Set(key string, e *Entry)
+
+	// Delete removes the entry stored under `key`.
+	Delete(key string)
+
+	// DeletePattern removes every entry cached for the route `pattern`.
+	DeletePattern(pattern string)
+}
+
+// LRU is an in-memory Cache holding up to a byte budget of entries, and
+// evicting the least recently used ones beyond.
+type LRU struct {
+	maxBytes int64
+
+	mu       sync.Mutex
+	size     int64
+	ll       *list.List
+	items    map[string]*list.Element
+	patterns map[string]map[string]struct{}
+
+	// now is overridden in tests
+	now func() time.Time
+}
+
+type lruItem struct {
+	key   string
+	entry *Entry
+	size  int64
+}
+
+// NewLRU returns an LRU cache using at most `maxBytes` for its entries.
+func NewLRU(maxBytes int64) *LRU {
+	return &LRU{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		patterns: make(map[string]map[string]struct{}),
+		now:      time.Now,
+	}
+}
+
+// Get implements Cache. Expired entries are never returned.
+func (c *LRU) Get(key string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := el.Value.(*lruItem)
+	if !c.now().Before(item.entry.Expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return item.entry, true
+}
+
+// Set implements Cache. Entries larger than the whole budget are dropped.
+func (c *LRU) Set(key string, e *Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+
+	size := int64(len(key)) + e.size()
+	if size > c.maxBytes {
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruItem{key: key, entry: e, size: size})
+	c.size += size
+	keys, ok := c.patterns[e.Pattern]
+	if !ok {
+		keys = make(map[string]struct{})
+		c.patterns[e.Pattern] = keys
+	}
+	keys[key] = struct{}{}
+
+	for c.size > c.maxBytes {
+		c.remove(c.ll.Back())
+	}
+}
+
+// Delete implements Cache.
+func (c *LRU) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+// DeletePattern implements Cache.
+func (c *LRU) DeletePattern(pattern string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.patterns[pattern] {
+		c.remove(c.items[key])
+	}
+}
+
+// Len returns the number of cached entries.
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// remove drops an element, it must be called with c.mu held.
+func (c *LRU) remove(el *list.Element) {
+	item := c.ll.Remove(el).(*lruItem)
+	delete(c.items, item.key)
+	c.size -= item.size
+
+	if keys, ok := c.patterns[item.entry.Pattern]; ok {
+		delete(keys, item.key)
+		if len(keys) == 0 {
+			delete(c.patterns, item.entry.Pattern)
+		}
+	}
+}
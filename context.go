@@ -1,14 +1,17 @@
 package phi
 
 import (
+	"net"
 	"strings"
-
-	"github.com/valyala/fasthttp"
+	"unsafe"
 )
 
 var (
 	// RouteCtxKey is the context.Context key to store the request context.
 	RouteCtxKey = (&contextKey{"RouteContext"}).String()
+
+	// routeCtxKey is RouteCtxKey converted once, as converting it allocates
+	routeCtxKey interface{} = RouteCtxKey
 )
 
 // Context is the default routing context set on the root node of a
@@ -28,9 +31,22 @@ type Context struct {
 	RoutePatterns []string
 
 	// URLParams are the stack of routeParams captured during the
-	// routing lifecycle across a stack of sub-routers.
+	// routing lifecycle across a stack of sub-routers. Their values are
+	// only valid until the request handler returns, copy them to keep
+	// them longer.
 	URLParams RouteParams
 
+	// Client address, scheme and host of the request as resolved behind
+	// trusted proxies, e.g. by middleware.RealIP. They're left empty
+	// when no such middleware is in use.
+	ClientIP net.IP
+	Scheme   string
+	Host     string
+
+	// Version is the API version requested, as resolved by the first
+	// router configured with Mux.Versioning, or its default version.
+	Version string
+
 	// The endpoint routing pattern that matched the request URI path
 	// or `RoutePath` of the current sub-router. This value will update
 	// during the lifecycle of a request passing through a stack of
@@ -41,13 +57,57 @@ type Context struct {
 	// intentionally unexported so it cant be tampered.
 	routeParams RouteParams
 
+	// value of the catch-all param matched for the current sub-router,
+	// routed by the mounted sub-routers
+	routeWildcard string
+
 	// methodNotAllowed hint
 	methodNotAllowed bool
+
+	// copy of the request path matched against, reused across requests
+	path []byte
+
+	// RoutePattern result, joined from the first routePatternN patterns
+	routePatternJoined string
+	routePatternN      int
+
+	// default API version of the router which resolved Version
+	defaultVersion string
+
+	// index of the first paramsIndexed URLParams, by router matched
+	paramFrames   []paramFrame
+	paramsIndexed int
+
+	// storage of the first params and frames, before they overflow. The
+	// values are views of `path`, valid during the request only
+	inline struct {
+		urlKeys, urlValues     [inlineParams]string
+		routeKeys, routeValues [inlineParams]string
+		frames                 [inlineFrames]paramFrame
+	}
+}
+
+const (
+	inlineParams = 8
+	inlineFrames = 4
+)
+
+// paramFrame indexes the params matched by a router, starting at `off`
+// in URLParams.
+type paramFrame struct {
+	off   int
+	index map[string]int
 }
 
 // NewRouteContext returns a new routing Context object.
 func NewRouteContext() *Context {
-	return &Context{}
+	x := &Context{}
+	x.URLParams.Keys = x.inline.urlKeys[:0]
+	x.URLParams.Values = x.inline.urlValues[:0]
+	x.routeParams.Keys = x.inline.routeKeys[:0]
+	x.routeParams.Values = x.inline.routeValues[:0]
+	x.paramFrames = x.inline.frames[:0]
+	return x
 }
 
 // Reset a routing context to its initial state.
@@ -58,16 +118,57 @@ func (x *Context) Reset() {
 	x.RoutePatterns = x.RoutePatterns[:0]
 	x.URLParams.Keys = x.URLParams.Keys[:0]
 	x.URLParams.Values = x.URLParams.Values[:0]
+	x.ClientIP = nil
+	x.Scheme = ""
+	x.Host = ""
+	x.Version = ""
 
 	x.routePattern = ""
 	x.routeParams.Keys = x.routeParams.Keys[:0]
 	x.routeParams.Values = x.routeParams.Values[:0]
+	x.routeWildcard = ""
 	x.methodNotAllowed = false
+	x.defaultVersion = ""
+	x.path = x.path[:0]
+	x.routePatternJoined = ""
+	x.routePatternN = 0
+	x.paramFrames = x.paramFrames[:0]
+	x.paramsIndexed = 0
+}
+
+// clone returns a copy of the routing context which doesn't share its
+// slices nor its request path, to outlive the pooled one.
+func (x *Context) clone() *Context {
+	c := *x
+	c.RoutePatterns = append([]string(nil), x.RoutePatterns...)
+	c.URLParams.Keys = append([]string(nil), x.URLParams.Keys...)
+	c.URLParams.Values = copyStrings(x.URLParams.Values)
+	c.routeParams.Keys = append([]string(nil), x.routeParams.Keys...)
+	c.routeParams.Values = copyStrings(x.routeParams.Values)
+	c.RoutePath = string([]byte(x.RoutePath))
+	c.Version = string([]byte(x.Version))
+	c.routeWildcard = string([]byte(x.routeWildcard))
+	c.path = nil
+	c.paramFrames = append([]paramFrame(nil), x.paramFrames...)
+	return &c
 }
 
 // URLParam returns the corresponding URL parameter value from the request
-// routing context.
+// routing context. It views the request path, and is only valid until the
+// request handler returns.
 func (x *Context) URLParam(key string) string {
+	// Look the key up in the index of the routes matched, as long as
+	// they recorded all of the params
+	if x.paramsIndexed == len(x.URLParams.Keys) && x.paramsIndexed <= len(x.URLParams.Values) {
+		for i := len(x.paramFrames) - 1; i >= 0; i-- {
+			f := x.paramFrames[i]
+			if k, ok := f.index[key]; ok {
+				return x.URLParams.Values[f.off+k]
+			}
+		}
+		return ""
+	}
+
 	for k := len(x.URLParams.Keys) - 1; k >= 0; k-- {
 		if x.URLParams.Keys[k] == key {
 			return x.URLParams.Values[k]
@@ -90,19 +191,34 @@ func (x *Context) URLParam(key string) string {
 //     measure(w, r, routePattern)
 // 	 })
 // }
+//
+// The pattern is joined once per request, as long as RoutePatterns is only
+// appended to.
 func (x *Context) RoutePattern() string {
-	routePattern := strings.Join(x.RoutePatterns, "")
-	return strings.Replace(routePattern, "/*/", "/", -1)
+	if x.routePatternN != len(x.RoutePatterns) || x.routePatternJoined == "" {
+		routePattern := strings.Join(x.RoutePatterns, "")
+		x.routePatternJoined = strings.Replace(routePattern, "/*/", "/", -1)
+		x.routePatternN = len(x.RoutePatterns)
+	}
+	return x.routePatternJoined
+}
+
+// UserValuer is implemented by *fasthttp.RequestCtx, and by
+// *websocket.Conn which keeps the routing context of the upgraded request.
+type UserValuer interface {
+	UserValue(key interface{}) interface{}
 }
 
 // RouteContext returns phi's routing Context object from
-// *fasthttp.RequestCtx
-func RouteContext(ctx *fasthttp.RequestCtx) *Context {
-	return ctx.UserValue(RouteCtxKey).(*Context)
+// *fasthttp.RequestCtx or *websocket.Conn
+func RouteContext(ctx UserValuer) *Context {
+	return ctx.UserValue(routeCtxKey).(*Context)
 }
 
-// URLParam returns the url parameter from *fasthttp.RequestCtx
-func URLParam(ctx *fasthttp.RequestCtx, key string) string {
+// URLParam returns the url parameter from *fasthttp.RequestCtx or
+// *websocket.Conn, which is only valid until the request handler returns,
+// like ctx.Path(). Copy it to keep it longer.
+func URLParam(ctx UserValuer, key string) string {
 	if rctx := RouteContext(ctx); rctx != nil {
 		return rctx.URLParam(key)
 	}
@@ -110,6 +226,8 @@ func URLParam(ctx *fasthttp.RequestCtx, key string) string {
 }
 
 // RouteParams is a structure to track URL routing parameters efficiently.
+// The params matched by a router have values viewing the request path, and
+// are stored inline in the Context up to a few of them.
 type RouteParams struct {
 	Keys, Values []string
 }
@@ -122,6 +240,22 @@ func (s *RouteParams) Add(key, value string) {
 
 /*----------  Internal  ----------*/
 
+// b2s converts a byte slice to a string without copying it, the string is
+// only valid as long as the bytes aren't modified.
+func b2s(b []byte) string {
+	return *(*string)(unsafe.Pointer(&b))
+}
+
+// copyStrings returns a copy of ss whose strings don't share the memory
+// of the request, see b2s.
+func copyStrings(ss []string) []string {
+	out := make([]string, len(ss))
+	for i, s := range ss {
+		out[i] = string([]byte(s))
+	}
+	return out
+}
+
 // contextKey is used as key for setting value in ctx.SetUserValue
 // using contextKey rather than a plain string is to prevent collision with
 // used defined key
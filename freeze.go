@@ -0,0 +1,227 @@
+package phi
+
+import (
+	"errors"
+	"sort"
+)
+
+// ErrFrozen is returned by AddRoute, Replace and RemoveRoute once the
+// router is frozen.
+var ErrFrozen = errors.New("phi: the router is frozen")
+
+// Freeze compiles the routing tree of the router and of its mounted
+// sub-routers for serving: the routes without params are looked up in a
+// perfect hash table by method and path before searching the tree, and the
+// static children of the tree nodes are indexed by their first byte.
+//
+// No route can be registered on the router afterwards, registering one
+// panics and AddRoute, Replace and RemoveRoute fail with ErrFrozen. Freeze
+// is meant to be called once all the routes are set up, before serving.
+func (mx *Mux) Freeze() {
+	mx.tree.mu.Lock()
+	if !mx.tree.frozen {
+		root := mx.tree.load().clone()
+		root.flatten()
+		mx.tree.static.Store(newStaticTable(root))
+		mx.tree.root.Store(root)
+		mx.tree.frozen = true
+	}
+	mx.tree.mu.Unlock()
+
+	mx.updateSubRoutes(func(subMux *Mux) {
+		subMux.Freeze()
+	})
+}
+
+// flatten indexes the static children of the nodes of the tree rooted at
+// n by their label, see staticEdge.
+func (n *node) flatten() {
+	if nds := n.children[ntStatic]; len(nds) > 0 {
+		base := nds[0].label
+		n.edges = make(nodes, int(nds[len(nds)-1].label-base)+1)
+		for _, cn := range nds {
+			n.edges[cn.label-base] = cn
+		}
+		n.edgeBase = base
+	}
+	for _, nds := range n.children {
+		for _, cn := range nds {
+			cn.flatten()
+		}
+	}
+}
+
+// staticEdge returns the static child of n with the `label` first byte.
+func (n *node) staticEdge(label byte) *node {
+	if n.edges == nil {
+		return n.children[ntStatic].findEdge(label)
+	}
+	if label < n.edgeBase || int(label-n.edgeBase) >= len(n.edges) {
+		return nil
+	}
+	return n.edges[label-n.edgeBase]
+}
+
+// staticTable is a perfect hash table of the static routes of a frozen
+// routing tree: a key is hashed once to pick the seed of its bucket, which
+// is chosen so that the keys of the bucket hash to distinct free slots.
+type staticTable struct {
+	seeds []uint64
+	slots []staticRoute
+}
+
+// staticRoute is the endpoint of a method and path without params.
+type staticRoute struct {
+	method  methodTyp
+	path    string
+	pattern string
+	handler Handler
+}
+
+func newStaticTable(root *node) *staticTable {
+	var routes []staticRoute
+	root.staticRoutes("", func(r staticRoute) {
+		routes = append(routes, r)
+	})
+	if len(routes) == 0 {
+		return nil
+	}
+
+	// The slots are grown until every bucket finds a seed, which rarely
+	// takes more than one attempt
+	nslots := nextPow2(len(routes))
+	for {
+		if t := buildStaticTable(routes, nslots); t != nil {
+			return t
+		}
+		nslots *= 2
+	}
+}
+
+func buildStaticTable(routes []staticRoute, nslots int) *staticTable {
+	t := &staticTable{
+		seeds: make([]uint64, nextPow2((len(routes)+3)/4)),
+		slots: make([]staticRoute, nslots),
+	}
+
+	buckets := make([][]staticRoute, len(t.seeds))
+	for _, r := range routes {
+		b := staticHash(0, r.method, r.path) & uint64(len(t.seeds)-1)
+		buckets[b] = append(buckets[b], r)
+	}
+
+	// Place the largest buckets first, while most slots are free
+	order := make([]int, len(buckets))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(buckets[order[i]]) > len(buckets[order[j]])
+	})
+
+	used := make([]bool, nslots)
+	taken := make([]int, 0, 8)
+	for _, b := range order {
+		bucket := buckets[b]
+		if len(bucket) == 0 {
+			break
+		}
+
+		placed := false
+		for seed := uint64(1); seed < 1<<16 && !placed; seed++ {
+			taken = taken[:0]
+			placed = true
+			for _, r := range bucket {
+				s := int(staticHash(seed, r.method, r.path) & uint64(nslots-1))
+				if used[s] {
+					placed = false
+					break
+				}
+				used[s] = true
+				taken = append(taken, s)
+			}
+			if !placed {
+				for _, s := range taken {
+					used[s] = false
+				}
+				continue
+			}
+			for i, r := range bucket {
+				t.slots[taken[i]] = r
+			}
+			t.seeds[b] = seed
+		}
+		if !placed {
+			return nil
+		}
+	}
+	return t
+}
+
+// lookup returns the route of the `method` and `path`, if it's static.
+func (t *staticTable) lookup(method methodTyp, path string) *staticRoute {
+	seed := t.seeds[staticHash(0, method, path)&uint64(len(t.seeds)-1)]
+	if seed == 0 {
+		return nil
+	}
+	r := &t.slots[staticHash(seed, method, path)&uint64(len(t.slots)-1)]
+	if r.method != method || r.path != path {
+		return nil
+	}
+	return r
+}
+
+// FindRoute routes the static routes of the table like node.FindRoute.
+func (t *staticTable) FindRoute(rctx *Context, method methodTyp, path string) Handler {
+	r := t.lookup(method, path)
+	if r == nil {
+		return nil
+	}
+	rctx.routePattern = r.pattern
+	rctx.routeParams.Keys = rctx.routeParams.Keys[:0]
+	rctx.routeParams.Values = rctx.routeParams.Values[:0]
+	rctx.routeWildcard = ""
+	rctx.RoutePatterns = append(rctx.RoutePatterns, r.pattern)
+	return r.handler
+}
+
+// staticRoutes calls fn with the routes of the tree rooted at n which are
+// only made of static nodes, except for the versioned ones and the shorter
+// forms of patterns with optional params.
+func (n *node) staticRoutes(prefix string, fn func(r staticRoute)) {
+	path := prefix + n.prefix
+	for m, e := range n.endpoints {
+		if m == mSTUB || m == mALL || e.handler == nil || e.pattern == "" || len(e.versions) > 0 || len(e.defaults.Keys) > 0 {
+			continue
+		}
+		fn(staticRoute{method: m, path: path, pattern: e.pattern, handler: e.handler})
+	}
+	for _, cn := range n.children[ntStatic] {
+		cn.staticRoutes(path, fn)
+	}
+}
+
+// staticHash is the 64-bit FNV-1a hash of the method and path, with the
+// seed mixed in and a final avalanche so that each seed hashes differently.
+func staticHash(seed uint64, method methodTyp, path string) uint64 {
+	const prime64 = 1099511628211
+	h := uint64(14695981039346656037) ^ seed
+	h ^= uint64(method)
+	h *= prime64
+	for i := 0; i < len(path); i++ {
+		h ^= uint64(path[i])
+		h *= prime64
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	return h
+}
+
+func nextPow2(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
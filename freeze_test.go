@@ -0,0 +1,146 @@
+package phi
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gavv/httpexpect"
+	"github.com/valyala/fasthttp"
+)
+
+func TestMuxFreeze(t *testing.T) {
+	pattern := func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString(RouteContext(ctx).RoutePattern() + " " + URLParam(ctx, "id"))
+	}
+
+	sub := NewRouter()
+	sub.Get("/", pattern)
+	sub.Get("/list", pattern)
+
+	r := NewRouter()
+	r.Get("/", pattern)
+	r.Get("/users", pattern)
+	r.Post("/users", pattern)
+	r.Get("/users/list", pattern)
+	r.Get("/users/{id}", pattern)
+	r.Get("/search/{id?}", pattern)
+	r.Version("2").Get("/versioned", pattern)
+	r.Handle("/all", pattern)
+	r.Mount("/sub", sub)
+
+	check := func(e *httpexpect.Expect) {
+		e.GET("/").Expect().Status(200).Text().Equal("/ ")
+		e.GET("/users").Expect().Status(200).Text().Equal("/users ")
+		e.POST("/users").Expect().Status(200).Text().Equal("/users ")
+		e.DELETE("/users").Expect().Status(405)
+		e.GET("/users/list").Expect().Status(200).Text().Equal("/users/list ")
+		e.GET("/users/1").Expect().Status(200).Text().Equal("/users/{id} 1")
+		e.GET("/users/lis").Expect().Status(200).Text().Equal("/users/{id} lis")
+		e.GET("/search").Expect().Status(200).Text().Equal("/search/{id?} ")
+		e.GET("/all").Expect().Status(200).Text().Equal("/all ")
+		e.PUT("/all").Expect().Status(200).Text().Equal("/all ")
+		e.GET("/sub").Expect().Status(200).Text().Equal("/sub/ ")
+		e.GET("/sub/list").Expect().Status(200).Text().Equal("/sub/list ")
+		e.GET("/nothing").Expect().Status(404)
+	}
+
+	e := newFastHTTPTester(t, r)
+	check(e)
+	r.Freeze()
+	check(e)
+
+	if st := r.tree.staticTable(); st == nil || st.lookup(mGET, "/users/list") == nil || st.lookup(mGET, "/search") != nil {
+		t.Fatal("expected the static routes in the table")
+	}
+	if st := sub.tree.staticTable(); st == nil || st.lookup(mGET, "/list") == nil {
+		t.Fatal("expected the mounted router to be frozen")
+	}
+
+	if err := r.AddRoute("GET", "/new", pattern); err != ErrFrozen {
+		t.Fatalf("expected ErrFrozen, got %v", err)
+	}
+	if err := r.RemoveRoute("GET", "/users"); err != ErrFrozen {
+		t.Fatalf("expected ErrFrozen, got %v", err)
+	}
+	func() {
+		defer func() {
+			if rec := recover(); rec == nil || !strings.Contains(fmt.Sprint(rec), "frozen") {
+				t.Fatalf("expected a panic, got %v", rec)
+			}
+		}()
+		r.Group(func(r Router) {
+			r.Get("/new", pattern)
+		})
+	}()
+}
+
+func TestStaticTable(t *testing.T) {
+	tr := &node{}
+	for i := 0; i < 5000; i++ {
+		tr.InsertRoute(mGET, fmt.Sprintf("/api/resource%d/items", i), newStub())
+		if i%3 == 0 {
+			tr.InsertRoute(mPOST, fmt.Sprintf("/api/resource%d/items", i), newStub())
+		}
+	}
+	st := newStaticTable(tr)
+
+	for i := 0; i < 5000; i++ {
+		path := fmt.Sprintf("/api/resource%d/items", i)
+		if r := st.lookup(mGET, path); r == nil || r.path != path {
+			t.Fatalf("expected GET %s in the table", path)
+		}
+		if r := st.lookup(mPOST, path); (r != nil) != (i%3 == 0) {
+			t.Fatalf("unexpected lookup of POST %s", path)
+		}
+		if st.lookup(mGET, path+"/") != nil {
+			t.Fatalf("unexpected lookup of GET %s/", path)
+		}
+	}
+}
+
+// staticMux is a router with many static routes, along with the routes of
+// bigMux.
+func staticMux() *Mux {
+	r := bigMux().(*Mux)
+	h := func(ctx *fasthttp.RequestCtx) {}
+	for i := 0; i < 1000; i++ {
+		r.Get(fmt.Sprintf("/api/v1/resource%d/items/list", i), h)
+		r.Get(fmt.Sprintf("/api/v1/resource%d/items/{id}", i), h)
+	}
+	return r
+}
+
+func BenchmarkMuxFreeze(b *testing.B) {
+	paths := map[string]string{
+		"static": "/api/v1/resource512/items/list",
+		"param":  "/api/v1/resource512/items/42",
+		"mount":  "/user/",
+	}
+
+	for _, frozen := range []bool{false, true} {
+		r := staticMux()
+		name := "tree"
+		if frozen {
+			r.Freeze()
+			name = "frozen"
+		}
+
+		for path, uri := range paths {
+			b.Run(name+"/"+path, func(b *testing.B) {
+				ctx := &fasthttp.RequestCtx{}
+				ctx.Request.Header.SetMethod("GET")
+				ctx.Request.SetRequestURI(uri)
+
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					ctx.ResetUserValues()
+					ctx.Response.ResetBody()
+					r.ServeFastHTTP(ctx)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package jwtauth
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// Claims is the set of claims of a verified token. Numbers are decoded as
+// json.Number, use the typed getters to read them.
+type Claims map[string]interface{}
+
+// String returns the claim `key` if it's a string.
+func (c Claims) String(key string) string {
+	s, _ := c[key].(string)
+	return s
+}
+
+// Subject returns the "sub" claim.
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+// Issuer returns the "iss" claim.
+func (c Claims) Issuer() string {
+	return c.String("iss")
+}
+
+// Audience returns the "aud" claim, which may be a single string or a list
+// of strings.
+func (c Claims) Audience() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		list := make([]string, 0, len(aud))
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// ExpiresAt returns the "exp" claim, or the zero time if it's missing.
+func (c Claims) ExpiresAt() time.Time {
+	t, _, _ := c.Time("exp")
+	return t
+}
+
+// NotBefore returns the "nbf" claim, or the zero time if it's missing.
+func (c Claims) NotBefore() time.Time {
+	t, _, _ := c.Time("nbf")
+	return t
+}
+
+// IssuedAt returns the "iat" claim, or the zero time if it's missing.
+func (c Claims) IssuedAt() time.Time {
+	t, _, _ := c.Time("iat")
+	return t
+}
+
+// Time reads the NumericDate claim `key`. It reports whether the claim is
+// present, and fails if it isn't a number or is out of range.
+func (c Claims) Time(key string) (time.Time, bool, error) {
+	v, ok := c[key]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	var secs float64
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		if err != nil {
+			return time.Time{}, true, ErrInvalidClaim
+		}
+		secs = f
+	case float64:
+		secs = n
+	default:
+		return time.Time{}, true, ErrInvalidClaim
+	}
+	if math.IsNaN(secs) || math.Abs(secs) > maxNumericDate {
+		return time.Time{}, true, ErrInvalidClaim
+	}
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(frac*1e9)), true, nil
+}
+
+// maxNumericDate is the largest NumericDate accepted, 9999-12-31T23:59:59Z.
+const maxNumericDate = 253402300799
+
+// validate checks the registered time, issuer and audience claims.
+func (c Claims) validate(cfg *Config, now time.Time) error {
+	exp, ok, err := c.Time("exp")
+	if err != nil {
+		return err
+	}
+	if ok && !now.Before(exp.Add(cfg.Leeway)) {
+		return ErrExpired
+	}
+	if !ok && cfg.RequireExpiration {
+		return ErrMissingExpiration
+	}
+
+	nbf, ok, err := c.Time("nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(cfg.Leeway).Before(nbf) {
+		return ErrNotYetValid
+	}
+
+	if cfg.Issuer != "" && c.Issuer() != cfg.Issuer {
+		return ErrIssuer
+	}
+
+	if cfg.Audience != "" {
+		found := false
+		for _, aud := range c.Audience() {
+			if aud == cfg.Audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrAudience
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package jwtauth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Supported signing algorithms.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// Token errors.
+var (
+	ErrNoToken      = errors.New("jwtauth: no token found")
+	ErrMalformed    = errors.New("jwtauth: malformed token")
+	ErrAlgorithm    = errors.New("jwtauth: unsupported signing algorithm")
+	ErrUnknownKey   = errors.New("jwtauth: no key to verify the token")
+	ErrSignature    = errors.New("jwtauth: invalid token signature")
+	ErrExpired      = errors.New("jwtauth: token is expired")
+	ErrNotYetValid  = errors.New("jwtauth: token is not valid yet")
+	ErrIssuer       = errors.New("jwtauth: invalid token issuer")
+	ErrAudience     = errors.New("jwtauth: invalid token audience")
+	ErrInvalidClaim = errors.New("jwtauth: invalid registered claim")
+
+	ErrMissingExpiration = errors.New("jwtauth: token has no expiration")
+)
+
+// header is the JOSE header of a token.
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// parseToken splits and decodes a compact serialized JWS, verifies its
+// signature with one of the keys and returns its claims.
+func parseToken(token string, keys KeySet) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var hdr header
+	if err := decodeSegment(parts[0], &hdr); err != nil {
+		return nil, ErrMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	switch hdr.Alg {
+	case HS256, RS256, ES256, EdDSA:
+	default:
+		return nil, ErrAlgorithm
+	}
+
+	candidates, err := keys.LookupKeys(hdr.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only keys bound to the token algorithm are tried, which rules out
+	// algorithm confusion attacks like verifying RS256 tokens as HS256.
+	signed := []byte(token[:len(parts[0])+1+len(parts[1])])
+	verified, matched := false, false
+	for _, k := range candidates {
+		if k.Algorithm != hdr.Alg {
+			continue
+		}
+		matched = true
+		if verify(hdr.Alg, k.Key, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !matched {
+		return nil, ErrUnknownKey
+	}
+	if !verified {
+		return nil, ErrSignature
+	}
+
+	claims := Claims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrMalformed
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// verify checks the signature of `signed` made with `alg` by key.
+func verify(alg string, key interface{}, signed, sig []byte) bool {
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(sig, mac.Sum(nil))
+
+	case RS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		sum := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+
+	case ES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		// JWS encodes ECDSA signatures as the fixed size R || S pair.
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		sum := sha256.Sum256(signed)
+		return ecdsa.Verify(pub, sum[:], r, s)
+
+	case EdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok || len(pub) != ed25519.PublicKeySize {
+			return false
+		}
+		return ed25519.Verify(pub, signed, sig)
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+// Package jwtauth provides a phi middleware verifying JSON Web Tokens
+// without any external service.
+//
+// Tokens signed with HS256, RS256, ES256 or EdDSA are verified against a
+// static key set, or a local JWKS file which is reloaded when it changes.
+// The registered exp, nbf, iss and aud claims are validated with some
+// clock skew allowance, and the verified claims are made available to
+// handlers:
+//
+//  keys, _ := jwtauth.NewJWKSFile("/etc/app/jwks.json", time.Minute)
+//  r.Use(jwtauth.Verifier(jwtauth.Config{
+//    Keys:     keys,
+//    Issuer:   "https://auth.example.com",
+//    Audience: "api",
+//    Leeway:   30 * time.Second,
+//  }))
+//  r.Get("/me", func(ctx *fasthttp.RequestCtx) {
+//    claims, _ := jwtauth.ClaimsFromContext(ctx)
+//    ctx.WriteString(claims.Subject())
+//  })
+package jwtauth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	// ClaimsCtxKey is the context key holding the verified Claims.
+	ClaimsCtxKey = (&contextKey{"Claims"}).String()
+)
+
+// Config configures the Verifier middleware.
+type Config struct {
+	// Keys provides the keys tokens are verified with. Required.
+	Keys KeySet
+
+	// Issuer, when set, must match the "iss" claim.
+	Issuer string
+
+	// Audience, when set, must be one of the "aud" claim values.
+	Audience string
+
+	// Leeway is the clock skew allowed when checking "exp" and "nbf".
+	Leeway time.Duration
+
+	// RequireExpiration rejects tokens without an "exp" claim, with
+	// ErrMissingExpiration.
+	RequireExpiration bool
+
+	// Cookie and Query name a cookie and a query argument the token is
+	// looked up in, after the `Authorization: Bearer` header.
+	Cookie string
+	Query  string
+
+	// ErrorHandler responds to requests whose token is missing or invalid.
+	// The default responds with a 401 and a Bearer challenge.
+	ErrorHandler func(ctx *fasthttp.RequestCtx, err error)
+
+	// Now returns the current time, time.Now by default.
+	Now func() time.Time
+}
+
+// Verifier returns a middleware which looks up, verifies and validates
+// the request token, and stores its claims in the request context.
+func Verifier(cfg Config) phi.Middleware {
+	if cfg.Keys == nil {
+		panic("jwtauth: Verifier requires a KeySet")
+	}
+	if cfg.ErrorHandler == nil {
+		cfg.ErrorHandler = unauthorized
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	return func(next phi.HandlerFunc) phi.HandlerFunc {
+		return func(ctx *fasthttp.RequestCtx) {
+			claims, err := cfg.Verify(findToken(ctx, &cfg))
+			if err != nil {
+				cfg.ErrorHandler(ctx, err)
+				return
+			}
+			ctx.SetUserValue(ClaimsCtxKey, claims)
+			next(ctx)
+		}
+	}
+}
+
+// Verify verifies and validates `token` as configured, and returns its
+// claims.
+func (cfg *Config) Verify(token string) (Claims, error) {
+	if token == "" {
+		return nil, ErrNoToken
+	}
+	claims, err := parseToken(token, cfg.Keys)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now
+	if cfg.Now != nil {
+		now = cfg.Now
+	}
+	if err := claims.validate(cfg, now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// ClaimsFromContext returns the claims of the token verified for the
+// request.
+func ClaimsFromContext(ctx *fasthttp.RequestCtx) (Claims, bool) {
+	claims, ok := ctx.UserValue(ClaimsCtxKey).(Claims)
+	return claims, ok
+}
+
+// findToken looks the token up in the Authorization header, then in the
+// configured cookie and query argument.
+func findToken(ctx *fasthttp.RequestCtx, cfg *Config) string {
+	const prefix = "Bearer "
+	auth := string(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization))
+	if len(auth) > len(prefix) && strings.EqualFold(auth[:len(prefix)], prefix) {
+		return strings.TrimSpace(auth[len(prefix):])
+	}
+	if cfg.Cookie != "" {
+		if v := ctx.Request.Header.Cookie(cfg.Cookie); len(v) > 0 {
+			return string(v)
+		}
+	}
+	if cfg.Query != "" {
+		if v := ctx.QueryArgs().Peek(cfg.Query); len(v) > 0 {
+			return string(v)
+		}
+	}
+	return ""
+}
+
+func unauthorized(ctx *fasthttp.RequestCtx, err error) {
+	ctx.Error(fasthttp.StatusMessage(fasthttp.StatusUnauthorized), fasthttp.StatusUnauthorized)
+	if err == ErrNoToken {
+		ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, `Bearer`)
+	} else {
+		ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+	}
+}
+
+// contextKey names the user values set by jwtauth, i.e. ClaimsCtxKey. It
+// formats to a prefixed string, so the verified claims can't be clobbered
+// by a user value of the application.
+type contextKey struct {
+	name string
+}
+
+func (k *contextKey) String() string {
+	return "jwtauth context value " + k.name
+}
@@ -0,0 +1,278 @@
+package jwtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fate-lovely/phi"
+	"github.com/gavv/httpexpect"
+	"github.com/valyala/fasthttp"
+)
+
+func TestVerifierAlgorithms(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	secret := []byte("s3cr3t")
+
+	keys := StaticKeys{
+		{ID: "hs", Algorithm: HS256, Key: secret},
+		{ID: "rs", Algorithm: RS256, Key: &rsaKey.PublicKey},
+		{ID: "es", Algorithm: ES256, Key: &ecKey.PublicKey},
+		{ID: "ed", Algorithm: EdDSA, Key: edPub},
+	}
+	cfg := Config{Keys: keys}
+	claims := map[string]interface{}{"sub": "alice"}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"hs256", sign(HS256, "hs", secret, claims), nil},
+		{"rs256", sign(RS256, "rs", rsaKey, claims), nil},
+		{"es256", sign(ES256, "es", ecKey, claims), nil},
+		{"eddsa", sign(EdDSA, "ed", edKey, claims), nil},
+		{"no kid", sign(ES256, "", ecKey, claims), nil},
+		{"wrong secret", sign(HS256, "hs", []byte("nope"), claims), ErrSignature},
+		{"unknown kid", sign(HS256, "zz", secret, claims), ErrUnknownKey},
+		// an RS256 public key must never be usable as an HS256 secret
+		{"alg confusion", sign(HS256, "rs", []byte("whatever"), claims), ErrUnknownKey},
+		{"none", "eyJhbGciOiJub25lIn0.eyJzdWIiOiJhbGljZSJ9.", ErrAlgorithm},
+		{"malformed", "abc.def", ErrMalformed},
+		{"empty", "", ErrNoToken},
+	}
+
+	for _, tt := range tests {
+		c, err := cfg.Verify(tt.token)
+		if err != tt.err {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.err, err)
+			continue
+		}
+		if err == nil && c.Subject() != "alice" {
+			t.Errorf("%s: expected subject alice, got %q", tt.name, c.Subject())
+		}
+	}
+}
+
+func TestVerifierClaims(t *testing.T) {
+	secret := []byte("s3cr3t")
+	now := time.Unix(1500000000, 0)
+	cfg := Config{
+		Keys:     StaticKeys{{Algorithm: HS256, Key: secret}},
+		Issuer:   "auth",
+		Audience: "api",
+		Leeway:   30 * time.Second,
+		Now:      func() time.Time { return now },
+	}
+	at := func(d time.Duration) int64 { return now.Add(d).Unix() }
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		err    error
+	}{
+		{"valid", map[string]interface{}{"iss": "auth", "aud": "api", "exp": at(time.Minute)}, nil},
+		{"aud list", map[string]interface{}{"iss": "auth", "aud": []string{"web", "api"}}, nil},
+		{"expired within leeway", map[string]interface{}{"iss": "auth", "aud": "api", "exp": at(-10 * time.Second)}, nil},
+		{"expired", map[string]interface{}{"iss": "auth", "aud": "api", "exp": at(-time.Minute)}, ErrExpired},
+		{"nbf within leeway", map[string]interface{}{"iss": "auth", "aud": "api", "nbf": at(10 * time.Second)}, nil},
+		{"not yet valid", map[string]interface{}{"iss": "auth", "aud": "api", "nbf": at(time.Minute)}, ErrNotYetValid},
+		{"bad exp", map[string]interface{}{"iss": "auth", "aud": "api", "exp": "tomorrow"}, ErrInvalidClaim},
+		{"far future exp", map[string]interface{}{"iss": "auth", "aud": "api", "exp": 9999999999}, nil},
+		{"out of range exp", map[string]interface{}{"iss": "auth", "aud": "api", "exp": 1e300}, ErrInvalidClaim},
+		{"wrong issuer", map[string]interface{}{"iss": "evil", "aud": "api"}, ErrIssuer},
+		{"wrong audience", map[string]interface{}{"iss": "auth", "aud": "web"}, ErrAudience},
+	}
+
+	for _, tt := range tests {
+		if _, err := cfg.Verify(sign(HS256, "", secret, tt.claims)); err != tt.err {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	strict := cfg
+	strict.RequireExpiration = true
+	if _, err := strict.Verify(sign(HS256, "", secret, map[string]interface{}{"iss": "auth", "aud": "api"})); err != ErrMissingExpiration {
+		t.Errorf("missing exp: expected error %v, got %v", ErrMissingExpiration, err)
+	}
+
+	c, err := cfg.Verify(sign(HS256, "", secret, map[string]interface{}{"iss": "auth", "aud": "api", "exp": at(time.Minute)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.ExpiresAt().Equal(now.Add(time.Minute)) {
+		t.Errorf("unexpected exp %v", c.ExpiresAt())
+	}
+
+	c = Claims{"exp": 9999999999.5, "nbf": math.NaN(), "iat": math.Inf(1)}
+	if exp := c.ExpiresAt(); !exp.Equal(time.Unix(9999999999, 5e8)) {
+		t.Errorf("unexpected far future exp %v", exp)
+	}
+	for _, key := range []string{"nbf", "iat"} {
+		if _, _, err := c.Time(key); err != ErrInvalidClaim {
+			t.Errorf("%s: expected error %v, got %v", key, ErrInvalidClaim, err)
+		}
+	}
+}
+
+func TestVerifierMiddleware(t *testing.T) {
+	secret := []byte("s3cr3t")
+	r := phi.NewRouter()
+	r.Use(Verifier(Config{
+		Keys:   StaticKeys{{Algorithm: HS256, Key: secret}},
+		Cookie: "jwt",
+		Query:  "token",
+	}))
+	r.Get("/", func(ctx *fasthttp.RequestCtx) {
+		claims, _ := ClaimsFromContext(ctx)
+		ctx.WriteString(claims.Subject())
+	})
+
+	token := sign(HS256, "", secret, map[string]interface{}{"sub": "alice"})
+
+	e := newFastHTTPTester(t, r)
+	e.GET("/").Expect().Status(401).Header("WWW-Authenticate").Equal("Bearer")
+	e.GET("/").WithHeader("Authorization", "Bearer x.y.z").
+		Expect().Status(401).Header("WWW-Authenticate").Equal(`Bearer error="invalid_token"`)
+	e.GET("/").WithHeader("Authorization", "Bearer "+token).Expect().Status(200).Text().Equal("alice")
+	e.GET("/").WithCookie("jwt", token).Expect().Status(200).Text().Equal("alice")
+	e.GET("/").WithQuery("token", token).Expect().Status(200).Text().Equal("alice")
+}
+
+func TestJWKSFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwtauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	writeJWKS := func(modTime time.Time, keys ...map[string]string) {
+		data, _ := json.Marshal(map[string]interface{}{"keys": keys})
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+	rsaJWK := map[string]string{
+		"kty": "RSA", "kid": "rs", "use": "sig",
+		"n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+	}
+	ecJWK := map[string]string{
+		"kty": "EC", "kid": "es", "crv": "P-256",
+		"x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes()),
+	}
+	edJWK := map[string]string{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(edPub)}
+	octJWK := map[string]string{"kty": "oct", "kid": "hs", "k": b64([]byte("s3cr3t"))}
+
+	writeJWKS(time.Unix(1000, 0), rsaJWK, ecJWK)
+
+	now := time.Now()
+	keys, err := NewJWKSFile(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.now = func() time.Time { return now }
+	cfg := Config{Keys: keys}
+
+	claims := map[string]interface{}{"sub": "alice"}
+	if _, err := cfg.Verify(sign(RS256, "rs", rsaKey, claims)); err != nil {
+		t.Errorf("rs256: %v", err)
+	}
+	if _, err := cfg.Verify(sign(ES256, "es", ecKey, claims)); err != nil {
+		t.Errorf("es256: %v", err)
+	}
+	if _, err := cfg.Verify(sign(EdDSA, "ed", edKey, claims)); err != ErrUnknownKey {
+		t.Errorf("eddsa: expected unknown key, got %v", err)
+	}
+
+	// Rotate keys: they're only picked up once the cache expires.
+	writeJWKS(time.Unix(2000, 0), edJWK, octJWK)
+	if _, err := cfg.Verify(sign(EdDSA, "ed", edKey, claims)); err != ErrUnknownKey {
+		t.Errorf("eddsa before expiry: expected unknown key, got %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := cfg.Verify(sign(EdDSA, "ed", edKey, claims)); err != nil {
+		t.Errorf("eddsa after reload: %v", err)
+	}
+	if _, err := cfg.Verify(sign(HS256, "hs", []byte("s3cr3t"), claims)); err != nil {
+		t.Errorf("hs256 after reload: %v", err)
+	}
+	if _, err := cfg.Verify(sign(RS256, "rs", rsaKey, claims)); err != ErrUnknownKey {
+		t.Errorf("rs256 after reload: expected unknown key, got %v", err)
+	}
+
+	// A broken file keeps the previous keys in service.
+	os.Remove(path)
+	now = now.Add(2 * time.Minute)
+	if _, err := cfg.Verify(sign(EdDSA, "ed", edKey, claims)); err != nil {
+		t.Errorf("eddsa with missing file: %v", err)
+	}
+}
+
+// sign creates a compact serialized token, it's only needed by tests.
+func sign(alg, kid string, key interface{}, claims map[string]interface{}) string {
+	hdr := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		hdr["kid"] = kid
+	}
+	h, _ := json.Marshal(hdr)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	var sig []byte
+	sum := sha256.Sum256([]byte(signed))
+	switch alg {
+	case HS256:
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case RS256:
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, sum[:])
+	case ES256:
+		r, s, _ := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), sum[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case EdDSA:
+		sig = ed25519.Sign(key.(ed25519.PrivateKey), []byte(signed))
+	default:
+		panic(fmt.Sprintf("unknown alg %s", alg))
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newFastHTTPTester(t *testing.T, h phi.Handler) *httpexpect.Expect {
+	return httpexpect.WithConfig(httpexpect.Config{
+		// Pass requests directly to FastHTTPHandler.
+		Client: &http.Client{
+			Transport: httpexpect.NewFastBinder(fasthttp.RequestHandler(h.ServeFastHTTP)),
+			Jar:       httpexpect.NewJar(),
+		},
+		// Report errors using testify.
+		Reporter: httpexpect.NewAssertReporter(t),
+	})
+}
@@ -0,0 +1,237 @@
+package jwtauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// Key is a verification key bound to a signing algorithm.
+//
+// The Key value depends on the algorithm: a []byte secret for HS256,
+// a *rsa.PublicKey for RS256, a *ecdsa.PublicKey on the P-256 curve for ES256
+// and an ed25519.PublicKey for EdDSA.
+type Key struct {
+	ID        string
+	Algorithm string
+	Key       interface{}
+}
+
+// KeySet provides the keys used to verify tokens.
+type KeySet interface {
+	// LookupKeys returns the keys matching the token key ID `kid`, or every
+	// key when `kid` is empty.
+	LookupKeys(kid string) ([]Key, error)
+}
+
+// StaticKeys is a fixed KeySet.
+type StaticKeys []Key
+
+// LookupKeys implements KeySet.
+func (ks StaticKeys) LookupKeys(kid string) ([]Key, error) {
+	return filterKeys(ks, kid), nil
+}
+
+func filterKeys(keys []Key, kid string) []Key {
+	if kid == "" {
+		return keys
+	}
+	var found []Key
+	for _, k := range keys {
+		if k.ID == kid {
+			found = append(found, k)
+		}
+	}
+	return found
+}
+
+// JWKSFile is a KeySet loaded from a local JSON Web Key Set file (RFC 7517).
+//
+// Keys are cached for a TTL. Once it has expired, the next lookup checks
+// the file modification time and reloads the keys if the file changed, so
+// keys can be rotated by rewriting the file without restarting the server.
+type JWKSFile struct {
+	path string
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	keys    []Key
+	modTime time.Time
+	expires time.Time
+
+	// now is overridden in tests
+	now func() time.Time
+}
+
+// NewJWKSFile loads the key set at `path`, caching it for `ttl`.
+func NewJWKSFile(path string, ttl time.Duration) (*JWKSFile, error) {
+	f := &JWKSFile{path: path, ttl: ttl, now: time.Now}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// LookupKeys implements KeySet.
+func (f *JWKSFile) LookupKeys(kid string) ([]Key, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.now().Before(f.expires) {
+		// Keep serving the cached keys if the file became unreadable,
+		// a broken deploy shouldn't lock every user out.
+		f.reload() // nolint: errcheck
+	}
+	return filterKeys(f.keys, kid), nil
+}
+
+// reload reads the file again if it was modified, and resets the cache
+// expiration. It must be called with f.mu held, or before f is shared.
+func (f *JWKSFile) reload() error {
+	f.expires = f.now().Add(f.ttl)
+
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if f.keys != nil && fi.ModTime().Equal(f.modTime) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+	f.keys = keys
+	f.modTime = fi.ModTime()
+	return nil
+}
+
+// jwk is the JSON representation of a single key of a key set.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+
+	K string `json:"k"`
+	N string `json:"n"`
+	E string `json:"e"`
+	X string `json:"x"`
+	Y string `json:"y"`
+}
+
+// ParseJWKS parses a JSON Web Key Set. Keys meant for encryption, and key
+// types which aren't supported, are skipped.
+func ParseJWKS(data []byte) ([]Key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("jwtauth: invalid JWKS: %v", err)
+	}
+
+	keys := make([]Key, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.parse()
+		if err != nil {
+			return nil, err
+		}
+		if key.Key != nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (k jwk) parse() (Key, error) {
+	key := Key{ID: k.Kid, Algorithm: k.Alg}
+
+	switch k.Kty {
+	case "oct":
+		secret, err := decodeB64(k.K)
+		if err != nil {
+			return key, k.invalid()
+		}
+		key.Key = secret
+		if key.Algorithm == "" {
+			key.Algorithm = HS256
+		}
+
+	case "RSA":
+		n, err1 := decodeB64(k.N)
+		e, err2 := decodeB64(k.E)
+		if err1 != nil || err2 != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return key, k.invalid()
+		}
+		key.Key = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if key.Algorithm == "" {
+			key.Algorithm = RS256
+		}
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return key, nil
+		}
+		x, err1 := decodeB64(k.X)
+		y, err2 := decodeB64(k.Y)
+		if err1 != nil || err2 != nil {
+			return key, k.invalid()
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return key, k.invalid()
+		}
+		key.Key = pub
+		if key.Algorithm == "" {
+			key.Algorithm = ES256
+		}
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return key, nil
+		}
+		x, err := decodeB64(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return key, k.invalid()
+		}
+		key.Key = ed25519.PublicKey(x)
+		if key.Algorithm == "" {
+			key.Algorithm = EdDSA
+		}
+	}
+
+	return key, nil
+}
+
+func (k jwk) invalid() error {
+	return fmt.Errorf("jwtauth: invalid %s key '%s' in JWKS", k.Kty, k.Kid)
+}
+
+func decodeB64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
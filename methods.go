@@ -0,0 +1,204 @@
+package phi
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// methodTyp is the bit of an http method, routes registered for several
+// methods at once are keyed by their union, see endpoints.
+type methodTyp uint64
+
+const (
+	mSTUB methodTyp = 1 << iota
+	mCONNECT
+	mDELETE
+	mGET
+	mHEAD
+	mOPTIONS
+	mPATCH
+	mPOST
+	mPUT
+	mTRACE
+
+	// WebDAV methods, RFC 4918
+	mCOPY
+	mLOCK
+	mMKCOL
+	mMOVE
+	mPROPFIND
+	mPROPPATCH
+	mUNLOCK
+
+	// mALL is the methods every router supports
+	mALL = mCONNECT | mDELETE | mGET | mHEAD | mOPTIONS | mPATCH | mPOST | mPUT | mTRACE |
+		mCOPY | mLOCK | mMKCOL | mMOVE | mPROPFIND | mPROPPATCH | mUNLOCK
+)
+
+// methodBits holds the bits of the method names, the ones registered with
+// RegisterMethod follow the ones of mALL. It's copied on write to be read
+// without locking while serving requests.
+var (
+	methodBits   atomic.Value // map[string]methodTyp
+	methodBitsMu sync.Mutex
+
+	// methods supported by the routers created with NewMux
+	defaultMethods = uint64(mALL)
+)
+
+func init() {
+	methodBits.Store(map[string]methodTyp{
+		"CONNECT":   mCONNECT,
+		"DELETE":    mDELETE,
+		"GET":       mGET,
+		"HEAD":      mHEAD,
+		"OPTIONS":   mOPTIONS,
+		"PATCH":     mPATCH,
+		"POST":      mPOST,
+		"PUT":       mPUT,
+		"TRACE":     mTRACE,
+		"COPY":      mCOPY,
+		"LOCK":      mLOCK,
+		"MKCOL":     mMKCOL,
+		"MOVE":      mMOVE,
+		"PROPFIND":  mPROPFIND,
+		"PROPPATCH": mPROPPATCH,
+		"UNLOCK":    mUNLOCK,
+	})
+}
+
+// RegisterMethod registers new methods which can be used in `Router.Method`
+// calls of the routers created afterwards, see Mux.RegisterMethod. It's
+// safe for concurrent use.
+func RegisterMethod(method string) {
+	if method == "" {
+		return
+	}
+	mt := registerMethodTyp(strings.ToUpper(method))
+	for {
+		old := atomic.LoadUint64(&defaultMethods)
+		if atomic.CompareAndSwapUint64(&defaultMethods, old, old|uint64(mt)) {
+			return
+		}
+	}
+}
+
+// RegisterMethod registers a new method which can be used in `Router.Method`
+// calls of the router, of its inline routers and of the sub-routers created
+// with Route or mounted afterwards. Handle routes registered afterwards also
+// match it. It's safe for concurrent use.
+func (mx *Mux) RegisterMethod(method string) {
+	if method == "" {
+		return
+	}
+	mx.tree.addMethods(registerMethodTyp(strings.ToUpper(method)))
+}
+
+// registerMethodTyp returns the bit of the `method`, assigning it the next
+// free bit if it's a new one.
+func registerMethodTyp(method string) methodTyp {
+	methodBitsMu.Lock()
+	defer methodBitsMu.Unlock()
+
+	bits := methodBits.Load().(map[string]methodTyp)
+	if mt, ok := bits[method]; ok {
+		return mt
+	}
+
+	// mSTUB takes the first bit
+	n := len(bits) + 1
+	if n >= 64 {
+		panic(fmt.Sprintf("phi: max number of methods reached (%d)", 63))
+	}
+	mt := methodTyp(1) << uint(n)
+
+	next := make(map[string]methodTyp, len(bits)+1)
+	for m, t := range bits {
+		next[m] = t
+	}
+	next[method] = mt
+	methodBits.Store(next)
+	return mt
+}
+
+// lookupMethod returns the bit of the registered `method`.
+func lookupMethod(method string) (methodTyp, bool) {
+	mt, ok := methodBits.Load().(map[string]methodTyp)[method]
+	return mt, ok
+}
+
+// methodTypOf returns the methodTyp of the request `method`, switching on
+// the standard methods before looking up the registered ones.
+func methodTypOf(method []byte) (methodTyp, bool) {
+	switch string(method) {
+	case "GET":
+		return mGET, true
+	case "POST":
+		return mPOST, true
+	case "PUT":
+		return mPUT, true
+	case "DELETE":
+		return mDELETE, true
+	case "PATCH":
+		return mPATCH, true
+	case "HEAD":
+		return mHEAD, true
+	case "OPTIONS":
+		return mOPTIONS, true
+	case "CONNECT":
+		return mCONNECT, true
+	case "TRACE":
+		return mTRACE, true
+	}
+	mt, ok := methodBits.Load().(map[string]methodTyp)[string(method)]
+	return mt, ok
+}
+
+// methodTypString returns the name of the method bit, or "" if it isn't
+// a single registered method.
+func methodTypString(method methodTyp) string {
+	for s, t := range methodBits.Load().(map[string]methodTyp) {
+		if method == t {
+			return s
+		}
+	}
+	return ""
+}
+
+// eachMethod calls fn with each of the method bits of `methods`.
+func eachMethod(methods methodTyp, fn func(m methodTyp)) {
+	methods &^= mSTUB
+	for methods != 0 {
+		m := methods & -methods
+		fn(m)
+		methods &^= m
+	}
+}
+
+// methods returns the methods supported by the routers of the tree.
+func (t *routingTree) methods() methodTyp {
+	return methodTyp(atomic.LoadUint64(&t.methodSet))
+}
+
+// addMethods adds `methods` to the ones supported by the routers of the
+// tree.
+func (t *routingTree) addMethods(methods methodTyp) {
+	for {
+		old := atomic.LoadUint64(&t.methodSet)
+		if atomic.CompareAndSwapUint64(&t.methodSet, old, old|uint64(methods)) {
+			return
+		}
+	}
+}
+
+// method returns the bit of the `method` http method if the router
+// supports it.
+func (mx *Mux) method(method string) (methodTyp, bool) {
+	mt, ok := lookupMethod(strings.ToUpper(method))
+	if !ok || mx.tree.methods()&mt == 0 {
+		return 0, false
+	}
+	return mt, true
+}
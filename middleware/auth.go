@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	// PrincipalCtxKey is the context key holding the *Principal set by the
+	// authentication middlewares.
+	PrincipalCtxKey = (&contextKey{"Principal"}).String()
+)
+
+// Principal is the identity authenticated by BasicAuth, BearerToken or
+// APIKey.
+type Principal struct {
+	// Name identifies the principal, e.g. the basic auth username.
+	Name string
+
+	// Scheme is the authentication scheme used: "Basic", "Bearer" or
+	// "APIKey".
+	Scheme string
+
+	// Data holds anything attached by a verify function, like a user record.
+	Data interface{}
+}
+
+// GetPrincipal returns the principal authenticated for the request, if any.
+func GetPrincipal(ctx *fasthttp.RequestCtx) (*Principal, bool) {
+	p, ok := ctx.UserValue(PrincipalCtxKey).(*Principal)
+	return p, ok
+}
+
+// BasicAuth implements a simple middleware handler for adding HTTP Basic
+// Authentication to a route, checking against the `creds` username to
+// password map. Passwords are compared in constant time, unknown users'
+// included, so the response time doesn't tell which usernames exist.
+func BasicAuth(realm string, creds map[string]string) phi.Middleware {
+	return BasicAuthFunc(realm, func(user, pass string) bool {
+		credPass, ok := creds[user]
+		// The hashes have the same length whatever the passwords, and an
+		// unknown user is compared to the empty password.
+		got, want := sha256.Sum256([]byte(pass)), sha256.Sum256([]byte(credPass))
+		match := subtle.ConstantTimeCompare(got[:], want[:]) == 1
+		return ok && match
+	})
+}
+
+// BasicAuthFunc is like BasicAuth, but the credentials are checked by
+// `verify`, which should compare secrets in constant time.
+func BasicAuthFunc(realm string, verify func(user, pass string) bool) phi.Middleware {
+	challenge := fmt.Sprintf(`Basic realm="%s"`, quoteEscape(realm))
+
+	return func(next phi.HandlerFunc) phi.HandlerFunc {
+		return func(ctx *fasthttp.RequestCtx) {
+			user, pass, ok := basicCredentials(ctx)
+			if !ok || !verify(user, pass) {
+				unauthorized(ctx, challenge)
+				return
+			}
+
+			ctx.SetUserValue(PrincipalCtxKey, &Principal{Name: user, Scheme: "Basic"})
+			next(ctx)
+		}
+	}
+}
+
+// BearerToken is a middleware authenticating requests by an RFC 6750
+// `Authorization: Bearer <token>` header. The token is checked by `verify`,
+// which returns the authenticated principal. A copy of it is stored for the
+// request, and a nil principal is rejected.
+func BearerToken(realm string, verify func(token string) (*Principal, bool)) phi.Middleware {
+	challenge := fmt.Sprintf(`Bearer realm="%s"`, quoteEscape(realm))
+	invalid := challenge + `, error="invalid_token"`
+
+	return func(next phi.HandlerFunc) phi.HandlerFunc {
+		return func(ctx *fasthttp.RequestCtx) {
+			token, ok := bearerToken(ctx)
+			if !ok {
+				unauthorized(ctx, challenge)
+				return
+			}
+			p, ok := verify(token)
+			if !ok || p == nil {
+				unauthorized(ctx, invalid)
+				return
+			}
+
+			pp := *p
+			pp.Scheme = "Bearer"
+			ctx.SetUserValue(PrincipalCtxKey, &pp)
+			next(ctx)
+		}
+	}
+}
+
+// APIKeyConfig configures the APIKey middleware.
+type APIKeyConfig struct {
+	// Realm reported in the WWW-Authenticate challenge.
+	Realm string
+
+	// Header is the request header carrying the key, "X-API-Key" by default.
+	Header string
+
+	// Query is an optional query string argument carrying the key, it's
+	// only looked up when the header is missing.
+	Query string
+
+	// Verify checks the key and returns the authenticated principal. A copy
+	// of it is stored for the request, and a nil principal is rejected.
+	Verify func(key string) (*Principal, bool)
+}
+
+// APIKey is a middleware authenticating requests by an API key sent in a
+// header or in the query string.
+func APIKey(cfg APIKeyConfig) phi.Middleware {
+	if cfg.Header == "" {
+		cfg.Header = "X-API-Key"
+	}
+	if cfg.Verify == nil {
+		panic("phi/middleware: APIKey requires a Verify function")
+	}
+	challenge := fmt.Sprintf(`APIKey realm="%s"`, quoteEscape(cfg.Realm))
+
+	return func(next phi.HandlerFunc) phi.HandlerFunc {
+		return func(ctx *fasthttp.RequestCtx) {
+			key := ctx.Request.Header.Peek(cfg.Header)
+			if len(key) == 0 && cfg.Query != "" {
+				key = ctx.QueryArgs().Peek(cfg.Query)
+			}
+			if len(key) == 0 {
+				unauthorized(ctx, challenge)
+				return
+			}
+			p, ok := cfg.Verify(string(key))
+			if !ok || p == nil {
+				unauthorized(ctx, challenge)
+				return
+			}
+
+			pp := *p
+			pp.Scheme = "APIKey"
+			ctx.SetUserValue(PrincipalCtxKey, &pp)
+			next(ctx)
+		}
+	}
+}
+
+// basicCredentials parses the request Authorization header as basic
+// authentication credentials.
+func basicCredentials(ctx *fasthttp.RequestCtx) (string, string, bool) {
+	const prefix = "Basic "
+	auth := string(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization))
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	b, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	cred := string(b)
+	i := strings.IndexByte(cred, ':')
+	if i < 0 {
+		return "", "", false
+	}
+	return cred[:i], cred[i+1:], true
+}
+
+// bearerToken returns the token of a `Bearer` Authorization header.
+func bearerToken(ctx *fasthttp.RequestCtx) (string, bool) {
+	const prefix = "Bearer "
+	auth := string(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization))
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len(prefix):]), true
+}
+
+// quoteEscape escapes the backslashes and double quotes of `s`, to be
+// sent as a quoted-string (RFC 7230 section 3.2.6).
+func quoteEscape(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// unauthorized responds with a 401 status and the given authentication
+// challenge. Note ctx.Error resets the response headers, so the challenge
+// is set afterwards.
+func unauthorized(ctx *fasthttp.RequestCtx, challenge string) {
+	ctx.Error(fasthttp.StatusMessage(fasthttp.StatusUnauthorized), fasthttp.StatusUnauthorized)
+	ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, challenge)
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestBasicAuth(t *testing.T) {
+	r := phi.NewRouter()
+	r.Use(BasicAuth("admin", map[string]string{"alice": "secret"}))
+	r.Get("/", func(ctx *fasthttp.RequestCtx) {
+		p, _ := GetPrincipal(ctx)
+		ctx.WriteString(p.Scheme + ":" + p.Name)
+	})
+
+	basic := func(user, pass string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+	}
+
+	e := newFastHTTPTester(t, r)
+	e.GET("/").Expect().Status(401).Header("WWW-Authenticate").Equal(`Basic realm="admin"`)
+	e.GET("/").WithHeader("Authorization", basic("alice", "wrong")).Expect().Status(401)
+	e.GET("/").WithHeader("Authorization", basic("bob", "secret")).Expect().Status(401)
+	e.GET("/").WithHeader("Authorization", basic("bob", "")).Expect().Status(401)
+	e.GET("/").WithHeader("Authorization", "Basic !!!").Expect().Status(401)
+	e.GET("/").WithHeader("Authorization", basic("alice", "secret")).
+		Expect().Status(200).Text().Equal("Basic:alice")
+
+	r = phi.NewRouter()
+	r.Use(BasicAuth(`the "admin" \ realm`, nil))
+	r.Get("/", func(ctx *fasthttp.RequestCtx) {})
+	newFastHTTPTester(t, r).GET("/").Expect().Status(401).
+		Header("WWW-Authenticate").Equal(`Basic realm="the \"admin\" \\ realm"`)
+}
+
+func TestBearerToken(t *testing.T) {
+	r := phi.NewRouter()
+	r.Use(BearerToken("api", func(token string) (*Principal, bool) {
+		if token != "t0k3n" {
+			return nil, false
+		}
+		return &Principal{Name: "svc", Data: 42}, true
+	}))
+	r.Get("/", func(ctx *fasthttp.RequestCtx) {
+		p, _ := GetPrincipal(ctx)
+		ctx.WriteString(p.Scheme + ":" + p.Name)
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.GET("/").Expect().Status(401).Header("WWW-Authenticate").Equal(`Bearer realm="api"`)
+	e.GET("/").WithHeader("Authorization", "Bearer nope").
+		Expect().Status(401).Header("WWW-Authenticate").Equal(`Bearer realm="api", error="invalid_token"`)
+	e.GET("/").WithHeader("Authorization", "bearer t0k3n").
+		Expect().Status(200).Text().Equal("Bearer:svc")
+}
+
+func TestAPIKey(t *testing.T) {
+	r := phi.NewRouter()
+	r.Use(APIKey(APIKeyConfig{
+		Realm: "api",
+		Query: "api_key",
+		Verify: func(key string) (*Principal, bool) {
+			return &Principal{Name: "client"}, key == "k1"
+		},
+	}))
+	r.Get("/", func(ctx *fasthttp.RequestCtx) {
+		p, _ := GetPrincipal(ctx)
+		ctx.WriteString(p.Scheme + ":" + p.Name)
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.GET("/").Expect().Status(401).Header("WWW-Authenticate").Equal(`APIKey realm="api"`)
+	e.GET("/").WithHeader("X-API-Key", "k2").Expect().Status(401)
+	e.GET("/").WithHeader("X-API-Key", "k1").Expect().Status(200).Text().Equal("APIKey:client")
+	e.GET("/").WithQuery("api_key", "k1").Expect().Status(200).Text().Equal("APIKey:client")
+}
+
+func TestAuthSharedPrincipal(t *testing.T) {
+	shared := &Principal{Name: "svc"}
+	lookup := func(key string) (*Principal, bool) {
+		switch key {
+		case "k1":
+			return shared, true
+		case "nil":
+			return nil, true
+		}
+		return nil, false
+	}
+
+	r := phi.NewRouter()
+	r.With(BearerToken("api", lookup)).Get("/bearer", func(ctx *fasthttp.RequestCtx) {
+		p, _ := GetPrincipal(ctx)
+		ctx.WriteString(p.Scheme + ":" + p.Name)
+	})
+	r.With(APIKey(APIKeyConfig{Verify: lookup})).Get("/key", func(ctx *fasthttp.RequestCtx) {
+		p, _ := GetPrincipal(ctx)
+		ctx.WriteString(p.Scheme + ":" + p.Name)
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.GET("/bearer").WithHeader("Authorization", "Bearer k1").Expect().Status(200).Text().Equal("Bearer:svc")
+	e.GET("/key").WithHeader("X-API-Key", "k1").Expect().Status(200).Text().Equal("APIKey:svc")
+	if shared.Scheme != "" {
+		t.Fatalf("the principal returned by verify was modified: %+v", shared)
+	}
+
+	e.GET("/bearer").WithHeader("Authorization", "Bearer nil").Expect().Status(401)
+	e.GET("/key").WithHeader("X-API-Key", "nil").Expect().Status(401)
+}
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"io"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	// BodyStreamCtxKey is the context key holding the size limited request
+	// body stream installed by BodyLimit.
+	BodyStreamCtxKey = (&contextKey{"BodyStream"}).String()
+)
+
+// BodyLimitConfig configures the BodyLimit middleware.
+type BodyLimitConfig struct {
+	// Limit is the maximum number of request body bytes accepted.
+	Limit int64
+
+	// Handler is called when the request body exceeds Limit. The default
+	// handler responds with a 413 Request Entity Too Large.
+	Handler phi.HandlerFunc
+}
+
+// BodyLimit is a middleware that rejects requests whose body is larger than
+// `limit` bytes with a 413 status.
+//
+// fasthttp only offers a server wide MaxRequestBodySize, so set that to the
+// largest body any route accepts and use BodyLimit to tighten the limit per
+// route or per group:
+//
+//  r.Group(func(r phi.Router) {
+//    r.Use(middleware.BodyLimit(64 << 10))
+//    r.Post("/articles", createArticle)
+//  })
+//  r.With(middleware.BodyLimit(100 << 20)).Post("/upload", upload)
+//
+// Nested limits don't override each other, the smallest one always wins.
+func BodyLimit(limit int64) phi.Middleware {
+	return BodyLimitWithConfig(BodyLimitConfig{Limit: limit})
+}
+
+// BodyLimitWithConfig returns a BodyLimit middleware built from cfg.
+//
+// The Content-Length header is checked up front, before any body byte is
+// read. When the server runs with StreamRequestBody and the body length is
+// unknown (chunked encoding), the limit is enforced while reading: use
+// BodyStream to get a reader which fails with fasthttp.ErrBodyTooLarge
+// once the limit is crossed.
+func BodyLimitWithConfig(cfg BodyLimitConfig) phi.Middleware {
+	if cfg.Handler == nil {
+		cfg.Handler = errorHandler(fasthttp.StatusRequestEntityTooLarge)
+	}
+
+	return func(next phi.HandlerFunc) phi.HandlerFunc {
+		return func(ctx *fasthttp.RequestCtx) {
+			if cl := ctx.Request.Header.ContentLength(); cl > 0 && int64(cl) > cfg.Limit {
+				cfg.Handler(ctx)
+				return
+			}
+
+			if stream := BodyStream(ctx); stream != nil {
+				ctx.SetUserValue(BodyStreamCtxKey, &limitedReader{r: stream, n: cfg.Limit})
+			} else if int64(len(ctx.Request.Body())) > cfg.Limit {
+				cfg.Handler(ctx)
+				return
+			}
+
+			next(ctx)
+		}
+	}
+}
+
+// BodyStream returns the request body stream limited by the innermost
+// BodyLimit middleware. It falls back to ctx.RequestBodyStream() when no
+// limit is installed, and returns nil if the body isn't streamed.
+func BodyStream(ctx *fasthttp.RequestCtx) io.Reader {
+	if lr, ok := ctx.UserValue(BodyStreamCtxKey).(*limitedReader); ok {
+		return lr
+	}
+	return ctx.RequestBodyStream()
+}
+
+// limitedReader reads from r but fails with fasthttp.ErrBodyTooLarge
+// once more than n bytes have been read.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, fasthttp.ErrBodyTooLarge
+	}
+	// Read one byte more than allowed, so we can tell a body of exactly
+	// `n` bytes from an oversized one.
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n + int(l.n), fasthttp.ErrBodyTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestBodyLimit(t *testing.T) {
+	r := phi.NewRouter()
+	echo := func(ctx *fasthttp.RequestCtx) {
+		ctx.Write(ctx.PostBody())
+	}
+	r.Group(func(r phi.Router) {
+		r.Use(BodyLimit(8))
+		r.Post("/small", echo)
+	})
+	r.With(BodyLimit(16)).Post("/large", echo)
+	r.With(BodyLimitWithConfig(BodyLimitConfig{
+		Limit: 4,
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.SetStatusCode(413)
+			ctx.WriteString("too big")
+		},
+	})).Post("/custom", echo)
+
+	e := newFastHTTPTester(t, r)
+	e.POST("/small").WithText("12345678").Expect().Status(200).Text().Equal("12345678")
+	e.POST("/small").WithText("123456789").Expect().Status(413)
+	e.POST("/large").WithText("123456789").Expect().Status(200).Text().Equal("123456789")
+	e.POST("/large").WithText(strings.Repeat("x", 17)).Expect().Status(413)
+	e.POST("/custom").WithText("12345").Expect().Status(413).Text().Equal("too big")
+}
+
+func TestBodyLimitStream(t *testing.T) {
+	var readErr error
+	var body []byte
+	h := BodyLimit(8)(func(ctx *fasthttp.RequestCtx) {
+		body, readErr = ioutil.ReadAll(BodyStream(ctx))
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetBodyStream(strings.NewReader("12345678"), -1)
+	h(ctx)
+	if readErr != nil || string(body) != "12345678" {
+		t.Fatalf("expected full body, got %q (%v)", body, readErr)
+	}
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetBodyStream(strings.NewReader("123456789"), -1)
+	h(ctx)
+	if readErr != fasthttp.ErrBodyTooLarge {
+		t.Fatalf("expected ErrBodyTooLarge, got %v", readErr)
+	}
+	if string(body) != "12345678" {
+		t.Fatalf("expected body to be truncated at the limit, got %q", body)
+	}
+}
@@ -0,0 +1,250 @@
+package middleware
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	// ContentTypeCtxKey is the context key holding the response media type
+	// negotiated by the Produces middleware.
+	ContentTypeCtxKey = (&contextKey{"ContentType"}).String()
+)
+
+// AllowContentType enforces a whitelist of request Content-Types, otherwise
+// responds with a 415 Unsupported Media Type status. Requests without a body
+// are let through. See DescribeContentType to report the types through
+// Routes() too.
+func AllowContentType(contentTypes ...string) phi.Middleware {
+	allowed := make(map[string]struct{}, len(contentTypes))
+	for _, ctype := range contentTypes {
+		allowed[strings.TrimSpace(strings.ToLower(ctype))] = struct{}{}
+	}
+
+	unsupported := errorHandler(fasthttp.StatusUnsupportedMediaType)
+
+	return func(next phi.HandlerFunc) phi.HandlerFunc {
+		return func(ctx *fasthttp.RequestCtx) {
+			if !hasBody(ctx) {
+				next(ctx)
+				return
+			}
+
+			s := string(mediaType(ctx.Request.Header.ContentType()))
+			if _, ok := allowed[s]; ok {
+				next(ctx)
+				return
+			}
+
+			unsupported(ctx)
+		}
+	}
+}
+
+// AllowContentEncoding enforces a whitelist of request Content-Encodings,
+// otherwise responds with a 415 Unsupported Media Type status. Requests
+// without a body, or without a Content-Encoding, are let through. See
+// DescribeContentEncoding to report the encodings through Routes() too.
+func AllowContentEncoding(contentEncodings ...string) phi.Middleware {
+	allowed := make(map[string]struct{}, len(contentEncodings))
+	for _, encoding := range contentEncodings {
+		allowed[strings.TrimSpace(strings.ToLower(encoding))] = struct{}{}
+	}
+
+	unsupported := errorHandler(fasthttp.StatusUnsupportedMediaType)
+
+	return func(next phi.HandlerFunc) phi.HandlerFunc {
+		return func(ctx *fasthttp.RequestCtx) {
+			if !hasBody(ctx) {
+				next(ctx)
+				return
+			}
+
+			header := ctx.Request.Header.Peek(fasthttp.HeaderContentEncoding)
+			for _, encoding := range bytes.Split(header, []byte(",")) {
+				encoding = bytes.ToLower(bytes.TrimSpace(encoding))
+				if len(encoding) == 0 {
+					continue
+				}
+				if _, ok := allowed[string(encoding)]; !ok {
+					unsupported(ctx)
+					return
+				}
+			}
+
+			next(ctx)
+		}
+	}
+}
+
+// Produces negotiates the response media type against the request Accept
+// header, from the `contentTypes` the route can render, in order of server
+// preference. If none of them is acceptable, it responds with a 406 Not
+// Acceptable status. The negotiated type can be read with ContentType.
+//
+// A missing Accept header accepts anything, and the first content type is
+// selected. See DescribeProduces to report the types through Routes() too.
+func Produces(contentTypes ...string) phi.Middleware {
+	offers := make([]string, len(contentTypes))
+	for i, ctype := range contentTypes {
+		offers[i] = strings.TrimSpace(strings.ToLower(ctype))
+	}
+
+	notAcceptable := errorHandler(fasthttp.StatusNotAcceptable)
+
+	return func(next phi.HandlerFunc) phi.HandlerFunc {
+		return func(ctx *fasthttp.RequestCtx) {
+			ctype := negotiate(ctx.Request.Header.Peek(fasthttp.HeaderAccept), offers)
+			if ctype == "" {
+				notAcceptable(ctx)
+				return
+			}
+
+			ctx.SetUserValue(ContentTypeCtxKey, ctype)
+			next(ctx)
+		}
+	}
+}
+
+// DescribeContentType is AllowContentType for phi's Describe: it records
+// the `contentTypes` as the RouteMeta.Consumes of the routes, reported by
+// Routes(), and enforces them.
+//
+//  r.Describe(middleware.DescribeContentType("application/json")).
+//    Post("/articles", createArticle)
+func DescribeContentType(contentTypes ...string) func(m *phi.RouteMeta) {
+	mw := AllowContentType(contentTypes...)
+	return func(m *phi.RouteMeta) {
+		m.Consumes = append(m.Consumes, contentTypes...)
+		m.Use(mw)
+	}
+}
+
+// DescribeContentEncoding is AllowContentEncoding for phi's Describe: it
+// records the `contentEncodings` as the RouteMeta.Encodings of the routes,
+// and enforces them.
+func DescribeContentEncoding(contentEncodings ...string) func(m *phi.RouteMeta) {
+	mw := AllowContentEncoding(contentEncodings...)
+	return func(m *phi.RouteMeta) {
+		m.Encodings = append(m.Encodings, contentEncodings...)
+		m.Use(mw)
+	}
+}
+
+// DescribeProduces is Produces for phi's Describe: it records the
+// `contentTypes` as the RouteMeta.Produces of the routes, and negotiates
+// them.
+func DescribeProduces(contentTypes ...string) func(m *phi.RouteMeta) {
+	mw := Produces(contentTypes...)
+	return func(m *phi.RouteMeta) {
+		m.Produces = append(m.Produces, contentTypes...)
+		m.Use(mw)
+	}
+}
+
+// ContentType returns the response media type negotiated by the Produces
+// middleware, or an empty string.
+func ContentType(ctx *fasthttp.RequestCtx) string {
+	ctype, _ := ctx.UserValue(ContentTypeCtxKey).(string)
+	return ctype
+}
+
+// hasBody reports whether the request carries a body, either by a
+// non-zero Content-Length or by chunked transfer encoding.
+func hasBody(ctx *fasthttp.RequestCtx) bool {
+	cl := ctx.Request.Header.ContentLength()
+	return cl > 0 || cl == -1 || len(ctx.Request.Body()) > 0
+}
+
+// mediaType returns the lowercased media type of a Content-Type value,
+// without any parameters.
+func mediaType(s []byte) []byte {
+	if i := bytes.IndexByte(s, ';'); i >= 0 {
+		s = s[:i]
+	}
+	return bytes.ToLower(bytes.TrimSpace(s))
+}
+
+// acceptSpec is a single media range of an Accept header.
+type acceptSpec struct {
+	typ, subtyp string
+	q           float64
+}
+
+// parseAccept parses an Accept header into its media ranges, the most
+// specific ones first.
+func parseAccept(header []byte) []acceptSpec {
+	var specs []acceptSpec
+	for _, part := range strings.Split(string(header), ",") {
+		params := strings.Split(part, ";")
+		mt := strings.ToLower(strings.TrimSpace(params[0]))
+		if mt == "" {
+			continue
+		}
+		spec := acceptSpec{typ: mt, subtyp: "*", q: 1}
+		if i := strings.IndexByte(mt, '/'); i >= 0 {
+			spec.typ, spec.subtyp = mt[:i], mt[i+1:]
+		}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) > 2 && (param[0] == 'q' || param[0] == 'Q') && param[1] == '=' {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					spec.q = q
+				}
+			}
+		}
+		specs = append(specs, spec)
+	}
+	sort.SliceStable(specs, func(i, j int) bool {
+		return specificity(specs[i]) > specificity(specs[j])
+	})
+	return specs
+}
+
+func specificity(spec acceptSpec) int {
+	switch {
+	case spec.typ == "*":
+		return 0
+	case spec.subtyp == "*":
+		return 1
+	default:
+		return 2
+	}
+}
+
+// negotiate returns the best offer for the Accept header, or an empty
+// string if none is acceptable. Offers with the same quality are picked
+// in order.
+func negotiate(header []byte, offers []string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if len(bytes.TrimSpace(header)) == 0 {
+		return offers[0]
+	}
+
+	specs := parseAccept(header)
+	bestOffer, bestQ := "", 0.0
+	for _, offer := range offers {
+		typ, subtyp := offer, "*"
+		if i := strings.IndexByte(offer, '/'); i >= 0 {
+			typ, subtyp = offer[:i], offer[i+1:]
+		}
+		// The most specific matching range decides the quality of an offer.
+		for _, spec := range specs {
+			if (spec.typ != "*" && spec.typ != typ) || (spec.subtyp != "*" && spec.subtyp != subtyp) {
+				continue
+			}
+			if spec.q > bestQ {
+				bestOffer, bestQ = offer, spec.q
+			}
+			break
+		}
+	}
+	return bestOffer
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestAllowContentType(t *testing.T) {
+	r := phi.NewRouter()
+	r.Use(AllowContentType("application/json", "text/xml"))
+	r.Post("/", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("ok")
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.POST("/").Expect().Status(200).Text().Equal("ok")
+	e.POST("/").WithHeader("Content-Type", "application/json").WithText("{}").
+		Expect().Status(200).Text().Equal("ok")
+	e.POST("/").WithHeader("Content-Type", "Application/JSON; charset=utf-8").WithText("{}").
+		Expect().Status(200).Text().Equal("ok")
+	e.POST("/").WithHeader("Content-Type", "text/xml").WithText("<a/>").
+		Expect().Status(200).Text().Equal("ok")
+	e.POST("/").WithHeader("Content-Type", "text/plain").WithText("hi").
+		Expect().Status(415)
+}
+
+func TestAllowContentEncoding(t *testing.T) {
+	r := phi.NewRouter()
+	r.Use(AllowContentEncoding("gzip", "deflate"))
+	r.Post("/", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("ok")
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.POST("/").WithText("body").Expect().Status(200).Text().Equal("ok")
+	e.POST("/").WithHeader("Content-Encoding", "gzip").WithText("body").
+		Expect().Status(200).Text().Equal("ok")
+	e.POST("/").WithHeader("Content-Encoding", "deflate, GZIP").WithText("body").
+		Expect().Status(200).Text().Equal("ok")
+	e.POST("/").WithHeader("Content-Encoding", "gzip, br").WithText("body").
+		Expect().Status(415)
+}
+
+func TestProduces(t *testing.T) {
+	r := phi.NewRouter()
+	r.Use(Produces("application/json", "text/html"))
+	r.Get("/", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString(ContentType(ctx))
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.GET("/").Expect().Status(200).Text().Equal("application/json")
+	e.GET("/").WithHeader("Accept", "text/html").Expect().Status(200).Text().Equal("text/html")
+	e.GET("/").WithHeader("Accept", "text/*").Expect().Status(200).Text().Equal("text/html")
+	e.GET("/").WithHeader("Accept", "*/*").Expect().Status(200).Text().Equal("application/json")
+	e.GET("/").WithHeader("Accept", "application/json;q=0.5, text/html").
+		Expect().Status(200).Text().Equal("text/html")
+	e.GET("/").WithHeader("Accept", "*/*;q=0.1, application/json;q=0").
+		Expect().Status(200).Text().Equal("text/html")
+	e.GET("/").WithHeader("Accept", "image/png").Expect().Status(406)
+	e.GET("/").WithHeader("Accept", "text/*;q=0.5, text/html;q=0, application/xml").
+		Expect().Status(406)
+}
+
+func TestDescribeContentType(t *testing.T) {
+	r := phi.NewRouter()
+	r.Describe(DescribeProduces("application/json")).Group(func(r phi.Router) {
+		r.Get("/articles", func(ctx *fasthttp.RequestCtx) {
+			ctx.WriteString(ContentType(ctx))
+		})
+		r.Describe(DescribeContentType("application/json")).
+			Describe(DescribeContentEncoding("gzip")).
+			Post("/articles", func(ctx *fasthttp.RequestCtx) {
+				ctx.WriteString("ok")
+			})
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.GET("/articles").Expect().Status(200).Text().Equal("application/json")
+	e.GET("/articles").WithHeader("Accept", "text/html").Expect().Status(406)
+	e.POST("/articles").WithHeader("Content-Type", "application/json").WithText("{}").
+		Expect().Status(200).Text().Equal("ok")
+	e.POST("/articles").WithHeader("Content-Type", "text/plain").WithText("hi").
+		Expect().Status(415)
+	e.POST("/articles").WithHeader("Content-Type", "application/json").
+		WithHeader("Content-Encoding", "br").WithText("{}").Expect().Status(415)
+
+	routes := r.Routes()
+	if len(routes) != 1 {
+		t.Fatalf("expected 1 route, got %d", len(routes))
+	}
+	get, post := routes[0].Meta["GET"], routes[0].Meta["POST"]
+	if len(get.Produces) != 1 || get.Produces[0] != "application/json" || len(get.Consumes) != 0 {
+		t.Errorf("unexpected GET /articles metadata: %+v", get)
+	}
+	if len(post.Produces) != 1 || len(post.Consumes) != 1 || post.Consumes[0] != "application/json" ||
+		len(post.Encodings) != 1 || post.Encodings[0] != "gzip" {
+		t.Errorf("unexpected POST /articles metadata: %+v", post)
+	}
+}
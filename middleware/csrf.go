@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	// CSRFTokenCtxKey is the context key holding the CSRF token of the
+	// request, see CSRFToken.
+	CSRFTokenCtxKey = (&contextKey{"CSRFToken"}).String()
+)
+
+// CSRFConfig configures the CSRF middleware.
+type CSRFConfig struct {
+	// Secret signs the tokens, so a cookie planted by a sibling domain
+	// isn't accepted. Required.
+	Secret []byte
+
+	// Cookie attributes of the token cookie. The name defaults to "_csrf",
+	// the path to "/" and the max age to 12 hours. SameSite defaults to Lax
+	// when left to fasthttp.CookieSameSiteDisabled.
+	CookieName   string
+	CookieDomain string
+	CookiePath   string
+	CookieMaxAge time.Duration
+	Secure       bool
+	SameSite     fasthttp.CookieSameSite
+
+	// Header and FormField name where the token is looked up on unsafe
+	// requests, "X-CSRF-Token" and "csrf_token" by default.
+	Header    string
+	FormField string
+
+	// Exempt lists route patterns which are never checked, e.g. webhooks.
+	// They're matched with a phi router, so `{param}` and `*` work.
+	Exempt []string
+
+	// ErrorHandler responds to requests failing the check. The default
+	// handler responds with a 403 Forbidden.
+	ErrorHandler phi.HandlerFunc
+}
+
+// CSRF is a middleware protecting against cross-site request forgery with
+// double-submit tokens.
+//
+// A signed random token is set in a cookie. Unsafe requests (anything but
+// GET, HEAD, OPTIONS and TRACE) must send the same token back in a header
+// or form field, which a cross-site attacker can't read. Handlers render
+// the token with CSRFToken:
+//
+//  <input type="hidden" name="csrf_token" value="{{ .CSRFToken }}">
+func CSRF(cfg CSRFConfig) phi.Middleware {
+	if len(cfg.Secret) == 0 {
+		panic("phi/middleware: CSRF requires a secret")
+	}
+	if cfg.CookieName == "" {
+		cfg.CookieName = "_csrf"
+	}
+	if cfg.CookiePath == "" {
+		cfg.CookiePath = "/"
+	}
+	if cfg.CookieMaxAge == 0 {
+		cfg.CookieMaxAge = 12 * time.Hour
+	}
+	if cfg.SameSite == fasthttp.CookieSameSiteDisabled {
+		cfg.SameSite = fasthttp.CookieSameSiteLaxMode
+	}
+	if cfg.Header == "" {
+		cfg.Header = "X-CSRF-Token"
+	}
+	if cfg.FormField == "" {
+		cfg.FormField = "csrf_token"
+	}
+	if cfg.ErrorHandler == nil {
+		cfg.ErrorHandler = errorHandler(fasthttp.StatusForbidden)
+	}
+
+	var exempt *phi.Mux
+	if len(cfg.Exempt) > 0 {
+		exempt = phi.NewRouter()
+		for _, pattern := range cfg.Exempt {
+			exempt.Handle(pattern, func(ctx *fasthttp.RequestCtx) {})
+		}
+	}
+
+	return func(next phi.HandlerFunc) phi.HandlerFunc {
+		return func(ctx *fasthttp.RequestCtx) {
+			token := string(ctx.Request.Header.Cookie(cfg.CookieName))
+			if !validCSRFToken(cfg.Secret, token) {
+				token = newCSRFToken(cfg.Secret)
+				setCSRFCookie(ctx, &cfg, token)
+			}
+			ctx.SetUserValue(CSRFTokenCtxKey, token)
+
+			if isSafeMethod(ctx) || (exempt != nil &&
+				exempt.Match(phi.NewRouteContext(), string(ctx.Method()), string(ctx.Path()))) {
+				next(ctx)
+				return
+			}
+
+			sent := ctx.Request.Header.Peek(cfg.Header)
+			if len(sent) == 0 {
+				sent = ctx.FormValue(cfg.FormField)
+			}
+			if len(sent) == 0 || subtle.ConstantTimeCompare(sent, []byte(token)) != 1 {
+				cfg.ErrorHandler(ctx)
+				return
+			}
+
+			next(ctx)
+		}
+	}
+}
+
+// CSRFToken returns the CSRF token to embed in forms, or to send in the
+// header of scripted requests.
+func CSRFToken(ctx *fasthttp.RequestCtx) string {
+	token, _ := ctx.UserValue(CSRFTokenCtxKey).(string)
+	return token
+}
+
+func isSafeMethod(ctx *fasthttp.RequestCtx) bool {
+	return ctx.IsGet() || ctx.IsHead() || ctx.IsOptions() || ctx.IsTrace()
+}
+
+// newCSRFToken returns a random nonce followed by its signature.
+func newCSRFToken(secret []byte) string {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		panic("phi/middleware: failed to generate CSRF token: " + err.Error())
+	}
+	return signCSRFNonce(secret, base64.RawURLEncoding.EncodeToString(nonce))
+}
+
+func signCSRFNonce(secret []byte, nonce string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(nonce))
+	return nonce + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func validCSRFToken(secret []byte, token string) bool {
+	i := strings.IndexByte(token, '.')
+	if i <= 0 {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(signCSRFNonce(secret, token[:i])))
+}
+
+func setCSRFCookie(ctx *fasthttp.RequestCtx, cfg *CSRFConfig, token string) {
+	c := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(c)
+
+	c.SetKey(cfg.CookieName)
+	c.SetValue(token)
+	c.SetPath(cfg.CookiePath)
+	c.SetDomain(cfg.CookieDomain)
+	c.SetMaxAge(int(cfg.CookieMaxAge / time.Second))
+	c.SetSecure(cfg.Secure)
+	c.SetHTTPOnly(true)
+	c.SetSameSite(cfg.SameSite)
+	ctx.Response.Header.SetCookie(c)
+}
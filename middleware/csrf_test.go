@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestCSRF(t *testing.T) {
+	secret := []byte("s3cr3t")
+	r := phi.NewRouter()
+	r.Use(CSRF(CSRFConfig{
+		Secret: secret,
+		Exempt: []string{"/hooks/{provider}"},
+	}))
+	r.Get("/form", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString(CSRFToken(ctx))
+	})
+	r.Post("/form", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("posted")
+	})
+	r.Post("/hooks/{provider}", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("hook " + phi.URLParam(ctx, "provider"))
+	})
+
+	e := newFastHTTPTester(t, r)
+	resp := e.GET("/form").Expect().Status(200)
+	token := resp.Text().NotEmpty().Raw()
+	resp.Cookie("_csrf").Value().Equal(token)
+	resp.Header("Set-Cookie").Contains("SameSite=Lax").Contains("HttpOnly")
+
+	// the cookie jar sends the cookie back, and the token is kept
+	e.GET("/form").Expect().Status(200).Text().Equal(token)
+
+	e.POST("/form").Expect().Status(403)
+	e.POST("/form").WithHeader("X-CSRF-Token", token+"x").Expect().Status(403)
+	e.POST("/form").WithHeader("X-CSRF-Token", token).Expect().Status(200).Text().Equal("posted")
+	e.POST("/form").WithFormField("csrf_token", token).Expect().Status(200).Text().Equal("posted")
+
+	e.POST("/hooks/github").Expect().Status(200).Text().Equal("hook github")
+	e.POST("/hooks/github/extra").Expect().Status(403)
+}
+
+func TestCSRFForgedCookie(t *testing.T) {
+	r := phi.NewRouter()
+	r.Use(CSRF(CSRFConfig{
+		Secret: []byte("s3cr3t"),
+		ErrorHandler: func(ctx *fasthttp.RequestCtx) {
+			ctx.SetStatusCode(400)
+			ctx.WriteString("bad token")
+		},
+	}))
+	r.Post("/", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("posted")
+	})
+
+	// a cookie planted without the secret is replaced, so echoing it fails
+	forged := signCSRFNonce([]byte("other"), "nonce")
+	e := newFastHTTPTester(t, r)
+	e.POST("/").WithCookie("_csrf", forged).WithHeader("X-CSRF-Token", forged).
+		Expect().Status(400).Text().Equal("bad token")
+}
@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+// ETagConfig configures the ETag middleware.
+type ETagConfig struct {
+	// Weak makes the tags hashed from the response bodies weak. As weak
+	// tags never match strongly, If-Match then only passes with "*".
+	Weak bool
+
+	// Validator returns the validators of the current representation of
+	// the resource targeted by a PUT, PATCH or DELETE request, and whether
+	// it exists, usually by looking them up in the store the handler
+	// writes to. The preconditions of these requests are ignored when it's
+	// nil.
+	Validator func(ctx *fasthttp.RequestCtx) (etag []byte, lastModified time.Time, exists bool)
+}
+
+// ETag is a middleware that tags successful GET and HEAD responses with an
+// entity tag hashed from their body, and evaluates the conditional request
+// headers of RFC 7232: If-Match, If-None-Match, If-Modified-Since and
+// If-Unmodified-Since.
+//
+// Responses already carrying an ETag header keep it, so handlers with a
+// cheaper version identifier can set it themselves. Last-Modified is only
+// used when the handler sets it.
+//
+// On GET and HEAD, matching responses are turned into a 304 Not Modified
+// or a 412 Precondition Failed. On PUT, PATCH and DELETE the preconditions
+// are checked before calling the handler, against the validators returned
+// by cfg.Validator. This gives clients optimistic concurrency:
+//
+//  r.Use(middleware.ETag(middleware.ETagConfig{
+//    Validator: func(ctx *fasthttp.RequestCtx) ([]byte, time.Time, bool) {
+//      a, ok := articles.Get(phi.URLParam(ctx, "id"))
+//      if !ok {
+//        return nil, time.Time{}, false
+//      }
+//      return a.ETag(), a.Updated, true
+//    },
+//  }))
+//  r.Get("/articles/{id}", getArticle)
+//  r.Put("/articles/{id}", updateArticle) // 412 on a stale If-Match
+//
+// The tags returned by the validator must be the ones of the GET
+// responses, so handlers using it usually set the ETag header themselves.
+func ETag(cfg ETagConfig) phi.Middleware {
+	return func(next phi.HandlerFunc) phi.HandlerFunc {
+		return func(ctx *fasthttp.RequestCtx) {
+			switch {
+			case ctx.IsGet() || ctx.IsHead():
+				next(ctx)
+				if !isSuccess(ctx.Response.StatusCode()) || ctx.Response.IsBodyStream() {
+					return
+				}
+				etag := responseETag(&ctx.Response, cfg.Weak)
+				lastModified := responseLastModified(&ctx.Response)
+				switch evalPreconditions(ctx, true, etag, lastModified) {
+				case fasthttp.StatusNotModified:
+					notModified(ctx)
+				case fasthttp.StatusPreconditionFailed:
+					ctx.Error(fasthttp.StatusMessage(fasthttp.StatusPreconditionFailed), fasthttp.StatusPreconditionFailed)
+				}
+
+			case ctx.IsPut() || ctx.IsPatch() || ctx.IsDelete():
+				if cfg.Validator != nil && hasPreconditions(ctx) {
+					etag, lastModified, exists := cfg.Validator(ctx)
+					if !exists {
+						etag, lastModified = nil, time.Time{}
+					}
+					if evalPreconditions(ctx, exists, etag, lastModified) != 0 {
+						ctx.Error(fasthttp.StatusMessage(fasthttp.StatusPreconditionFailed), fasthttp.StatusPreconditionFailed)
+						return
+					}
+				}
+				next(ctx)
+
+			default:
+				next(ctx)
+			}
+		}
+	}
+}
+
+// evalPreconditions evaluates the conditional headers of the request in the
+// order of RFC 7232 section 6, against a representation which may not
+// exist. It returns 0 when the request should proceed, or the 304/412
+// status to respond with.
+func evalPreconditions(ctx *fasthttp.RequestCtx, exists bool, etag []byte, lastModified time.Time) int {
+	h := &ctx.Request.Header
+	safe := ctx.IsGet() || ctx.IsHead()
+
+	if v := h.Peek(fasthttp.HeaderIfMatch); len(v) > 0 {
+		if !exists || !matchETag(v, etag, false) {
+			return fasthttp.StatusPreconditionFailed
+		}
+	} else if t, err := fasthttp.ParseHTTPDate(h.Peek(fasthttp.HeaderIfUnmodifiedSince)); err == nil && exists && !lastModified.IsZero() {
+		if lastModified.Truncate(time.Second).After(t) {
+			return fasthttp.StatusPreconditionFailed
+		}
+	}
+
+	if v := h.Peek(fasthttp.HeaderIfNoneMatch); len(v) > 0 {
+		if exists && matchETag(v, etag, true) {
+			if safe {
+				return fasthttp.StatusNotModified
+			}
+			return fasthttp.StatusPreconditionFailed
+		}
+	} else if t, err := fasthttp.ParseHTTPDate(h.Peek(fasthttp.HeaderIfModifiedSince)); err == nil && safe && exists && !lastModified.IsZero() {
+		if !lastModified.Truncate(time.Second).After(t) {
+			return fasthttp.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+func hasPreconditions(ctx *fasthttp.RequestCtx) bool {
+	h := &ctx.Request.Header
+	return len(h.Peek(fasthttp.HeaderIfMatch)) > 0 ||
+		len(h.Peek(fasthttp.HeaderIfNoneMatch)) > 0 ||
+		len(h.Peek(fasthttp.HeaderIfUnmodifiedSince)) > 0
+}
+
+// responseETag returns the ETag of the response, setting one hashed from
+// the body when the handler didn't.
+func responseETag(resp *fasthttp.Response, weak bool) []byte {
+	if etag := resp.Header.Peek(fasthttp.HeaderETag); len(etag) > 0 {
+		return etag
+	}
+
+	sum := sha256.Sum256(resp.Body())
+	etag := make([]byte, 0, 36)
+	if weak {
+		etag = append(etag, "W/"...)
+	}
+	etag = append(etag, '"')
+	etag = append(etag, hex.EncodeToString(sum[:16])...)
+	etag = append(etag, '"')
+	resp.Header.SetBytesV(fasthttp.HeaderETag, etag)
+	return etag
+}
+
+func responseLastModified(resp *fasthttp.Response) time.Time {
+	t, _ := fasthttp.ParseHTTPDate(resp.Header.Peek(fasthttp.HeaderLastModified))
+	return t
+}
+
+// matchETag reports whether the If-Match or If-None-Match header value `v`
+// matches etag, using the weak or the strong comparison function.
+func matchETag(v, etag []byte, weakCmp bool) bool {
+	if string(bytes.TrimSpace(v)) == "*" {
+		return true
+	}
+	if len(etag) == 0 {
+		return false
+	}
+	for len(v) > 0 {
+		var tag []byte
+		if i := bytes.IndexByte(v, ','); i >= 0 {
+			tag, v = v[:i], v[i+1:]
+		} else {
+			tag, v = v, nil
+		}
+		tag = bytes.TrimSpace(tag)
+
+		if weakCmp {
+			if bytes.Equal(bytes.TrimPrefix(tag, []byte("W/")), bytes.TrimPrefix(etag, []byte("W/"))) {
+				return true
+			}
+		} else if !bytes.HasPrefix(tag, []byte("W/")) && !bytes.HasPrefix(etag, []byte("W/")) && bytes.Equal(tag, etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified turns the response into a 304, keeping the headers RFC 7232
+// requires and dropping the body.
+func notModified(ctx *fasthttp.RequestCtx) {
+	ctx.Response.ResetBody()
+	ctx.Response.Header.Del(fasthttp.HeaderContentType)
+	ctx.Response.Header.SetNoDefaultContentType(true)
+	ctx.SetStatusCode(fasthttp.StatusNotModified)
+}
+
+func isSuccess(status int) bool {
+	return status >= 200 && status < 300
+}
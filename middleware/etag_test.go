@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestETag(t *testing.T) {
+	body := "v1"
+	version := 1
+	tag := func() []byte {
+		return []byte(fmt.Sprintf(`"v%d"`, version))
+	}
+
+	r := phi.NewRouter()
+	r.Use(ETag(ETagConfig{
+		Validator: func(ctx *fasthttp.RequestCtx) ([]byte, time.Time, bool) {
+			if string(ctx.Path()) != "/article" {
+				return nil, time.Time{}, false
+			}
+			return tag(), time.Time{}, true
+		},
+	}))
+	getArticle := func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.SetBytesV(fasthttp.HeaderETag, tag())
+		ctx.WriteString(body)
+	}
+	r.Get("/article", getArticle)
+	r.Head("/article", getArticle)
+	r.Put("/article", func(ctx *fasthttp.RequestCtx) {
+		body = string(ctx.PostBody())
+		version++
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+	})
+	r.Put("/missing", func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusCreated)
+	})
+
+	e := newFastHTTPTester(t, r)
+
+	etag := e.GET("/article").Expect().Status(200).Header("ETag").Equal(`"v1"`).Raw()
+	e.GET("/article").Expect().Header("ETag").Equal(etag)
+	e.HEAD("/article").Expect().Header("ETag").Equal(etag)
+
+	res := e.GET("/article").WithHeader("If-None-Match", `"other", `+etag).Expect()
+	res.Status(304)
+	res.Header("ETag").Equal(etag)
+	res.Body().Empty()
+	e.GET("/article").WithHeader("If-None-Match", "W/"+etag).Expect().Status(304)
+	e.GET("/article").WithHeader("If-None-Match", `"other"`).Expect().Status(200)
+	e.GET("/article").WithHeader("If-Match", `"other"`).Expect().Status(412)
+	e.GET("/article").WithHeader("If-Match", etag).Expect().Status(200)
+
+	// optimistic concurrency
+	e.PUT("/article").WithHeader("If-Match", `"stale"`).WithText("v2").Expect().Status(412)
+	e.PUT("/article").WithHeader("If-Match", etag).WithText("v2").Expect().Status(204)
+	e.PUT("/article").WithHeader("If-Match", etag).WithText("v3").Expect().Status(412)
+	e.GET("/article").Expect().Body().Equal("v2")
+	e.GET("/article").Expect().Header("ETag").Equal(`"v2"`)
+
+	// create only if missing
+	e.PUT("/article").WithHeader("If-None-Match", "*").Expect().Status(412)
+	e.PUT("/missing").WithHeader("If-None-Match", "*").Expect().Status(201)
+	e.PUT("/missing").WithHeader("If-Match", "*").Expect().Status(412)
+}
+
+func TestETagHash(t *testing.T) {
+	r := phi.NewRouter()
+	r.Use(ETag(ETagConfig{}))
+	r.Get("/", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("hello")
+	})
+	r.Put("/", func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+	})
+
+	e := newFastHTTPTester(t, r)
+	etag := e.GET("/").Expect().Status(200).Header("ETag").NotEmpty().Raw()
+	e.GET("/").Expect().Header("ETag").Equal(etag)
+	e.GET("/").WithHeader("If-None-Match", etag).Expect().Status(304)
+
+	// without a validator the write preconditions are left to the handler
+	e.PUT("/").WithHeader("If-Match", `"stale"`).Expect().Status(204)
+}
+
+func TestETagWeak(t *testing.T) {
+	r := phi.NewRouter()
+	r.Use(ETag(ETagConfig{Weak: true}))
+	r.Get("/", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("hello")
+	})
+
+	e := newFastHTTPTester(t, r)
+	etag := e.GET("/").Expect().Header("ETag").Raw()
+	if etag[:3] != `W/"` {
+		t.Fatalf("etag = %s, want a weak tag", etag)
+	}
+	e.GET("/").WithHeader("If-None-Match", etag).Expect().Status(304)
+	e.GET("/").WithHeader("If-Match", etag).Expect().Status(412)
+	e.GET("/").WithHeader("If-Match", "*").Expect().Status(200)
+}
+
+func TestETagLastModified(t *testing.T) {
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	r := phi.NewRouter()
+	r.Use(ETag(ETagConfig{
+		Validator: func(ctx *fasthttp.RequestCtx) ([]byte, time.Time, bool) {
+			return nil, modified, true
+		},
+	}))
+	r.Get("/", func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.SetLastModified(modified)
+		ctx.WriteString("hello")
+	})
+	r.Delete("/", func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusNoContent)
+	})
+
+	e := newFastHTTPTester(t, r)
+	before := string(fasthttp.AppendHTTPDate(nil, modified.Add(-time.Hour)))
+	after := string(fasthttp.AppendHTTPDate(nil, modified))
+
+	e.GET("/").WithHeader("If-Modified-Since", after).Expect().Status(304)
+	e.GET("/").WithHeader("If-Modified-Since", before).Expect().Status(200)
+	// If-None-Match takes precedence over If-Modified-Since
+	e.GET("/").WithHeader("If-None-Match", `"other"`).WithHeader("If-Modified-Since", after).Expect().Status(200)
+
+	e.DELETE("/").WithHeader("If-Unmodified-Since", before).Expect().Status(412)
+	e.DELETE("/").WithHeader("If-Unmodified-Since", after).Expect().Status(204)
+}
@@ -0,0 +1,290 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+// IdempotencyRecord is a response stored by the Idempotency middleware.
+type IdempotencyRecord struct {
+	// Fingerprint identifies the request which produced the response.
+	Fingerprint []byte
+
+	Status int
+	Header [][2]string
+	Body   []byte
+}
+
+// IdempotencyStore stores the responses of the Idempotency middleware.
+// Implementations must be safe for concurrent use.
+type IdempotencyStore interface {
+	// Get returns the record stored under `key`.
+	Get(key string) (*IdempotencyRecord, bool)
+
+	// Lock reserves `key` for `ttl`. It reports false when the key is
+	// already locked or holds a record.
+	Lock(key string, ttl time.Duration) bool
+
+	// Unlock releases the key without storing anything.
+	Unlock(key string)
+
+	// Save stores the record under `key` for `ttl`, and releases the key.
+	Save(key string, rec *IdempotencyRecord, ttl time.Duration)
+}
+
+// IdempotencyConfig configures the Idempotency middleware.
+type IdempotencyConfig struct {
+	// Store holds the responses, an in-memory store by default.
+	Store IdempotencyStore
+
+	// Header carrying the key, "Idempotency-Key" by default.
+	Header string
+
+	// TTL of the stored responses, 24 hours by default.
+	TTL time.Duration
+
+	// Methods subject to idempotency keys, POST and PATCH by default.
+	Methods []string
+
+	// ConflictHandler is called when a request with the same key is still
+	// being processed. It responds with a 409 Conflict by default.
+	ConflictHandler phi.HandlerFunc
+
+	// MismatchHandler is called when the key was used for another request.
+	// It responds with a 422 Unprocessable Entity by default.
+	MismatchHandler phi.HandlerFunc
+
+	// KeyFunc returns the store key of the idempotency `key` sent with the
+	// request, which must differ between callers so that none is replayed
+	// the response of another. By default the key is prefixed with a hash
+	// of the Authorization and Cookie headers, a KeyFunc can scope it by
+	// the authenticated user instead.
+	KeyFunc func(ctx *fasthttp.RequestCtx, key string) string
+
+	// ShouldStore reports whether the response to the request is stored.
+	// By default all but the 5xx responses are, so that the retries of a
+	// request which failed on the server side run the handler again.
+	ShouldStore func(ctx *fasthttp.RequestCtx) bool
+}
+
+// Idempotency is a middleware making retries of unsafe requests safe.
+//
+// The first request carrying a given Idempotency-Key runs the handler, and
+// its response is stored. Retries with the same key replay that response,
+// with an "Idempotent-Replayed: true" header, without calling the handler
+// again. A retry arriving while the first request is in flight gets a 409,
+// and reusing a key for a request with another method, URL or body gets a
+// 422. Keys are scoped to the credentials of the caller, see KeyFunc.
+// Requests without a key aren't affected:
+//
+//  r.With(middleware.Idempotency(middleware.IdempotencyConfig{})).Post("/payments", pay)
+//
+// Streamed and 5xx responses aren't stored, so their requests can be
+// retried.
+func Idempotency(cfg IdempotencyConfig) phi.Middleware {
+	if cfg.Store == nil {
+		cfg.Store = NewIdempotencyMemoryStore()
+	}
+	if cfg.Header == "" {
+		cfg.Header = "Idempotency-Key"
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+	if len(cfg.Methods) == 0 {
+		cfg.Methods = []string{fasthttp.MethodPost, fasthttp.MethodPatch}
+	}
+	if cfg.ConflictHandler == nil {
+		cfg.ConflictHandler = errorHandler(fasthttp.StatusConflict)
+	}
+	if cfg.MismatchHandler == nil {
+		cfg.MismatchHandler = errorHandler(fasthttp.StatusUnprocessableEntity)
+	}
+	if cfg.KeyFunc == nil {
+		cfg.KeyFunc = credentialsKey
+	}
+	if cfg.ShouldStore == nil {
+		cfg.ShouldStore = func(ctx *fasthttp.RequestCtx) bool {
+			return ctx.Response.StatusCode() < fasthttp.StatusInternalServerError
+		}
+	}
+
+	return func(next phi.HandlerFunc) phi.HandlerFunc {
+		return func(ctx *fasthttp.RequestCtx) {
+			key := string(ctx.Request.Header.Peek(cfg.Header))
+			if key == "" || !containsMethod(cfg.Methods, ctx.Method()) {
+				next(ctx)
+				return
+			}
+
+			key = cfg.KeyFunc(ctx, key)
+			fingerprint := requestFingerprint(ctx)
+			replay := func(rec *IdempotencyRecord) {
+				if !bytes.Equal(rec.Fingerprint, fingerprint) {
+					cfg.MismatchHandler(ctx)
+					return
+				}
+				ctx.Response.Reset()
+				ctx.SetStatusCode(rec.Status)
+				for _, kv := range rec.Header {
+					ctx.Response.Header.Add(kv[0], kv[1])
+				}
+				ctx.Response.Header.Set("Idempotent-Replayed", "true")
+				ctx.SetBody(rec.Body)
+			}
+
+			if rec, ok := cfg.Store.Get(key); ok {
+				replay(rec)
+				return
+			}
+			if !cfg.Store.Lock(key, cfg.TTL) {
+				// The first request may have completed in the meantime.
+				if rec, ok := cfg.Store.Get(key); ok {
+					replay(rec)
+					return
+				}
+				cfg.ConflictHandler(ctx)
+				return
+			}
+
+			saved := false
+			defer func() {
+				if !saved {
+					cfg.Store.Unlock(key)
+				}
+			}()
+
+			next(ctx)
+
+			if ctx.Response.IsBodyStream() || !cfg.ShouldStore(ctx) {
+				return
+			}
+			rec := &IdempotencyRecord{
+				Fingerprint: fingerprint,
+				Status:      ctx.Response.StatusCode(),
+				Body:        append([]byte(nil), ctx.Response.Body()...),
+			}
+			// VisitAll yields the Set-Cookie headers too
+			ctx.Response.Header.VisitAll(func(k, v []byte) {
+				switch string(k) {
+				case fasthttp.HeaderContentLength, fasthttp.HeaderDate, fasthttp.HeaderServer:
+					return
+				}
+				rec.Header = append(rec.Header, [2]string{string(k), string(v)})
+			})
+			cfg.Store.Save(key, rec, cfg.TTL)
+			saved = true
+		}
+	}
+}
+
+// credentialsKey prefixes the idempotency `key` with a hash of the
+// credentials of the request.
+func credentialsKey(ctx *fasthttp.RequestCtx, key string) string {
+	h := sha256.New()
+	h.Write(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization))
+	h.Write([]byte{'\n'})
+	h.Write(ctx.Request.Header.Peek(fasthttp.HeaderCookie))
+	return hex.EncodeToString(h.Sum(nil)[:16]) + ":" + key
+}
+
+// requestFingerprint hashes the method, URL and body of the request.
+func requestFingerprint(ctx *fasthttp.RequestCtx) []byte {
+	h := sha256.New()
+	h.Write(ctx.Method())
+	h.Write([]byte{' '})
+	h.Write(ctx.URI().RequestURI())
+	h.Write([]byte{'\n'})
+	h.Write(ctx.PostBody())
+	return h.Sum(nil)
+}
+
+func containsMethod(methods []string, method []byte) bool {
+	for _, m := range methods {
+		if m == string(method) {
+			return true
+		}
+	}
+	return false
+}
+
+// IdempotencyMemoryStore is an in-memory IdempotencyStore. Expired keys are
+// swept at most once a minute, as new ones are locked.
+type IdempotencyMemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+
+	// now is overridden in tests
+	now func() time.Time
+}
+
+type idempotencyEntry struct {
+	rec     *IdempotencyRecord // nil while locked
+	expires time.Time
+}
+
+// NewIdempotencyMemoryStore returns an empty IdempotencyMemoryStore.
+func NewIdempotencyMemoryStore() *IdempotencyMemoryStore {
+	return &IdempotencyMemoryStore{
+		entries: make(map[string]*idempotencyEntry),
+		now:     time.Now,
+	}
+}
+
+// Get implements IdempotencyStore.
+func (s *IdempotencyMemoryStore) Get(key string) (*IdempotencyRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || e.rec == nil || !s.now().Before(e.expires) {
+		return nil, false
+	}
+	return e.rec, true
+}
+
+// Lock implements IdempotencyStore.
+func (s *IdempotencyMemoryStore) Lock(key string, ttl time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.entries {
+			if !now.Before(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	if e, ok := s.entries[key]; ok && now.Before(e.expires) {
+		return false
+	}
+	s.entries[key] = &idempotencyEntry{expires: now.Add(ttl)}
+	return true
+}
+
+// Unlock implements IdempotencyStore.
+func (s *IdempotencyMemoryStore) Unlock(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok && e.rec == nil {
+		delete(s.entries, key)
+	}
+}
+
+// Save implements IdempotencyStore.
+func (s *IdempotencyMemoryStore) Save(key string, rec *IdempotencyRecord, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = &idempotencyEntry{rec: rec, expires: s.now().Add(ttl)}
+}
@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestIdempotency(t *testing.T) {
+	var calls int32
+	r := phi.NewRouter()
+	r.Use(Idempotency(IdempotencyConfig{}))
+	r.Post("/payments", func(ctx *fasthttp.RequestCtx) {
+		n := atomic.AddInt32(&calls, 1)
+		ctx.Response.Header.Set("Location", "/payments/"+strconv.Itoa(int(n)))
+		ctx.SetStatusCode(fasthttp.StatusCreated)
+		ctx.WriteString("payment " + strconv.Itoa(int(n)))
+	})
+	r.Put("/payments", func(ctx *fasthttp.RequestCtx) {
+		atomic.AddInt32(&calls, 1)
+	})
+
+	e := newFastHTTPTester(t, r)
+
+	e.POST("/payments").WithText("10").Expect().Status(201).Body().Equal("payment 1")
+	e.POST("/payments").WithText("10").Expect().Status(201).Body().Equal("payment 2")
+
+	res := e.POST("/payments").WithHeader("Idempotency-Key", "k1").WithText("10").Expect()
+	res.Status(201).Body().Equal("payment 3")
+	res.Header("Idempotent-Replayed").Empty()
+
+	res = e.POST("/payments").WithHeader("Idempotency-Key", "k1").WithText("10").Expect()
+	res.Status(201).Body().Equal("payment 3")
+	res.Header("Location").Equal("/payments/3")
+	res.Header("Idempotent-Replayed").Equal("true")
+
+	e.POST("/payments").WithHeader("Idempotency-Key", "k1").WithText("20").Expect().Status(422)
+	e.POST("/payments").WithQuery("x", "1").WithHeader("Idempotency-Key", "k1").WithText("10").Expect().Status(422)
+
+	e.POST("/payments").WithHeader("Idempotency-Key", "k2").WithText("10").Expect().Body().Equal("payment 4")
+
+	// only POST and PATCH by default
+	e.PUT("/payments").WithHeader("Idempotency-Key", "k3").Expect().Status(200)
+	e.PUT("/payments").WithHeader("Idempotency-Key", "k3").Expect().Status(200)
+	if calls != 6 {
+		t.Fatalf("handler called %d times, want 6", calls)
+	}
+}
+
+func TestIdempotencyCookies(t *testing.T) {
+	r := phi.NewRouter()
+	r.Use(Idempotency(IdempotencyConfig{}))
+	r.Post("/login", func(ctx *fasthttp.RequestCtx) {
+		for _, name := range []string{"session", "theme"} {
+			c := fasthttp.AcquireCookie()
+			c.SetKey(name)
+			c.SetValue("v")
+			ctx.Response.Header.SetCookie(c)
+			fasthttp.ReleaseCookie(c)
+		}
+		ctx.WriteString("ok")
+	})
+
+	serve := func() (cookies int) {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.Header.SetMethod("POST")
+		ctx.Request.SetRequestURI("/login")
+		ctx.Request.Header.Set("Idempotency-Key", "k")
+		r.ServeFastHTTP(&ctx)
+		ctx.Response.Header.VisitAll(func(k, v []byte) {
+			if string(k) == fasthttp.HeaderSetCookie {
+				cookies++
+			}
+		})
+		return cookies
+	}
+
+	if n := serve(); n != 2 {
+		t.Fatalf("expected 2 cookies, got %d", n)
+	}
+	if n := serve(); n != 2 {
+		t.Fatalf("expected 2 replayed cookies, got %d", n)
+	}
+}
+
+func TestIdempotencyServerErrors(t *testing.T) {
+	var calls int32
+	r := phi.NewRouter()
+	r.Use(Idempotency(IdempotencyConfig{}))
+	r.Post("/payments", func(ctx *fasthttp.RequestCtx) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+			return
+		}
+		ctx.SetStatusCode(fasthttp.StatusCreated)
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.POST("/payments").WithHeader("Idempotency-Key", "k1").Expect().Status(503)
+	e.POST("/payments").WithHeader("Idempotency-Key", "k1").Expect().Status(201)
+	e.POST("/payments").WithHeader("Idempotency-Key", "k1").Expect().Status(201).Header("Idempotent-Replayed").Equal("true")
+
+	r = phi.NewRouter()
+	r.Use(Idempotency(IdempotencyConfig{
+		ShouldStore: func(ctx *fasthttp.RequestCtx) bool { return true },
+	}))
+	r.Post("/payments", func(ctx *fasthttp.RequestCtx) {
+		ctx.SetStatusCode(fasthttp.StatusBadGateway)
+		ctx.WriteString(strconv.Itoa(int(atomic.AddInt32(&calls, 1))))
+	})
+
+	e = newFastHTTPTester(t, r)
+	e.POST("/payments").WithHeader("Idempotency-Key", "k1").Expect().Status(502).Body().Equal("3")
+	e.POST("/payments").WithHeader("Idempotency-Key", "k1").Expect().Status(502).Body().Equal("3")
+}
+
+func TestIdempotencyCallers(t *testing.T) {
+	r := phi.NewRouter()
+	r.Use(Idempotency(IdempotencyConfig{}))
+	r.Post("/payments", func(ctx *fasthttp.RequestCtx) {
+		ctx.Write(ctx.Request.Header.Peek("Authorization"))
+	})
+
+	e := newFastHTTPTester(t, r)
+	for _, user := range []string{"Bearer alice", "Bearer bob", "Bearer alice"} {
+		e.POST("/payments").WithHeader("Authorization", user).WithHeader("Idempotency-Key", "k1").WithText("10").
+			Expect().Status(200).Body().Equal(user)
+	}
+	e.POST("/payments").WithHeader("Cookie", "session=1").WithHeader("Idempotency-Key", "k1").WithText("10").
+		Expect().Status(200).Body().Equal("")
+}
+
+func TestIdempotencyConflict(t *testing.T) {
+	store := NewIdempotencyMemoryStore()
+	r := phi.NewRouter()
+	r.Use(Idempotency(IdempotencyConfig{
+		Store: store,
+		KeyFunc: func(ctx *fasthttp.RequestCtx, key string) string {
+			return key
+		},
+	}))
+	r.Post("/", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("ok")
+	})
+	r.Post("/panic", func(ctx *fasthttp.RequestCtx) {
+		panic("boom")
+	})
+
+	e := newFastHTTPTester(t, r)
+
+	// a request with the same key is in flight
+	store.Lock("busy", time.Minute)
+	e.POST("/").WithHeader("Idempotency-Key", "busy").Expect().Status(409)
+	store.Unlock("busy")
+	e.POST("/").WithHeader("Idempotency-Key", "busy").Expect().Status(200).Body().Equal("ok")
+
+	// keys are released when the handler panics
+	func() {
+		defer func() { recover() }()
+		var ctx fasthttp.RequestCtx
+		ctx.Request.Header.SetMethod("POST")
+		ctx.Request.SetRequestURI("/panic")
+		ctx.Request.Header.Set("Idempotency-Key", "panic")
+		r.ServeFastHTTP(&ctx)
+	}()
+	if !store.Lock("panic", time.Minute) {
+		t.Fatal("key should be unlocked after a panic")
+	}
+}
+
+func TestIdempotencyMemoryStoreTTL(t *testing.T) {
+	now := time.Now()
+	store := NewIdempotencyMemoryStore()
+	store.now = func() time.Time { return now }
+
+	if !store.Lock("k", time.Minute) {
+		t.Fatal("lock failed")
+	}
+	store.Save("k", &IdempotencyRecord{Status: 200}, time.Minute)
+	if _, ok := store.Get("k"); !ok {
+		t.Fatal("record should be stored")
+	}
+	if store.Lock("k", time.Minute) {
+		t.Fatal("stored keys can't be locked")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := store.Get("k"); ok {
+		t.Fatal("record should be expired")
+	}
+	if !store.Lock("k", time.Minute) {
+		t.Fatal("expired keys should be lockable")
+	}
+}
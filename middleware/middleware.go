@@ -0,0 +1,36 @@
+// Package middleware provides a set of phi.Middleware handlers for common
+// concerns such as request body limits, content negotiation, proxy headers
+// and authentication.
+//
+// Every middleware in this package has the phi.Middleware signature, so it
+// can be installed on a whole router with Use, or on a single route with
+// With:
+//
+//  r := phi.NewRouter()
+//  r.Use(middleware.BodyLimit(64 << 10))
+//  r.With(middleware.BodyLimit(100 << 20)).Post("/upload", upload)
+package middleware
+
+import (
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+// contextKey names the values set with ctx.SetUserValue. The keys are the
+// strings it formats, prefixed to prevent collisions with the keys of the
+// application, like phi.RouteCtxKey.
+type contextKey struct {
+	name string
+}
+
+func (k *contextKey) String() string {
+	return "phi/middleware context value " + k.name
+}
+
+// errorHandler returns a phi.HandlerFunc responding with the given status
+// code and its standard status text.
+func errorHandler(status int) phi.HandlerFunc {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.Error(fasthttp.StatusMessage(status), status)
+	}
+}
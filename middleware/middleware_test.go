@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/fate-lovely/phi"
+	"github.com/gavv/httpexpect"
+	"github.com/valyala/fasthttp"
+)
+
+func newFastHTTPTester(t *testing.T, h phi.Handler) *httpexpect.Expect {
+	return httpexpect.WithConfig(httpexpect.Config{
+		// Pass requests directly to FastHTTPHandler.
+		Client: &http.Client{
+			Transport: httpexpect.NewFastBinder(fasthttp.RequestHandler(h.ServeFastHTTP)),
+			Jar:       httpexpect.NewJar(),
+		},
+		// Report errors using testify.
+		Reporter: httpexpect.NewAssertReporter(t),
+	})
+}
@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+// RealIP is a middleware that resolves the client IP, scheme and host of a
+// request sent through proxies, and stores them on phi.Context as ClientIP,
+// Scheme and Host.
+//
+// Proxy headers are easy to forge, so they're only honored for hops coming
+// from one of the `trustedProxies`, given as CIDRs ("10.0.0.0/8") or plain
+// IPs. Headers are looked up in this order:
+//
+//  1. Forwarded (RFC 7239), e.g. `Forwarded: for=192.0.2.60;proto=https`
+//  2. X-Forwarded-For, along with X-Forwarded-Proto and X-Forwarded-Host
+//  3. X-Real-IP
+//
+// The hop list is walked from the closest proxy back to the client and the
+// first address which isn't trusted is the client. Without a trusted peer,
+// the connection's remote address, TLS state and Host header are used.
+//
+// RealIP panics if a trusted proxy can't be parsed.
+func RealIP(trustedProxies ...string) phi.Middleware {
+	nets := make([]*net.IPNet, 0, len(trustedProxies))
+	for _, s := range trustedProxies {
+		nets = append(nets, parseCIDR(s))
+	}
+
+	return func(next phi.HandlerFunc) phi.HandlerFunc {
+		return func(ctx *fasthttp.RequestCtx) {
+			if rctx, ok := ctx.UserValue(phi.RouteCtxKey).(*phi.Context); ok {
+				rctx.ClientIP, rctx.Scheme, rctx.Host = resolveClient(ctx, nets)
+			}
+			next(ctx)
+		}
+	}
+}
+
+// forwardedHop is a single proxy hop, as recorded by forwarding headers.
+type forwardedHop struct {
+	addr, proto, host string
+}
+
+func resolveClient(ctx *fasthttp.RequestCtx, trusted []*net.IPNet) (net.IP, string, string) {
+	ip := ctx.RemoteIP()
+	scheme := "http"
+	if ctx.IsTLS() {
+		scheme = "https"
+	}
+	host := string(ctx.Host())
+
+	if !isTrusted(ip, trusted) {
+		return ip, scheme, host
+	}
+
+	hops := forwardedHops(&ctx.Request.Header)
+
+	// Walk back from the connection peer as long as the hop is trusted.
+	idx := len(hops)
+	for idx > 0 && isTrusted(ip, trusted) {
+		hopIP := parseHopAddr(hops[idx-1].addr)
+		if hopIP == nil {
+			break
+		}
+		idx--
+		ip = hopIP
+	}
+
+	if idx < len(hops) {
+		if hops[idx].proto != "" {
+			scheme = strings.ToLower(hops[idx].proto)
+		}
+		if hops[idx].host != "" {
+			host = hops[idx].host
+		}
+	}
+
+	return ip, scheme, host
+}
+
+// forwardedHops returns the hops recorded in the request headers, ordered
+// from the client to the closest proxy.
+func forwardedHops(h *fasthttp.RequestHeader) []forwardedHop {
+	if v := h.Peek("Forwarded"); len(v) > 0 {
+		return parseForwarded(string(v))
+	}
+
+	if v := h.Peek(fasthttp.HeaderXForwardedFor); len(v) > 0 {
+		addrs := splitList(string(v))
+		protos := splitList(string(h.Peek("X-Forwarded-Proto")))
+		hosts := splitList(string(h.Peek("X-Forwarded-Host")))
+
+		hops := make([]forwardedHop, len(addrs))
+		for i, addr := range addrs {
+			hops[i] = forwardedHop{
+				addr:  addr,
+				proto: alignedValue(protos, i, len(addrs)),
+				host:  alignedValue(hosts, i, len(addrs)),
+			}
+		}
+		return hops
+	}
+
+	if v := h.Peek("X-Real-IP"); len(v) > 0 {
+		return []forwardedHop{{addr: string(bytes.TrimSpace(v))}}
+	}
+
+	return nil
+}
+
+// parseForwarded parses an RFC 7239 Forwarded header value.
+func parseForwarded(v string) []forwardedHop {
+	var hops []forwardedHop
+	for _, element := range splitList(v) {
+		var hop forwardedHop
+		for _, pair := range strings.Split(element, ";") {
+			i := strings.IndexByte(pair, '=')
+			if i < 0 {
+				continue
+			}
+			key := strings.ToLower(strings.TrimSpace(pair[:i]))
+			value := strings.Trim(strings.TrimSpace(pair[i+1:]), `"`)
+			switch key {
+			case "for":
+				hop.addr = value
+			case "proto":
+				hop.proto = value
+			case "host":
+				hop.host = value
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+// parseHopAddr parses a forwarded node, which may carry a port and, for
+// IPv6, brackets. Obfuscated and "unknown" nodes return nil.
+func parseHopAddr(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	return net.ParseIP(addr)
+}
+
+// alignedValue returns the i-th of n values when the list lines up with the
+// hop list, and the value set by the closest proxy otherwise.
+func alignedValue(values []string, i, n int) string {
+	switch {
+	case len(values) == 0:
+		return ""
+	case len(values) == n:
+		return values[i]
+	default:
+		return values[len(values)-1]
+	}
+}
+
+func splitList(v string) []string {
+	var list []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDR(s string) *net.IPNet {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			panic(fmt.Sprintf("phi/middleware: invalid trusted proxy '%s'", s))
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	}
+
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(fmt.Sprintf("phi/middleware: invalid trusted proxy '%s'", s))
+	}
+	return n
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestRealIP(t *testing.T) {
+	// Requests from the test binder come from 10.0.0.1.
+	r := phi.NewRouter()
+	r.Use(RealIP("10.0.0.0/8", "192.168.1.1"))
+	r.Get("/", func(ctx *fasthttp.RequestCtx) {
+		rctx := phi.RouteContext(ctx)
+		ctx.WriteString(rctx.ClientIP.String() + " " + rctx.Scheme + " " + rctx.Host)
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.GET("/").Expect().Status(200).Text().Equal("10.0.0.1 http example.com")
+
+	e.GET("/").WithHeader("X-Real-IP", "203.0.113.7").
+		Expect().Status(200).Text().Equal("203.0.113.7 http example.com")
+
+	e.GET("/").
+		WithHeader("X-Forwarded-For", "198.51.100.1, 203.0.113.7, 192.168.1.1").
+		WithHeader("X-Forwarded-Proto", "https").
+		WithHeader("X-Forwarded-Host", "api.example.org").
+		Expect().Status(200).Text().Equal("203.0.113.7 https api.example.org")
+
+	e.GET("/").
+		WithHeader("X-Forwarded-For", "198.51.100.1, 203.0.113.7, 192.168.1.1").
+		WithHeader("X-Forwarded-Proto", "http, https").
+		Expect().Status(200).Text().Equal("203.0.113.7 https example.com")
+
+	e.GET("/").
+		WithHeader("X-Forwarded-For", "203.0.113.7").
+		WithHeader("X-Forwarded-Proto", "https").
+		WithHeader("X-Forwarded-Host", "api.example.org").
+		Expect().Status(200).Text().Equal("203.0.113.7 https api.example.org")
+
+	e.GET("/").
+		WithHeader("Forwarded", `for="[2001:db8:cafe::17]:4711";proto=https;host=api.example.org, for=192.168.1.1`).
+		WithHeader("X-Forwarded-For", "198.51.100.1").
+		Expect().Status(200).Text().Equal("2001:db8:cafe::17 https api.example.org")
+
+	e.GET("/").WithHeader("Forwarded", "for=unknown, for=192.168.1.1").
+		Expect().Status(200).Text().Equal("192.168.1.1 http example.com")
+}
+
+func TestRealIPUntrustedPeer(t *testing.T) {
+	r := phi.NewRouter()
+	r.Use(RealIP("172.16.0.0/12"))
+	r.Get("/", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString(phi.RouteContext(ctx).ClientIP.String())
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.GET("/").WithHeader("X-Forwarded-For", "203.0.113.7").
+		Expect().Status(200).Text().Equal("10.0.0.1")
+}
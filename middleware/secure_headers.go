@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	// CSPNonceCtxKey is the context key holding the Content-Security-Policy
+	// nonce of the request, see CSPNonce.
+	CSPNonceCtxKey = (&contextKey{"CSPNonce"}).String()
+)
+
+// SecureHeadersConfig configures the SecureHeaders middleware. Zero values
+// turn the matching feature off.
+type SecureHeadersConfig struct {
+	// AllowedHosts lists the accepted Host values. Requests for other hosts
+	// are handled by BadHostHandler, which responds with a 400 Bad Request
+	// by default.
+	AllowedHosts   []string
+	BadHostHandler phi.HandlerFunc
+
+	// SSLRedirect redirects plain HTTP requests to HTTPS, optionally to
+	// SSLHost instead of the request host.
+	SSLRedirect bool
+	SSLHost     string
+
+	// Strict-Transport-Security, only sent over HTTPS.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+
+	// ContentSecurityPolicy is sent as is, except for `{nonce}` placeholders
+	// which are replaced by a fresh nonce on each request, see CSPNonce.
+	ContentSecurityPolicy string
+
+	// X-Frame-Options, e.g. "DENY" or "SAMEORIGIN".
+	FrameOptions string
+
+	// ContentTypeNosniff sends `X-Content-Type-Options: nosniff`.
+	ContentTypeNosniff bool
+
+	// Referrer-Policy and Permissions-Policy header values.
+	ReferrerPolicy    string
+	PermissionsPolicy string
+
+	// Cross-Origin-Opener-Policy and Cross-Origin-Embedder-Policy header
+	// values.
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+}
+
+// SecureHeaders is a middleware setting security related response headers,
+// redirecting to HTTPS and checking the request host, e.g.
+//
+//  r.Use(middleware.SecureHeaders(middleware.SecureHeadersConfig{
+//    AllowedHosts:          []string{"example.com"},
+//    SSLRedirect:           true,
+//    HSTSMaxAge:            365 * 24 * time.Hour,
+//    ContentSecurityPolicy: "script-src 'self' 'nonce-{nonce}'",
+//    FrameOptions:          "DENY",
+//    ContentTypeNosniff:    true,
+//    ReferrerPolicy:        "strict-origin-when-cross-origin",
+//  }))
+//
+// When phi.Context has the scheme and host resolved, by the RealIP
+// middleware, they're used over the connection ones.
+func SecureHeaders(cfg SecureHeadersConfig) phi.Middleware {
+	if cfg.BadHostHandler == nil {
+		cfg.BadHostHandler = errorHandler(fasthttp.StatusBadRequest)
+	}
+
+	hsts := ""
+	if cfg.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.FormatInt(int64(cfg.HSTSMaxAge/time.Second), 10)
+		if cfg.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if cfg.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+	useNonce := strings.Contains(cfg.ContentSecurityPolicy, "{nonce}")
+
+	return func(next phi.HandlerFunc) phi.HandlerFunc {
+		return func(ctx *fasthttp.RequestCtx) {
+			scheme, host := requestSchemeHost(ctx)
+
+			if len(cfg.AllowedHosts) > 0 && !hostAllowed(cfg.AllowedHosts, host) {
+				cfg.BadHostHandler(ctx)
+				return
+			}
+
+			if cfg.SSLRedirect && scheme != "https" {
+				if cfg.SSLHost != "" {
+					host = cfg.SSLHost
+				}
+				status := fasthttp.StatusMovedPermanently
+				if !ctx.IsGet() && !ctx.IsHead() {
+					status = fasthttp.StatusPermanentRedirect
+				}
+				ctx.Redirect("https://"+host+string(ctx.URI().RequestURI()), status)
+				return
+			}
+
+			h := &ctx.Response.Header
+			if hsts != "" && scheme == "https" {
+				h.Set(fasthttp.HeaderStrictTransportSecurity, hsts)
+			}
+			if cfg.ContentSecurityPolicy != "" {
+				csp := cfg.ContentSecurityPolicy
+				if useNonce {
+					nonce := newNonce()
+					ctx.SetUserValue(CSPNonceCtxKey, nonce)
+					csp = strings.Replace(csp, "{nonce}", nonce, -1)
+				}
+				h.Set(fasthttp.HeaderContentSecurityPolicy, csp)
+			}
+			if cfg.FrameOptions != "" {
+				h.Set(fasthttp.HeaderXFrameOptions, cfg.FrameOptions)
+			}
+			if cfg.ContentTypeNosniff {
+				h.Set(fasthttp.HeaderXContentTypeOptions, "nosniff")
+			}
+			if cfg.ReferrerPolicy != "" {
+				h.Set(fasthttp.HeaderReferrerPolicy, cfg.ReferrerPolicy)
+			}
+			if cfg.PermissionsPolicy != "" {
+				h.Set("Permissions-Policy", cfg.PermissionsPolicy)
+			}
+			if cfg.CrossOriginOpenerPolicy != "" {
+				h.Set("Cross-Origin-Opener-Policy", cfg.CrossOriginOpenerPolicy)
+			}
+			if cfg.CrossOriginEmbedderPolicy != "" {
+				h.Set("Cross-Origin-Embedder-Policy", cfg.CrossOriginEmbedderPolicy)
+			}
+
+			next(ctx)
+		}
+	}
+}
+
+// CSPNonce returns the Content-Security-Policy nonce of the request, to be
+// set on inline scripts and styles:
+//
+//  <script nonce="{{ .CSPNonce }}">...</script>
+func CSPNonce(ctx *fasthttp.RequestCtx) string {
+	nonce, _ := ctx.UserValue(CSPNonceCtxKey).(string)
+	return nonce
+}
+
+// requestSchemeHost returns the scheme and host of the request, preferring
+// the ones resolved on phi.Context behind proxies.
+func requestSchemeHost(ctx *fasthttp.RequestCtx) (string, string) {
+	scheme, host := "http", string(ctx.Host())
+	if ctx.IsTLS() {
+		scheme = "https"
+	}
+	if rctx, ok := ctx.UserValue(phi.RouteCtxKey).(*phi.Context); ok {
+		if rctx.Scheme != "" {
+			scheme = rctx.Scheme
+		}
+		if rctx.Host != "" {
+			host = rctx.Host
+		}
+	}
+	return scheme, host
+}
+
+func hostAllowed(allowed []string, host string) bool {
+	// Ignore the port, unless the allowed host has one.
+	hostname := host
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.HasSuffix(host, "]") {
+		hostname = host[:i]
+	}
+	for _, h := range allowed {
+		if strings.EqualFold(h, host) || strings.EqualFold(h, hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("phi/middleware: failed to generate nonce: " + err.Error())
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
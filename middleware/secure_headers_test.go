@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+func TestSecureHeaders(t *testing.T) {
+	r := phi.NewRouter()
+	r.Use(RealIP("10.0.0.0/8"))
+	r.Use(SecureHeaders(SecureHeadersConfig{
+		AllowedHosts:              []string{"example.com"},
+		SSLRedirect:               true,
+		HSTSMaxAge:                365 * 24 * time.Hour,
+		HSTSIncludeSubdomains:     true,
+		HSTSPreload:               true,
+		ContentSecurityPolicy:     "script-src 'self' 'nonce-{nonce}'",
+		FrameOptions:              "DENY",
+		ContentTypeNosniff:        true,
+		ReferrerPolicy:            "no-referrer",
+		PermissionsPolicy:         "geolocation=()",
+		CrossOriginOpenerPolicy:   "same-origin",
+		CrossOriginEmbedderPolicy: "require-corp",
+	}))
+	r.Get("/", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString(CSPNonce(ctx))
+	})
+	r.Post("/", func(ctx *fasthttp.RequestCtx) {})
+
+	e := newFastHTTPTester(t, r)
+
+	e.GET("/").WithHost("evil.com").WithHeader("X-Forwarded-Proto", "https").Expect().Status(400)
+
+	resp := e.GET("/").
+		WithHeader("X-Forwarded-For", "203.0.113.7").
+		WithHeader("X-Forwarded-Proto", "https").
+		WithHeader("X-Forwarded-Host", "example.com").
+		Expect().Status(200)
+	nonce := resp.Text().NotEmpty().Raw()
+	resp.Header("Content-Security-Policy").Equal("script-src 'self' 'nonce-" + nonce + "'")
+	resp.Header("Strict-Transport-Security").Equal("max-age=31536000; includeSubDomains; preload")
+	resp.Header("X-Frame-Options").Equal("DENY")
+	resp.Header("X-Content-Type-Options").Equal("nosniff")
+	resp.Header("Referrer-Policy").Equal("no-referrer")
+	resp.Header("Permissions-Policy").Equal("geolocation=()")
+	resp.Header("Cross-Origin-Opener-Policy").Equal("same-origin")
+	resp.Header("Cross-Origin-Embedder-Policy").Equal("require-corp")
+
+	// nonces are unique per request
+	e.GET("/").
+		WithHeader("X-Forwarded-For", "203.0.113.7").
+		WithHeader("X-Forwarded-Proto", "https").
+		WithHeader("X-Forwarded-Host", "example.com").
+		Expect().Status(200).Text().NotEqual(nonce)
+}
+
+func TestSecureHeadersSSLRedirect(t *testing.T) {
+	h := SecureHeaders(SecureHeadersConfig{SSLRedirect: true, HSTSMaxAge: time.Second})(func(ctx *fasthttp.RequestCtx) {})
+
+	for _, tt := range []struct {
+		method string
+		status int
+	}{
+		{"GET", fasthttp.StatusMovedPermanently},
+		{"POST", fasthttp.StatusPermanentRedirect},
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(tt.method)
+		ctx.Request.SetRequestURI("http://example.com/path?q=1")
+		h(ctx)
+		if ctx.Response.StatusCode() != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.method, tt.status, ctx.Response.StatusCode())
+		}
+		loc := string(ctx.Response.Header.Peek("Location"))
+		if loc != "https://example.com/path?q=1" {
+			t.Errorf("%s: unexpected location %q", tt.method, loc)
+		}
+		if strings.Contains(ctx.Response.Header.String(), "Strict-Transport-Security") {
+			t.Errorf("%s: HSTS must not be sent over plain http", tt.method)
+		}
+	}
+}
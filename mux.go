@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/valyala/fasthttp"
 )
@@ -19,8 +20,8 @@ var _ Router = &Mux{}
 // particularly useful for writing large REST API services that break a handler
 // into many smaller parts composed of middlewares and end handlers.
 type Mux struct {
-	// The radix trie router
-	tree *node
+	// The radix trie router, shared with the inline muxes
+	tree *routingTree
 
 	// The middleware stack
 	middlewares Middlewares
@@ -30,9 +31,21 @@ type Mux struct {
 	inline bool
 	parent *Mux
 
+	// Route metadata declared with Describe on an inline mux, recorded
+	// on every route registered through it.
+	meta RouteMeta
+
+	// Pattern prefix of the routes registered through an inline mux,
+	// see Prefix
+	prefix string
+
+	// API version resolution, see Versioning
+	versioning *VersionConfig
+
 	// The computed mux handler made of the chained middleware stack and
-	// the tree router
-	handler Handler
+	// the tree router. It's published atomically, as AddRoute may build it
+	// while the router is serving requests.
+	handler atomic.Value // muxHandler
 
 	// Routing context pool
 	pool sync.Pool
@@ -47,7 +60,7 @@ type Mux struct {
 // NewMux returns a newly initialized Mux object that implements the Router
 // interface.
 func NewMux() *Mux {
-	mux := &Mux{tree: &node{}}
+	mux := &Mux{tree: newRoutingTree()}
 	mux.pool.New = func() interface{} {
 		return NewRouteContext()
 	}
@@ -59,26 +72,27 @@ func NewMux() *Mux {
 // reuse routing contexts for each request.
 func (mx *Mux) ServeFastHTTP(ctx *fasthttp.RequestCtx) {
 	// Ensure the mux has some routes defined on the mux
-	if mx.handler == nil {
+	handler := mx.routeHandler()
+	if handler == nil {
 		panic("phi: attempting to route to a mux with no handlers.")
 	}
 
 	// Check if a routing context already exists from a parent router.
-	rctx, _ := ctx.UserValue(RouteCtxKey).(*Context)
+	rctx, _ := ctx.UserValue(routeCtxKey).(*Context)
 	if rctx != nil {
-		mx.handler.ServeFastHTTP(ctx)
+		handler.ServeFastHTTP(ctx)
 		return
 	}
 
 	// Fetch a RouteContext object from the sync pool, and call the computed
-	// mx.handler that is comprised of mx.middlewares + mx.routeHTTP.
+	// mux handler that is comprised of mx.middlewares + mx.routeHTTP.
 	// Once the request is finished, reset the routing context and put it back
 	// into the pool for reuse from another request.
 	rctx = mx.pool.Get().(*Context)
 	rctx.Reset()
 	rctx.Routes = mx
-	ctx.SetUserValue(RouteCtxKey, rctx)
-	mx.handler.ServeFastHTTP(ctx)
+	ctx.SetUserValue(routeCtxKey, rctx)
+	handler.ServeFastHTTP(ctx)
 	mx.pool.Put(rctx)
 }
 
@@ -89,7 +103,7 @@ func (mx *Mux) ServeFastHTTP(ctx *fasthttp.RequestCtx) {
 // change the course of the request execution, or set request-scoped values for
 // the next phi.Handler.
 func (mx *Mux) Use(middlewares ...Middleware) {
-	if mx.handler != nil {
+	if mx.routeHandler() != nil {
 		panic("phi: all middlewares must be defined before routes on a mux")
 	}
 	mx.middlewares = append(mx.middlewares, middlewares...)
@@ -98,13 +112,13 @@ func (mx *Mux) Use(middlewares ...Middleware) {
 // Handle adds the route `pattern` that matches any http method to
 // execute the `handler` phi.Handler.
 func (mx *Mux) Handle(pattern string, handler HandlerFunc) {
-	mx.handle(mALL, pattern, handler)
+	mx.handle(mx.tree.methods(), pattern, handler)
 }
 
 // Method adds the route `pattern` that matches `method` http method to
 // execute the `handler` phi.Handler.
 func (mx *Mux) Method(method, pattern string, handler HandlerFunc) {
-	m, ok := methodMap[strings.ToUpper(method)]
+	m, ok := mx.method(method)
 	if !ok {
 		panic(fmt.Sprintf("phi: '%s' http method is not supported.", method))
 	}
@@ -209,7 +223,7 @@ func (mx *Mux) MethodNotAllowed(handlerFn HandlerFunc) {
 func (mx *Mux) With(middlewares ...Middleware) Router {
 	// Similarly as in handle(), we must build the mux handler once further
 	// middleware registration isn't allowed for this stack, like now.
-	if !mx.inline && mx.handler == nil {
+	if !mx.inline {
 		mx.buildRouteHandler()
 	}
 
@@ -221,10 +235,60 @@ func (mx *Mux) With(middlewares ...Middleware) Router {
 	}
 	mws = append(mws, middlewares...)
 
-	im := &Mux{inline: true, parent: mx, tree: mx.tree, middlewares: mws}
+	im := &Mux{inline: true, parent: mx, tree: mx.tree, middlewares: mws, prefix: mx.prefix}
+	if mx.inline {
+		im.meta = mx.meta.clone()
+	}
+	return im
+}
+
+// Describe creates a new inline-Mux, like With, whose routes carry the
+// metadata set by `fn`. Metadata is inherited by nested inline-Muxes and
+// reported by Routes(). The middlewares `fn` installs with RouteMeta.Use
+// are appended to the stack, e.g. the ones enforcing the media types:
+//
+//  r.Describe(middleware.DescribeContentType("application/json")).
+//    Post("/articles", createArticle)
+func (mx *Mux) Describe(fn func(m *RouteMeta)) Router {
+	im := mx.With().(*Mux)
+	fn(&im.meta)
+	im.middlewares = append(im.middlewares, im.meta.middlewares...)
+	im.meta.middlewares = nil
 	return im
 }
 
+// Priority creates a new inline-Mux, like With, whose routes are
+// registered with the `priority`, the default being 0. Their
+// RouteMeta.Priority is set.
+//
+// A request path is matched segment by segment, trying the routes in this
+// order, which Routes() reports:
+//
+//  1. static segments, e.g. /users/me
+//  2. regexp params, e.g. /users/{id:[0-9]+}
+//  3. params, e.g. /users/{name}
+//  4. catch-alls, e.g. /users/*
+//
+// Among regexp params, or params, the routes with the highest priority
+// are tried first, then the ones whose param ends with a delimiter other
+// than '/', e.g. /files/{name}.json, and then the routes registered
+// first. The first param matching the segment is used, the following ones
+// aren't tried, unless it's a regexp which may match its delimiter, e.g.
+// {path:.+} in /repos/{path:.+}/info: its values are ended at each
+// delimiter, the longest first, and the following params are tried if
+// none leads to a route. For instance:
+//
+//  r.Get("/articles/{slug:[a-z-]+}", getArticleBySlug)
+//  r.Priority(1).Get("/articles/{date:[0-9-]+}", getArticlesByDate)
+//
+// routes /articles/2020-01-01 to getArticlesByDate, though its segment
+// matches both regexps.
+func (mx *Mux) Priority(priority int) Router {
+	return mx.Describe(func(m *RouteMeta) {
+		m.Priority = priority
+	})
+}
+
 // Group creates a new inline-Mux with a fresh middleware stack. It's useful
 // for a group of handlers along the same routing path that use an additional
 // set of middlewares. See _examples/.
@@ -233,11 +297,35 @@ func (mx *Mux) Group(fn func(r Router)) {
 	fn(im)
 }
 
+// Prefix creates a new inline-Mux with a fresh middleware stack, like Group,
+// whose routes are registered along the `pattern` prefix. Unlike Route, the
+// routes are inserted in the routing tree of the mux rather than in a
+// mounted sub-router, so their RoutePattern is a single pattern and Routes,
+// Match and Walk see them as routes of the mux. For instance:
+//
+//  r.Prefix("/users/{id}", func(r phi.Router) {
+//    r.Get("", getUser)         // GET /users/{id}
+//    r.Get("/posts", listPosts) // GET /users/{id}/posts
+//  })
+//
+// The patterns are joined as is, "/" routes /users/{id}/ only, so they
+// must be empty or begin with '/': registering "posts" panics.
+func (mx *Mux) Prefix(pattern string, fn func(r Router)) {
+	if len(pattern) == 0 || pattern[0] != '/' {
+		panic(fmt.Sprintf("phi: routing pattern must begin with '/' in '%s'", pattern))
+	}
+	im := mx.With().(*Mux)
+	im.prefix += strings.TrimSuffix(pattern, "/")
+	fn(im)
+}
+
 // Route creates a new Mux with a fresh middleware stack and mounts it
 // along the `pattern` as a subrouter. Effectively, this is a short-hand
 // call to Mount. See _examples/.
 func (mx *Mux) Route(pattern string, fn func(r Router)) {
 	subRouter := NewRouter()
+	subRouter.tree.strict = mx.tree.strict
+	subRouter.tree.addMethods(mx.tree.methods())
 	fn(subRouter)
 	mx.Mount(pattern, subRouter)
 }
@@ -252,12 +340,20 @@ func (mx *Mux) Route(pattern string, fn func(r Router)) {
 func (mx *Mux) Mount(pattern string, handler Handler) { // nolint: gocyclo
 	// Provide runtime safety for ensuring a pattern isn't mounted on an existing
 	// routing pattern.
-	if mx.tree.findPattern(pattern+"*") || mx.tree.findPattern(pattern+"/*") {
-		panic(fmt.Sprintf("phi: attempting to Mount() a handler on an existing path, '%s'", pattern))
+	full, err := mx.prefixed(pattern)
+	if err != nil {
+		panic(err.Error())
+	}
+	if mx.tree.load().findPattern(full+"*") || mx.tree.load().findPattern(full+"/*") {
+		panic(fmt.Sprintf("phi: attempting to Mount() a handler on an existing path, '%s'", full))
 	}
 
-	// Assign sub-Router's with the parent not found & method not allowed handler if not specified.
+	// Assign sub-Router's with the parent not found & method not allowed handler if not specified,
+	// and the methods the parent supports.
 	subr, ok := handler.(*Mux)
+	if ok {
+		subr.tree.addMethods(mx.tree.methods())
+	}
 	if ok && subr.notFoundHandler == nil && mx.notFoundHandler != nil {
 		subr.NotFound(mx.notFoundHandler)
 	}
@@ -277,12 +373,12 @@ func (mx *Mux) Mount(pattern string, handler Handler) { // nolint: gocyclo
 			mx.NotFoundHandler().ServeFastHTTP(ctx)
 		})
 
-		mx.handle(mALL|mSTUB, pattern, mountHandler)
-		mx.handle(mALL|mSTUB, pattern+"/", notFoundHandler)
+		mx.handle(mx.tree.methods()|mSTUB, pattern, mountHandler)
+		mx.handle(mx.tree.methods()|mSTUB, pattern+"/", notFoundHandler)
 		pattern += "/"
 	}
 
-	method := mALL
+	method := mx.tree.methods()
 	subroutes, _ := handler.(Routes)
 	if subroutes != nil {
 		method |= mSTUB
@@ -292,12 +388,19 @@ func (mx *Mux) Mount(pattern string, handler Handler) { // nolint: gocyclo
 	if subroutes != nil {
 		n.subroutes = subroutes
 	}
+
+	// In strict mode, check the routes of the sub-router along the pattern
+	if err := mx.strictCheck(mx.tree.load(), func(d Diagnostic) bool {
+		return strings.HasPrefix(d.Pattern, full)
+	}, full+"*", full+"/*"); err != nil {
+		panic(err.Error())
+	}
 }
 
 // Routes returns a slice of routing information from the tree,
 // useful for traversing available routes of a router.
 func (mx *Mux) Routes() []Route {
-	return mx.tree.routes()
+	return mx.tree.load().routes()
 }
 
 // Middlewares returns a slice of middleware handler functions.
@@ -312,12 +415,12 @@ func (mx *Mux) Middlewares() Middlewares {
 // Note: the *Context state is updated during execution, so manage
 // the state carefully or make a NewRouteContext().
 func (mx *Mux) Match(rctx *Context, method, path string) bool {
-	m, ok := methodMap[method]
+	m, ok := mx.method(method)
 	if !ok {
 		return false
 	}
 
-	node, _, h := mx.tree.FindRoute(rctx, m, path)
+	node, _, h := mx.tree.load().FindRoute(rctx, m, path)
 
 	if node != nil && node.subroutes != nil {
 		rctx.RoutePath = mx.nextRoutePath(rctx)
@@ -350,32 +453,82 @@ func (mx *Mux) MethodNotAllowedHandler() HandlerFunc {
 // point, no other middlewares can be registered on this Mux's stack. But you can still
 // compose additional middlewares via Group()'s or using a chained middleware handler.
 func (mx *Mux) buildRouteHandler() {
-	mx.handler = chain(mx.middlewares, HandlerFunc(mx.routeHTTP))
+	if mx.routeHandler() == nil {
+		mx.handler.CompareAndSwap(nil, muxHandler{chain(mx.middlewares, HandlerFunc(mx.routeHTTP))})
+	}
+}
+
+// muxHandler wraps the mux handler, to store it in an atomic.Value.
+type muxHandler struct {
+	Handler
+}
+
+// routeHandler returns the mux handler, or nil until it's built.
+func (mx *Mux) routeHandler() Handler {
+	h, _ := mx.handler.Load().(muxHandler)
+	return h.Handler
 }
 
 // handle registers a phi.Handler in the routing tree for a particular http method
 // and routing pattern.
 func (mx *Mux) handle(method methodTyp, pattern string, handler Handler) *node {
+	pattern, err := mx.prefixed(pattern)
+	if err != nil {
+		panic(err.Error())
+	}
 	if len(pattern) == 0 || pattern[0] != '/' {
 		panic(fmt.Sprintf("phi: routing pattern must begin with '/' in '%s'", pattern))
 	}
 
 	// Build the final routing handler for this Mux.
-	if !mx.inline && mx.handler == nil {
+	if !mx.inline {
 		mx.buildRouteHandler()
 	}
 
+	// Add the endpoint to the tree and return the node
+	mx.tree.mu.Lock()
+	defer mx.tree.mu.Unlock()
+	if mx.tree.frozen {
+		panic(fmt.Sprintf("%s, can't register '%s'", ErrFrozen, pattern))
+	}
+	root := mx.tree.load()
+	if err := mx.recordDuplicate(root, method, pattern); err != nil {
+		panic(err.Error())
+	}
+	n := mx.insertRoute(root, method, pattern, handler)
+	if err := mx.strictCheck(root, isRouteDiagnostic(pattern), pattern); err != nil {
+		panic(err.Error())
+	}
+	return n
+}
+
+// prefixed returns the `pattern` joined to the prefix of the mux, see
+// Prefix. Along a prefix, the pattern must be empty or begin with '/'.
+func (mx *Mux) prefixed(pattern string) (string, error) {
+	if mx.prefix != "" && pattern != "" && pattern[0] != '/' {
+		return "", fmt.Errorf("phi: routing pattern must be empty or begin with '/' in '%s' along the prefix '%s'", pattern, mx.prefix)
+	}
+	return mx.prefix + pattern, nil
+}
+
+// insertRoute adds the endpoint to the tree rooted at `root`, with the
+// inline middlewares and metadata of the mux.
+func (mx *Mux) insertRoute(root *node, method methodTyp, pattern string, handler Handler) *node {
 	// Build endpoint handler with inline middlewares for the route
 	var h Handler
 	if mx.inline {
-		mx.handler = HandlerFunc(mx.routeHTTP)
+		mx.handler.Store(muxHandler{HandlerFunc(mx.routeHTTP)})
 		h = Chain(mx.middlewares...).Handler(handler)
 	} else {
 		h = handler
 	}
 
-	// Add the endpoint to the tree and return the node
-	return mx.tree.InsertRoute(method, pattern, h)
+	var meta *RouteMeta
+	if mx.inline && !mx.meta.isZero() {
+		m := mx.meta.clone()
+		meta = &m
+	}
+	return root.insertRoute(method, pattern, meta, h)
 }
 
 // routeHTTP routes a phi.Request through the Mux routing tree to serve
@@ -384,24 +537,40 @@ func (mx *Mux) routeHTTP(ctx *fasthttp.RequestCtx) {
 	// Grab the route context object
 	rctx := RouteContext(ctx)
 
-	// The request routing path
+	// The request routing path, a view of the copy kept by the context
+	// to route without allocating
 	routePath := rctx.RoutePath
 	if routePath == "" {
-		routePath = string(ctx.Path())
+		rctx.path = append(rctx.path[:0], ctx.Path()...)
+		routePath = b2s(rctx.path)
+	}
+
+	// Resolve the API version, unless a parent router did
+	if mx.versioning != nil && rctx.Version == "" {
+		routePath = mx.resolveVersion(ctx, rctx, routePath)
 	}
 
 	// Check if method is supported by phi
-	if rctx.RouteMethod == "" {
-		rctx.RouteMethod = string(ctx.Method())
+	var method methodTyp
+	var ok bool
+	if rctx.RouteMethod != "" {
+		method, ok = lookupMethod(rctx.RouteMethod)
+	} else {
+		method, ok = methodTypOf(ctx.Method())
 	}
-	method, ok := methodMap[rctx.RouteMethod]
-	if !ok {
+	if !ok || mx.tree.methods()&method == 0 {
 		mx.MethodNotAllowedHandler().ServeFastHTTP(ctx)
 		return
 	}
 
-	// Find the route
-	if _, _, h := mx.tree.FindRoute(rctx, method, routePath); h != nil {
+	// Find the route, in the static routes first once frozen
+	if st := mx.tree.staticTable(); st != nil {
+		if h := st.FindRoute(rctx, method, routePath); h != nil {
+			h.ServeFastHTTP(ctx)
+			return
+		}
+	}
+	if _, _, h := mx.tree.load().FindRoute(rctx, method, routePath); h != nil {
 		h.ServeFastHTTP(ctx)
 		return
 	}
@@ -412,18 +581,15 @@ func (mx *Mux) routeHTTP(ctx *fasthttp.RequestCtx) {
 	}
 }
 
+// nextRoutePath returns the routing path of a mounted sub-router: the
+// value of the catch-all param matched, whatever its name.
 func (mx *Mux) nextRoutePath(rctx *Context) string {
-	routePath := "/"
-	nx := len(rctx.routeParams.Keys) - 1 // index of last param in list
-	if nx >= 0 && rctx.routeParams.Keys[nx] == "*" && len(rctx.routeParams.Values) > nx {
-		routePath += rctx.routeParams.Values[nx]
-	}
-	return routePath
+	return "/" + rctx.routeWildcard
 }
 
 // Recursively update data on child routers.
 func (mx *Mux) updateSubRoutes(fn func(subMux *Mux)) {
-	for _, r := range mx.tree.routes() {
+	for _, r := range mx.tree.load().routes() {
 		subMux, ok := r.SubRoutes.(*Mux)
 		if !ok {
 			continue
@@ -1,8 +1,14 @@
 package phi
 
 import (
+	"fmt"
 	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gavv/httpexpect"
 	"github.com/valyala/fasthttp"
@@ -62,10 +68,168 @@ func TestMuxURLParams(t *testing.T) {
 		ctx.WriteString("sub" + URLParam(ctx, "name"))
 	})
 
+	r.Route("/orgs/{id}", func(r Router) {
+		r.Use(func(next HandlerFunc) HandlerFunc {
+			return func(ctx *fasthttp.RequestCtx) {
+				if string(ctx.QueryArgs().Peek("add")) != "" {
+					RouteContext(ctx).URLParams.Add("id", "added")
+				}
+				next(ctx)
+			}
+		})
+		r.Get("/{a}/{b}/{c}/{d}/{e}/{f}/{g}/{h}/{id}", func(ctx *fasthttp.RequestCtx) {
+			ctx.WriteString(URLParam(ctx, "a") + URLParam(ctx, "h") + URLParam(ctx, "id") + URLParam(ctx, "x"))
+		})
+	})
+
 	e := newFastHTTPTester(t, r)
 	e.GET("/hello").Expect().Status(200).Text().Equal("hello")
 	e.GET("/hello/all").Expect().Status(404)
 	e.GET("/sub/hello").Expect().Status(200).Text().Equal("subhello")
+
+	// the last param of a key is used, past the inline storage too
+	e.GET("/orgs/1/a/b/c/d/e/f/g/h/9").Expect().Status(200).Text().Equal("ah9")
+	e.GET("/orgs/1/a/b/c/d/e/f/g/h/9").WithQuery("add", 1).Expect().Status(200).Text().Equal("ah9")
+}
+
+func TestMuxOptionalParams(t *testing.T) {
+	r := NewRouter()
+	r.Get("/posts/{id}/{slug?}", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString(URLParam(ctx, "id") + ":" + URLParam(ctx, "slug") + ":" + RouteContext(ctx).RoutePattern())
+	})
+	r.Get("/search/{page=1:[0-9]+}", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("page " + URLParam(ctx, "page"))
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.GET("/posts/1").Expect().Status(200).Text().Equal("1::/posts/{id}/{slug?}")
+	e.GET("/posts/1/hello").Expect().Status(200).Text().Equal("1:hello:/posts/{id}/{slug?}")
+	e.GET("/search").Expect().Status(200).Text().Equal("page 1")
+	e.GET("/search/2").Expect().Status(200).Text().Equal("page 2")
+	e.GET("/search/x").Expect().Status(404)
+
+	if routes := r.Routes(); len(routes) != 2 || routes[0].Pattern != "/posts/{id}/{slug?}" {
+		t.Fatalf("unexpected routes %v", routes)
+	}
+
+	if err := r.RemoveRoute("GET", "/posts/{id}/{slug?}"); err != nil {
+		t.Fatal(err)
+	}
+	e.GET("/posts/1").Expect().Status(404)
+	e.GET("/posts/1/hello").Expect().Status(404)
+}
+
+func TestMuxCatchAll(t *testing.T) {
+	r := NewRouter()
+	r.Get("/files/{path...}", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("file " + URLParam(ctx, "path"))
+	})
+	r.Get("/docs/{page...:.+\\.md}", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("doc " + URLParam(ctx, "page"))
+	})
+
+	sub := NewRouter()
+	sub.Get("/{file}", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString(URLParam(ctx, "repo") + " " + URLParam(ctx, "file"))
+	})
+	r.Route("/repos/{repo}", func(r Router) {
+		r.Mount("/tree", sub)
+		r.Handle("/blob/*rest", func(ctx *fasthttp.RequestCtx) {
+			ctx.WriteString("blob " + URLParam(ctx, "rest"))
+		})
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.GET("/files/a/b.txt").Expect().Status(200).Text().Equal("file a/b.txt")
+	e.GET("/docs/guide/intro.md").Expect().Status(200).Text().Equal("doc guide/intro.md")
+	e.GET("/docs/guide/intro.html").Expect().Status(404)
+	e.GET("/repos/phi/tree/README").Expect().Status(200).Text().Equal("phi README")
+	e.GET("/repos/phi/blob/a/b").Expect().Status(200).Text().Equal("blob a/b")
+}
+
+func TestMuxRouteContextLifetime(t *testing.T) {
+	var kept *Context
+	r := NewRouter()
+	r.Get("/users/{id}", func(ctx *fasthttp.RequestCtx) {
+		kept = RouteContext(ctx).clone()
+		ctx.WriteString(URLParam(ctx, "id") + " " + RouteContext(ctx).RoutePattern())
+	})
+	r.Route("/orgs/{org}", func(r Router) {
+		r.Get("/members/{id}", func(ctx *fasthttp.RequestCtx) {
+			ctx.WriteString(RouteContext(ctx).RoutePattern())
+		})
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.GET("/users/1").Expect().Status(200).Text().Equal("1 /users/{id}")
+	e.GET("/orgs/phi/members/2").Expect().Status(200).Text().Equal("/orgs/{org}/members/{id}")
+
+	// the copy doesn't share the path of the later requests
+	e.GET("/users/2").Expect().Status(200)
+	clone := kept
+	e.GET("/users/3").Expect().Status(200)
+	if clone.URLParam("id") != "2" {
+		t.Fatalf("expected the kept param 2, got %s", clone.URLParam("id"))
+	}
+}
+
+func TestMuxMethods(t *testing.T) {
+	text := func(s string) HandlerFunc {
+		return func(ctx *fasthttp.RequestCtx) {
+			ctx.WriteString(s)
+		}
+	}
+
+	r := NewRouter()
+	r.RegisterMethod("link")
+	r.Method("PROPFIND", "/dav", text("propfind"))
+	r.Method("LINK", "/link", text("link"))
+	r.Handle("/any", text("any"))
+	r.Route("/sub", func(r Router) {
+		r.Method("LINK", "/", text("sub link"))
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.Request("PROPFIND", "/dav").Expect().Status(200).Text().Equal("propfind")
+	e.Request("MKCOL", "/dav").Expect().Status(405)
+	e.Request("LINK", "/link").Expect().Status(200).Text().Equal("link")
+	e.Request("LINK", "/any").Expect().Status(200).Text().Equal("any")
+	e.Request("LINK", "/sub").Expect().Status(200).Text().Equal("sub link")
+
+	for _, rt := range r.Routes() {
+		if rt.Pattern == "/dav" && rt.Handlers["PROPFIND"] == nil {
+			t.Fatalf("expected a PROPFIND handler, got %v", rt.Handlers)
+		}
+		if rt.Pattern == "/link" && rt.Handlers["LINK"] == nil {
+			t.Fatalf("expected a LINK handler, got %v", rt.Handlers)
+		}
+	}
+
+	// the method isn't supported by other routers
+	other := NewRouter()
+	other.Handle("/any", text("any"))
+	newFastHTTPTester(t, other).Request("LINK", "/any").Expect().Status(405)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected LINK to be unsupported")
+			}
+		}()
+		other.Method("LINK", "/link", text("link"))
+	}()
+
+	// concurrent setup
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := NewRouter()
+			r.RegisterMethod(fmt.Sprintf("CUSTOM%d", i%2))
+			r.Method(fmt.Sprintf("CUSTOM%d", i%2), "/", text("custom"))
+		}(i)
+	}
+	wg.Wait()
 }
 
 func TestMuxUse(t *testing.T) {
@@ -110,6 +274,148 @@ func TestMuxWith(t *testing.T) {
 	e.GET("/with").Expect().Status(200).Text().Equal("ok+with")
 }
 
+func TestMuxDescribe(t *testing.T) {
+	r := NewRouter()
+	h := func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("ok")
+	}
+	r.Get("/plain", h)
+	r.Describe(func(m *RouteMeta) {
+		m.Produces = []string{"application/json"}
+	}).Group(func(r Router) {
+		r.Get("/articles", h)
+		r.Describe(func(m *RouteMeta) {
+			m.Consumes = []string{"application/json"}
+		}).Post("/articles", h)
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.GET("/articles").Expect().Status(200).Text().Equal("ok")
+	e.POST("/articles").Expect().Status(200).Text().Equal("ok")
+
+	for _, rt := range r.Routes() {
+		switch rt.Pattern {
+		case "/plain":
+			if rt.Meta != nil {
+				t.Errorf("expected no metadata for /plain, got %v", rt.Meta)
+			}
+		case "/articles":
+			get, post := rt.Meta["GET"], rt.Meta["POST"]
+			if !stringSliceEqual(get.Produces, []string{"application/json"}) || len(get.Consumes) != 0 {
+				t.Errorf("unexpected GET /articles metadata: %+v", get)
+			}
+			if !stringSliceEqual(post.Produces, []string{"application/json"}) ||
+				!stringSliceEqual(post.Consumes, []string{"application/json"}) {
+				t.Errorf("unexpected POST /articles metadata: %+v", post)
+			}
+		default:
+			t.Errorf("unexpected route %s", rt.Pattern)
+		}
+	}
+}
+
+func TestMuxVersioning(t *testing.T) {
+	text := func(s string) HandlerFunc {
+		return func(ctx *fasthttp.RequestCtx) {
+			ctx.WriteString(s + " " + URLParam(ctx, "id") + " " + RouteContext(ctx).Version)
+		}
+	}
+	sunset := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r := NewRouter()
+	r.Versioning(VersionConfig{
+		PathPrefix: true,
+		Vendor:     "acme",
+		Header:     "X-API-Version",
+		Default:    "1",
+		Deprecations: map[string]Deprecation{
+			"1": {Sunset: sunset, Link: "https://example.com/v2"},
+		},
+	})
+	r.Version("1").Get("/users/{id}", text("v1"))
+	r.Version("2").Get("/users/{uid}", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("v2 " + URLParam(ctx, "uid"))
+	})
+	r.Get("/health", text("health"))
+	r.Route("/articles", func(r Router) {
+		r.Version("2").Get("/", text("articles v2"))
+		r.Version("3").Get("/", text("articles v3"))
+	})
+
+	e := newFastHTTPTester(t, r)
+
+	res := e.GET("/users/1").Expect()
+	res.Status(200).Text().Equal("v1 1 1")
+	res.Header("Deprecation").Equal("true")
+	res.Header("Sunset").Equal("Tue, 01 Jan 2030 00:00:00 GMT")
+	res.Header("Link").Equal(`<https://example.com/v2>; rel="deprecation"`)
+
+	res = e.GET("/users/1").WithHeader("Accept", "application/vnd.acme.v2+json").Expect()
+	res.Status(200).Text().Equal("v2 1")
+	res.Header("Deprecation").Empty()
+	e.GET("/users/1").WithHeader("X-API-Version", "v2").Expect().Text().Equal("v2 1")
+	e.GET("/v2/users/1").Expect().Text().Equal("v2 1")
+	// the path prefix wins over headers
+	e.GET("/v1/users/1").WithHeader("X-API-Version", "2").Expect().Text().Equal("v1 1 1")
+
+	// unversioned routes serve every version, unknown versions fall back to
+	// the default one
+	e.GET("/v2/health").Expect().Text().Equal("health  2")
+	e.GET("/users/1").WithHeader("X-API-Version", "9").Expect().Status(200).Text().Equal("v1 1 9")
+
+	// sub-routers dispatch on the version resolved by the parent
+	e.GET("/v3/articles").Expect().Text().Equal("articles v3  3")
+	e.GET("/articles").WithHeader("X-API-Version", "2").Expect().Text().Equal("articles v2  2")
+	e.GET("/articles").Expect().Status(404)
+
+	versions := map[string]string{}
+	for _, rt := range r.Routes() {
+		if rt.Pattern == "/users/{id}" || rt.Pattern == "/users/{uid}" {
+			versions[rt.Pattern] = rt.Version + "/" + rt.Meta["GET"].Version
+		}
+	}
+	if versions["/users/{id}"] != "1/1" || versions["/users/{uid}"] != "2/2" {
+		t.Fatalf("unexpected route versions %v", versions)
+	}
+}
+
+func TestMuxPriority(t *testing.T) {
+	r := NewRouter()
+	r.Get("/articles/{id}", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("id")
+	})
+	r.Get("/articles/{slug:[a-z0-9-]+}", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("slug")
+	})
+	r.Priority(1).Get("/articles/{date:[0-9-]+}", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("date")
+	})
+	r.Get("/articles/new", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("new")
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.GET("/articles/new").Expect().Status(200).Text().Equal("new")
+	e.GET("/articles/2020-01-01").Expect().Status(200).Text().Equal("date")
+	e.GET("/articles/hello-world").Expect().Status(200).Text().Equal("slug")
+	e.GET("/articles/Hello").Expect().Status(200).Text().Equal("id")
+
+	var patterns []string
+	for i, rt := range r.Routes() {
+		if rt.Precedence != i {
+			t.Errorf("unexpected precedence %d of %s", rt.Precedence, rt.Pattern)
+		}
+		patterns = append(patterns, rt.Pattern)
+	}
+	want := []string{"/articles/new", "/articles/{date:[0-9-]+}", "/articles/{slug:[a-z0-9-]+}", "/articles/{id}"}
+	if !reflect.DeepEqual(patterns, want) {
+		t.Fatalf("unexpected routes order %v", patterns)
+	}
+	if meta := r.Routes()[1].Meta["GET"]; meta.Priority != 1 {
+		t.Fatalf("unexpected route meta %+v", meta)
+	}
+}
+
 func TestMuxGroup(t *testing.T) {
 	r := NewRouter()
 	r.Get("/", func(ctx *fasthttp.RequestCtx) {
@@ -136,6 +442,85 @@ func TestMuxGroup(t *testing.T) {
 	e.GET("/s2").Expect().Status(200).Text().Equal("s2+group")
 }
 
+func TestMuxPrefix(t *testing.T) {
+	pattern := func(ctx *fasthttp.RequestCtx) {
+		rctx := RouteContext(ctx)
+		ctx.WriteString(rctx.RoutePattern() + " " + URLParam(ctx, "id") + " " + fmt.Sprint(len(rctx.RoutePatterns)))
+	}
+
+	r := NewRouter()
+	r.Get("/", pattern)
+	r.Prefix("/users/{id}/", func(r Router) {
+		r.Use(func(next HandlerFunc) HandlerFunc {
+			return func(ctx *fasthttp.RequestCtx) {
+				next(ctx)
+				ctx.WriteString("+users")
+			}
+		})
+		r.Get("", pattern)
+		r.Get("/", pattern)
+		r.Prefix("/posts", func(r Router) {
+			r.With().Post("/{post}", pattern)
+		})
+		r.Route("/files", func(r Router) {
+			r.Get("/", pattern)
+		})
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.GET("/").Expect().Status(200).Text().Equal("/  1")
+	e.GET("/users/1").Expect().Status(200).Text().Equal("/users/{id} 1 1+users")
+	e.GET("/users/1/").Expect().Status(200).Text().Equal("/users/{id}/ 1 1+users")
+	e.POST("/users/1/posts/2").Expect().Status(200).Text().Equal("/users/{id}/posts/{post} 1 1+users")
+	e.GET("/users/1/files").Expect().Status(200).Text().Equal("/users/{id}/files/ 1 2+users")
+	e.GET("/users/1/posts").Expect().Status(404)
+
+	var routes []string
+	if err := Walk(r, func(method string, route string, handler Handler, middlewares ...Middleware) error {
+		routes = append(routes, method+" "+route)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(routes)
+	want := []string{"GET /", "GET /users/{id}", "GET /users/{id}/", "GET /users/{id}/files/*/", "POST /users/{id}/posts/{post}"}
+	if !reflect.DeepEqual(routes, want) {
+		t.Fatalf("unexpected routes %v", routes)
+	}
+	if !r.Match(NewRouteContext(), "POST", "/users/1/posts/2") {
+		t.Fatal("expected a match")
+	}
+
+	// runtime routes are registered along the prefix too
+	r.Prefix("/admin", func(r Router) {
+		if err := r.AddRoute("GET", "/stats", pattern); err != nil {
+			t.Fatal(err)
+		}
+	})
+	e.GET("/admin/stats").Expect().Status(200).Text().Equal("/admin/stats  1")
+
+	// the patterns along a prefix must be empty or begin with '/'
+	r.Prefix("/users", func(r Router) {
+		for name, register := range map[string]func(){
+			"get":   func() { r.Get("posts", pattern) },
+			"mount": func() { r.Mount("posts", NewRouter()) },
+		} {
+			func() {
+				defer func() {
+					if rec := recover(); rec == nil || !strings.Contains(fmt.Sprint(rec), "begin with '/'") {
+						t.Fatalf("%s: expected a panic, got %v", name, rec)
+					}
+				}()
+				register()
+			}()
+		}
+		if err := r.AddRoute("GET", "posts", pattern); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+	e.GET("/usersposts").Expect().Status(404)
+}
+
 func TestMuxRoute(t *testing.T) {
 	r := NewRouter()
 	r.Get("/", func(ctx *fasthttp.RequestCtx) {
@@ -188,6 +573,187 @@ func TestMuxMount(t *testing.T) {
 	e.GET("/admin/s1").Expect().Status(200).Text().Equal("s1+mount")
 }
 
+func TestMuxRuntimeRoutes(t *testing.T) {
+	r := NewRouter()
+	r.Get("/", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("index")
+	})
+
+	e := newFastHTTPTester(t, r)
+	e.GET("/plugin").Expect().Status(404)
+
+	auth := func(next HandlerFunc) HandlerFunc {
+		return func(ctx *fasthttp.RequestCtx) {
+			ctx.WriteString("auth+")
+			next(ctx)
+		}
+	}
+	err := r.With(auth).AddRoute("GET", "/plugin", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("v1")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.GET("/plugin").Expect().Status(200).Text().Equal("auth+v1")
+	e.POST("/plugin").Expect().Status(405)
+
+	if err := r.AddRoute("GET", "/plugin", func(ctx *fasthttp.RequestCtx) {}); err != ErrRouteExists {
+		t.Fatalf("expected ErrRouteExists, got %v", err)
+	}
+	if err := r.Replace("POST", "/plugin", func(ctx *fasthttp.RequestCtx) {}); err != ErrRouteNotFound {
+		t.Fatalf("expected ErrRouteNotFound, got %v", err)
+	}
+	if err := r.AddRoute("FOO", "/plugin", func(ctx *fasthttp.RequestCtx) {}); err == nil {
+		t.Fatal("expected an error for an unknown method")
+	}
+	if err := r.AddRoute("GET", "/{a}/{a}", func(ctx *fasthttp.RequestCtx) {}); err == nil {
+		t.Fatal("expected an error for an invalid pattern")
+	}
+
+	err = r.Replace("GET", "/plugin", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("v2")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.GET("/plugin").Expect().Status(200).Text().Equal("v2")
+
+	// "*" replaces the methods the route is registered for only
+	r.Get("/star", func(ctx *fasthttp.RequestCtx) {})
+	r.Post("/star", func(ctx *fasthttp.RequestCtx) {})
+	r.Handle("/any", func(ctx *fasthttp.RequestCtx) {})
+	for _, pattern := range []string{"/star", "/any"} {
+		if err := r.Replace("*", pattern, func(ctx *fasthttp.RequestCtx) {
+			ctx.WriteString("v3")
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.GET("/star").Expect().Status(200).Text().Equal("v3")
+	e.POST("/star").Expect().Status(200).Text().Equal("v3")
+	e.DELETE("/star").Expect().Status(405)
+	e.DELETE("/any").Expect().Status(200).Text().Equal("v3")
+	if err := r.Replace("*", "/none", func(ctx *fasthttp.RequestCtx) {}); err != ErrRouteNotFound {
+		t.Fatalf("expected ErrRouteNotFound, got %v", err)
+	}
+
+	if err := r.RemoveRoute("GET", "/plugin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RemoveRoute("GET", "/plugin"); err != ErrRouteNotFound {
+		t.Fatalf("expected ErrRouteNotFound, got %v", err)
+	}
+	e.GET("/plugin").Expect().Status(404)
+	e.GET("/").Expect().Status(200).Text().Equal("index")
+
+	// a request being routed keeps the tree it started with
+	removed := make(chan struct{})
+	r.Get("/slow/{id}", func(ctx *fasthttp.RequestCtx) {
+		<-removed
+		ctx.WriteString(URLParam(ctx, "id"))
+	})
+	done := make(chan string)
+	go func() {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.SetRequestURI("/slow/1")
+		r.ServeFastHTTP(&ctx)
+		done <- string(ctx.Response.Body())
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := r.RemoveRoute("GET", "/slow/{id}"); err != nil {
+		t.Fatal(err)
+	}
+	close(removed)
+	if body := <-done; body != "1" {
+		t.Fatalf("expected in-flight request to complete, got %q", body)
+	}
+	e.GET("/slow/1").Expect().Status(404)
+}
+
+func TestMuxRuntimeRoutesFirstRoute(t *testing.T) {
+	// the mounted router has no handler until its first route is added,
+	// while the parent router is serving requests
+	sub := NewRouter()
+	r := NewRouter()
+	r.Mount("/sub", sub)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			var ctx fasthttp.RequestCtx
+			ctx.Request.SetRequestURI("/sub/a")
+			func() {
+				defer func() { recover() }()
+				r.ServeFastHTTP(&ctx)
+			}()
+			if ctx.Response.StatusCode() == 200 && string(ctx.Response.Body()) == "a" {
+				return
+			}
+		}
+	}()
+
+	time.Sleep(time.Millisecond)
+	if err := sub.AddRoute("GET", "/a", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("a")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+}
+
+func TestMuxRuntimeRoutesConcurrency(t *testing.T) {
+	r := NewRouter()
+	r.Get("/static", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("static")
+	})
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var ctx fasthttp.RequestCtx
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				ctx.Response.Reset()
+				ctx.Request.SetRequestURI("/static")
+				r.ServeFastHTTP(&ctx)
+				if string(ctx.Response.Body()) != "static" {
+					t.Errorf("unexpected response %q", ctx.Response.Body())
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		pattern := fmt.Sprintf("/dyn/%d/{id}", i%10)
+		h := func(ctx *fasthttp.RequestCtx) {}
+		if err := r.AddRoute("GET", pattern, h); err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			if err := r.RemoveRoute("GET", pattern); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := r.RemoveRoute("*", pattern); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if routes := r.Routes(); len(routes) != 1 || routes[0].Pattern != "/static" {
+		t.Fatalf("expected only /static to remain, got %v", routes)
+	}
+}
+
 func TestMuxNotFound(t *testing.T) {
 	t.Run("simple case", func(t *testing.T) {
 		r := NewRouter()
@@ -416,3 +982,80 @@ func newFastHTTPTester(t *testing.T, h Handler) *httpexpect.Expect {
 		Reporter: httpexpect.NewAssertReporter(t),
 	})
 }
+
+func BenchmarkMuxGet(b *testing.B) {
+	h := func(ctx *fasthttp.RequestCtx) {
+		RouteContext(ctx).RoutePattern()
+	}
+	r := NewRouter()
+	r.Get("/", h)
+	r.Get("/ping", h)
+	r.Get("/ping/{id}", h)
+	r.Get("/ping/{id}/woop", h)
+	r.Get("/ping/{id}/{opt}", h)
+	r.Get("/hello", h)
+
+	for name, path := range map[string]string{"static": "/ping", "param": "/ping/123/456"} {
+		b.Run(name, func(b *testing.B) {
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.Header.SetMethod("GET")
+			ctx.Request.SetRequestURI(path)
+
+			// static and param routes are served without allocating
+			if allocs := testing.AllocsPerRun(100, func() {
+				ctx.ResetUserValues()
+				r.ServeFastHTTP(ctx)
+			}); allocs != 0 {
+				b.Fatalf("%s: %v allocs/op, want 0", path, allocs)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				ctx.ResetUserValues()
+				r.ServeFastHTTP(ctx)
+			}
+		})
+	}
+}
+
+func BenchmarkURLParam(b *testing.B) {
+	for _, n := range []int{1, 5, 20} {
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			var pattern, path string
+			for i := 0; i < n; i++ {
+				pattern += fmt.Sprintf("/{p%d}", i)
+				path += fmt.Sprintf("/%d", i)
+			}
+
+			last := fmt.Sprintf("p%d", n-1)
+			r := NewRouter()
+			r.Get(pattern, func(ctx *fasthttp.RequestCtx) {
+				URLParam(ctx, "p0")
+				URLParam(ctx, last)
+			})
+
+			ctx := &fasthttp.RequestCtx{}
+			ctx.Request.Header.SetMethod("GET")
+			ctx.Request.SetRequestURI(path)
+
+			// the params are views of the request path, stored inline or
+			// in the slices kept by the pooled context
+			if allocs := testing.AllocsPerRun(100, func() {
+				ctx.ResetUserValues()
+				r.ServeFastHTTP(ctx)
+			}); allocs != 0 {
+				b.Fatalf("%d params: %v allocs/op, want 0", n, allocs)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				ctx.ResetUserValues()
+				r.ServeFastHTTP(ctx)
+			}
+		})
+	}
+}
@@ -117,6 +117,7 @@
 package phi
 
 import (
+	"github.com/fate-lovely/phi/websocket"
 	"github.com/valyala/fasthttp"
 )
 
@@ -159,6 +160,18 @@ type Router interface {
 	// With adds inline middlewares for an endpoint handler.
 	With(middlewares ...Middleware) Router
 
+	// Describe adds an inline-Router whose routes carry the metadata
+	// set by `fn`, as reported by Routes().
+	Describe(fn func(m *RouteMeta)) Router
+
+	// Version adds an inline-Router whose routes are registered for
+	// the API `version`, see Mux.Versioning.
+	Version(version string) Router
+
+	// Priority adds an inline-Router whose routes are registered with
+	// the `priority`, ordering them among their param siblings.
+	Priority(priority int) Router
+
 	// Group adds a new inline-Router along the current routing
 	// path, with a fresh middleware stack for the inline-Router.
 	Group(fn func(r Router))
@@ -166,6 +179,10 @@ type Router interface {
 	// Route mounts a sub-Router along a `pattern`` string.
 	Route(pattern string, fn func(r Router))
 
+	// Prefix adds an inline-Router registering its routes along the
+	// `pattern` prefix, in the routing tree of the Router.
+	Prefix(pattern string, fn func(r Router))
+
 	// Mount attaches another phi.Handler along ./pattern/*
 	Mount(pattern string, h Handler)
 
@@ -188,6 +205,16 @@ type Router interface {
 	Put(pattern string, h HandlerFunc)
 	Trace(pattern string, h HandlerFunc)
 
+	// WebSocket adds a GET route upgrading requests to WebSocket
+	// connections served by `h`.
+	WebSocket(pattern string, h websocket.HandlerFunc)
+
+	// AddRoute, Replace and RemoveRoute modify the routes while the
+	// Router may be serving requests.
+	AddRoute(method, pattern string, h HandlerFunc) error
+	Replace(method, pattern string, h HandlerFunc) error
+	RemoveRoute(method, pattern string) error
+
 	// NotFound defines a handler to respond whenever a route could
 	// not be found.
 	NotFound(h HandlerFunc)
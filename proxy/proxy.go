@@ -0,0 +1,366 @@
+// Package proxy provides a reverse proxy phi.Handler forwarding requests to
+// a pool of upstream fasthttp.HostClient.
+//
+// Mounted on a phi router, the proxy forwards the path matched by the
+// mount wildcard, so that each internal service keeps its own root:
+//
+//  users := proxy.New(proxy.Config{
+//    Upstreams: []*fasthttp.HostClient{
+//      {Addr: "10.0.0.1:8080"},
+//      {Addr: "10.0.0.2:8080"},
+//    },
+//    Balancing: proxy.LeastConn,
+//  })
+//  r.Mount("/users", users) // GET /users/42 -> GET /42
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+// Balancing is a load balancing strategy.
+type Balancing int
+
+const (
+	// RoundRobin picks the upstreams in turn.
+	RoundRobin Balancing = iota
+
+	// LeastConn picks the upstream with the fewest in-flight requests.
+	LeastConn
+)
+
+// ErrNoUpstream is passed to the ErrorHandler when every upstream failed.
+var ErrNoUpstream = errors.New("proxy: no upstream available")
+
+// Config configures a Proxy.
+type Config struct {
+	// Upstreams receiving the requests. Their own settings, e.g. the
+	// read and write timeouts, TLS or StreamResponseBody to stream the
+	// responses instead of buffering them, apply. Their path normalizing
+	// is disabled, to forward the paths as sent by the clients. Required.
+	Upstreams []*fasthttp.HostClient
+
+	// Balancing strategy, RoundRobin by default.
+	Balancing Balancing
+
+	// Timeout bounds each upstream attempt, 30 seconds by default.
+	Timeout time.Duration
+
+	// Retries is the number of other upstreams tried when an idempotent
+	// request fails to reach its upstream. 1 by default, negative to
+	// disable.
+	Retries int
+
+	// MaxFails is the number of consecutive failures after which an
+	// upstream is considered down for FailTimeout. Failures are
+	// connection errors, timeouts and 502, 503 or 504 responses.
+	// 3 and 10 seconds by default.
+	MaxFails    int
+	FailTimeout time.Duration
+
+	// PreserveHost forwards the original Host header instead of the
+	// upstream address.
+	PreserveHost bool
+
+	// Rewrite is called with the upstream request before it's sent.
+	Rewrite func(ctx *fasthttp.RequestCtx, req *fasthttp.Request)
+
+	// ErrorHandler is called when the request couldn't be forwarded. It
+	// responds with a 504 on timeouts, and a 502 otherwise.
+	ErrorHandler func(ctx *fasthttp.RequestCtx, err error)
+
+	// now is overridden in tests
+	now func() time.Time
+}
+
+// Proxy is a reverse proxy handler, see New.
+type Proxy struct {
+	cfg       Config
+	upstreams []*upstream
+	next      uint32
+}
+
+type upstream struct {
+	hc        *fasthttp.HostClient
+	active    int64
+	fails     int32
+	downUntil int64 // unix nanoseconds
+}
+
+// New returns a Proxy configured by cfg.
+func New(cfg Config) *Proxy {
+	if len(cfg.Upstreams) == 0 {
+		panic("proxy: New requires at least one upstream")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.Retries == 0 {
+		cfg.Retries = 1
+	} else if cfg.Retries < 0 {
+		cfg.Retries = 0
+	}
+	if cfg.MaxFails <= 0 {
+		cfg.MaxFails = 3
+	}
+	if cfg.FailTimeout <= 0 {
+		cfg.FailTimeout = 10 * time.Second
+	}
+	if cfg.ErrorHandler == nil {
+		cfg.ErrorHandler = defaultErrorHandler
+	}
+	if cfg.now == nil {
+		cfg.now = time.Now
+	}
+
+	p := &Proxy{cfg: cfg}
+	for _, hc := range cfg.Upstreams {
+		hc.DisablePathNormalizing = true
+		p.upstreams = append(p.upstreams, &upstream{hc: hc})
+	}
+	return p
+}
+
+// ServeFastHTTP implements phi.Handler.
+func (p *Proxy) ServeFastHTTP(ctx *fasthttp.RequestCtx) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	p.prepareRequest(ctx, req)
+
+	// A streamed request body can only be sent once.
+	attempts := 1
+	if isIdempotent(ctx.Method()) && !ctx.Request.IsBodyStream() {
+		attempts += p.cfg.Retries
+	}
+
+	var err error
+	tried := make([]bool, len(p.upstreams))
+	for i := 0; i < attempts; i++ {
+		u := p.pick(tried)
+		if u == nil {
+			break
+		}
+		tried[p.index(u)] = true
+
+		if !p.cfg.PreserveHost {
+			req.URI().SetHost(u.hc.Addr)
+		}
+		ctx.Response.Reset()
+
+		atomic.AddInt64(&u.active, 1)
+		err = u.hc.DoTimeout(req, &ctx.Response, p.cfg.Timeout)
+		atomic.AddInt64(&u.active, -1)
+
+		if err != nil {
+			p.markFailure(u)
+			continue
+		}
+		switch ctx.Response.StatusCode() {
+		case fasthttp.StatusBadGateway, fasthttp.StatusServiceUnavailable, fasthttp.StatusGatewayTimeout:
+			p.markFailure(u)
+		default:
+			atomic.StoreInt32(&u.fails, 0)
+		}
+		removeHopHeaders(&ctx.Response.Header)
+		return
+	}
+
+	if err == nil {
+		err = ErrNoUpstream
+	}
+	ctx.Response.Reset()
+	p.cfg.ErrorHandler(ctx, err)
+}
+
+// prepareRequest builds the upstream request from the client one.
+func (p *Proxy) prepareRequest(ctx *fasthttp.RequestCtx, req *fasthttp.Request) {
+	if ctx.Request.IsBodyStream() {
+		ctx.Request.Header.CopyTo(&req.Header)
+		req.SetBodyStream(ctx.RequestBodyStream(), ctx.Request.Header.ContentLength())
+	} else {
+		ctx.Request.CopyTo(req)
+	}
+
+	// Forward the path left by the mount wildcard, as sent by the client:
+	// the mount prefix is taken off the raw path, so that the escaped
+	// characters reach the upstream unchanged.
+	path := ctx.URI().PathOriginal()
+	prefix := ""
+	if rctx, ok := ctx.UserValue(phi.RouteCtxKey).(*phi.Context); ok && rctx.RoutePath != "" {
+		prefix = strings.TrimSuffix(string(ctx.Path()), rctx.RoutePath)
+		path = trimRawPrefix(path, prefix, rctx.RoutePath)
+	}
+	uri := req.URI()
+	uri.SetPathBytes(path)
+	uri.SetQueryStringBytes(ctx.URI().QueryString())
+
+	removeHopHeaders(&req.Header)
+
+	clientIP, scheme, host := clientInfo(ctx)
+	if prior := req.Header.Peek(fasthttp.HeaderXForwardedFor); len(prior) > 0 {
+		req.Header.Set(fasthttp.HeaderXForwardedFor, string(prior)+", "+clientIP)
+	} else {
+		req.Header.Set(fasthttp.HeaderXForwardedFor, clientIP)
+	}
+	req.Header.Set(fasthttp.HeaderXForwardedProto, scheme)
+	req.Header.Set(fasthttp.HeaderXForwardedHost, host)
+	if prefix != "" {
+		req.Header.Set("X-Forwarded-Prefix", prefix)
+	}
+
+	if p.cfg.Rewrite != nil {
+		p.cfg.Rewrite(ctx, req)
+	}
+}
+
+// trimRawPrefix returns the raw request path without the `prefix` of the
+// decoded path. It falls back to the decoded `rest` of the path when the
+// raw path doesn't start with the prefix, e.g. once normalized.
+func trimRawPrefix(raw []byte, prefix, rest string) []byte {
+	i, n := 0, 0
+	for ; n < len(prefix) && i < len(raw); n++ {
+		if raw[i] == '%' && i+2 < len(raw) && isHex(raw[i+1]) && isHex(raw[i+2]) {
+			i += 3
+		} else {
+			i++
+		}
+	}
+	if decoded, err := url.PathUnescape(string(raw[:i])); err != nil || decoded != prefix {
+		return []byte(rest)
+	}
+	if raw = raw[i:]; len(raw) == 0 || raw[0] != '/' {
+		raw = append([]byte{'/'}, raw...)
+	}
+	return raw
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// clientInfo returns the client address, scheme and host, as resolved by
+// middleware.RealIP when in use.
+func clientInfo(ctx *fasthttp.RequestCtx) (ip, scheme, host string) {
+	ip, scheme, host = ctx.RemoteIP().String(), "http", string(ctx.Host())
+	if ctx.IsTLS() {
+		scheme = "https"
+	}
+	if rctx, ok := ctx.UserValue(phi.RouteCtxKey).(*phi.Context); ok {
+		if rctx.ClientIP != nil {
+			ip = rctx.ClientIP.String()
+		}
+		if rctx.Scheme != "" {
+			scheme = rctx.Scheme
+		}
+		if rctx.Host != "" {
+			host = rctx.Host
+		}
+	}
+	return ip, scheme, host
+}
+
+// pick returns the next healthy upstream not tried yet. When every
+// remaining upstream is down, the least recently failed one is tried
+// anyway, rather than failing right away.
+func (p *Proxy) pick(tried []bool) *upstream {
+	now := p.cfg.now().UnixNano()
+	start := int(atomic.AddUint32(&p.next, 1) - 1)
+
+	var best, fallback *upstream
+	for i := range p.upstreams {
+		idx := (start + i) % len(p.upstreams)
+		u := p.upstreams[idx]
+		if tried[idx] {
+			continue
+		}
+		if atomic.LoadInt64(&u.downUntil) > now {
+			if fallback == nil || atomic.LoadInt64(&u.downUntil) < atomic.LoadInt64(&fallback.downUntil) {
+				fallback = u
+			}
+			continue
+		}
+		if p.cfg.Balancing == RoundRobin {
+			return u
+		}
+		if best == nil || atomic.LoadInt64(&u.active) < atomic.LoadInt64(&best.active) {
+			best = u
+		}
+	}
+	if best != nil {
+		return best
+	}
+	return fallback
+}
+
+func (p *Proxy) index(u *upstream) int {
+	for i, v := range p.upstreams {
+		if v == u {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *Proxy) markFailure(u *upstream) {
+	if atomic.AddInt32(&u.fails, 1) >= int32(p.cfg.MaxFails) {
+		atomic.StoreInt32(&u.fails, 0)
+		atomic.StoreInt64(&u.downUntil, p.cfg.now().Add(p.cfg.FailTimeout).UnixNano())
+	}
+}
+
+func defaultErrorHandler(ctx *fasthttp.RequestCtx, err error) {
+	status := fasthttp.StatusBadGateway
+	if err == fasthttp.ErrTimeout {
+		status = fasthttp.StatusGatewayTimeout
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		status = fasthttp.StatusGatewayTimeout
+	}
+	ctx.Error(fasthttp.StatusMessage(status), status)
+}
+
+func isIdempotent(method []byte) bool {
+	switch string(method) {
+	case fasthttp.MethodGet, fasthttp.MethodHead, fasthttp.MethodOptions,
+		fasthttp.MethodTrace, fasthttp.MethodPut, fasthttp.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// hopHeaders are only meaningful for a single connection, they're not
+// forwarded (RFC 7230 section 6.1).
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Upgrade",
+}
+
+type header interface {
+	Peek(key string) []byte
+	Del(key string)
+}
+
+func removeHopHeaders(h header) {
+	for _, name := range bytes.Split(h.Peek(fasthttp.HeaderConnection), []byte{','}) {
+		if name = bytes.TrimSpace(name); len(name) > 0 {
+			h.Del(string(name))
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
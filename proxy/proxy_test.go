@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fate-lovely/phi"
+	"github.com/gavv/httpexpect"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+// newUpstream serves h on an in-memory listener, and returns a HostClient
+// dialing it.
+func newUpstream(t *testing.T, name string, h fasthttp.RequestHandler) (*fasthttp.HostClient, *fasthttputil.InmemoryListener) {
+	ln := fasthttputil.NewInmemoryListener()
+	t.Cleanup(func() { ln.Close() })
+	go fasthttp.Serve(ln, func(ctx *fasthttp.RequestCtx) {
+		ctx.Response.Header.Set("X-Upstream", name)
+		h(ctx)
+	})
+	hc := &fasthttp.HostClient{
+		Addr: name + ":80",
+		Dial: func(addr string) (net.Conn, error) { return ln.Dial() },
+	}
+	return hc, ln
+}
+
+func echoRequest(ctx *fasthttp.RequestCtx) {
+	h := &ctx.Request.Header
+	ctx.Response.Header.Set("Connection", "X-Hop")
+	ctx.Response.Header.Set("X-Hop", "1")
+	ctx.WriteString(string(ctx.Method()) + " " + string(ctx.RequestURI()) + "\n")
+	for _, k := range []string{"Host", "X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Forwarded-Prefix", "X-Private"} {
+		ctx.WriteString(k + ": " + string(h.Peek(k)) + "\n")
+	}
+	ctx.Write(ctx.PostBody())
+}
+
+func TestProxyMount(t *testing.T) {
+	a, _ := newUpstream(t, "a", echoRequest)
+	b, _ := newUpstream(t, "b", echoRequest)
+
+	r := phi.NewRouter()
+	r.Mount("/users", New(Config{Upstreams: []*fasthttp.HostClient{a, b}}))
+
+	e := newFastHTTPTester(t, r)
+
+	res := e.GET("/users/42").WithQuery("q", "1").
+		WithHeader("X-Forwarded-For", "1.2.3.4").
+		WithHeader("Connection", "X-Private").
+		WithHeader("X-Private", "secret").
+		Expect()
+	res.Status(200)
+	res.Header("X-Upstream").Equal("a")
+	res.Header("X-Hop").Empty()
+	res.Body().Equal("GET /42?q=1\n" +
+		"Host: a:80\n" +
+		"X-Forwarded-For: 1.2.3.4, 10.0.0.1\n" +
+		"X-Forwarded-Proto: http\n" +
+		"X-Forwarded-Host: example.com\n" +
+		"X-Forwarded-Prefix: /users\n" +
+		"X-Private: \n")
+
+	// round robin
+	e.POST("/users").WithText("body").Expect().Header("X-Upstream").Equal("b")
+	e.GET("/users/1").Expect().Header("X-Upstream").Equal("a")
+}
+
+func TestProxyRawPath(t *testing.T) {
+	a, _ := newUpstream(t, "a", func(ctx *fasthttp.RequestCtx) {
+		ctx.Write(ctx.RequestURI())
+	})
+
+	r := phi.NewRouter()
+	r.Mount("/users", New(Config{Upstreams: []*fasthttp.HostClient{a}}))
+	r.Mount("/a b", New(Config{Upstreams: []*fasthttp.HostClient{a}}))
+
+	for uri, want := range map[string]string{
+		"/users/a%2Fb":       "/a%2Fb",
+		"/users/a%2fb?q=%2F": "/a%2fb?q=%2F",
+		"/users/%7Ebob/x+y":  "/%7Ebob/x+y",
+		"/users":             "/",
+		"/a%20b/c%3Fd":       "/c%3Fd",
+	} {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.SetRequestURI(uri)
+		r.ServeFastHTTP(&ctx)
+		if got := string(ctx.Response.Body()); got != want {
+			t.Errorf("%s: upstream got %s, want %s", uri, got, want)
+		}
+	}
+}
+
+func TestProxyRetries(t *testing.T) {
+	down, ln := newUpstream(t, "down", echoRequest)
+	ln.Close()
+	up, _ := newUpstream(t, "up", echoRequest)
+
+	now := time.Now()
+	p := New(Config{Upstreams: []*fasthttp.HostClient{down, up}, MaxFails: 2, now: func() time.Time { return now }})
+	e := newFastHTTPTester(t, p)
+
+	// idempotent requests are retried on the next upstream
+	e.GET("/").Expect().Status(200).Header("X-Upstream").Equal("up")
+	e.GET("/").Expect().Status(200).Header("X-Upstream").Equal("up")
+
+	// the failing upstream is now down, even POST requests avoid it
+	e.POST("/").Expect().Status(200).Header("X-Upstream").Equal("up")
+	e.POST("/").Expect().Status(200).Header("X-Upstream").Equal("up")
+
+	// until its fail timeout expires
+	now = now.Add(11 * time.Second)
+	e.POST("/").Expect().Status(502)
+	e.POST("/").Expect().Status(200)
+}
+
+func TestProxyTimeout(t *testing.T) {
+	slow, _ := newUpstream(t, "slow", func(ctx *fasthttp.RequestCtx) {
+		time.Sleep(100 * time.Millisecond)
+	})
+	p := New(Config{Upstreams: []*fasthttp.HostClient{slow}, Timeout: 10 * time.Millisecond, Retries: -1})
+	newFastHTTPTester(t, p).GET("/").Expect().Status(504)
+}
+
+func TestProxyUpstreamErrors(t *testing.T) {
+	calls := 0
+	failing, _ := newUpstream(t, "failing", func(ctx *fasthttp.RequestCtx) {
+		calls++
+		ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+	})
+	p := New(Config{Upstreams: []*fasthttp.HostClient{failing}, MaxFails: 1})
+	e := newFastHTTPTester(t, p)
+
+	// upstream responses are passed through, not retried
+	e.GET("/").Expect().Status(503)
+	if calls != 1 {
+		t.Fatalf("upstream called %d times, want 1", calls)
+	}
+	// a down upstream is still tried when it's the only one
+	e.GET("/").Expect().Status(503)
+}
+
+func TestProxyStreaming(t *testing.T) {
+	stream, _ := newUpstream(t, "stream", func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+			for i := 0; i < 3; i++ {
+				w.WriteString("chunk\n")
+				w.Flush()
+			}
+		})
+	})
+	stream.StreamResponseBody = true
+
+	p := New(Config{Upstreams: []*fasthttp.HostClient{stream}})
+	newFastHTTPTester(t, p).GET("/").Expect().Status(200).Body().Equal("chunk\nchunk\nchunk\n")
+}
+
+func TestLeastConn(t *testing.T) {
+	p := New(Config{
+		Upstreams: []*fasthttp.HostClient{{Addr: "a"}, {Addr: "b"}, {Addr: "c"}},
+		Balancing: LeastConn,
+	})
+	p.upstreams[0].active = 3
+	p.upstreams[1].active = 1
+	p.upstreams[2].active = 2
+
+	tried := make([]bool, 3)
+	for _, want := range []string{"b", "c", "a"} {
+		u := p.pick(tried)
+		if u.hc.Addr != want {
+			t.Fatalf("picked %s, want %s", u.hc.Addr, want)
+		}
+		tried[p.index(u)] = true
+	}
+	if u := p.pick(tried); u != nil {
+		t.Fatalf("picked %s, want none", u.hc.Addr)
+	}
+}
+
+func newFastHTTPTester(t *testing.T, h phi.Handler) *httpexpect.Expect {
+	return httpexpect.WithConfig(httpexpect.Config{
+		// Pass requests directly to FastHTTPHandler.
+		Client: &http.Client{
+			Transport: httpexpect.NewFastBinder(fasthttp.RequestHandler(h.ServeFastHTTP)),
+			Jar:       httpexpect.NewJar(),
+		},
+		// Report errors using testify.
+		Reporter: httpexpect.NewAssertReporter(t),
+	})
+}
@@ -0,0 +1,165 @@
+package phi
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// ErrRouteExists is returned by AddRoute when the route is already
+	// registered.
+	ErrRouteExists = errors.New("phi: route already registered")
+
+	// ErrRouteNotFound is returned by RemoveRoute and Replace when the
+	// route isn't registered.
+	ErrRouteNotFound = errors.New("phi: route not registered")
+)
+
+// routingTree holds the root node of a Mux routing tree. It's shared by
+// the inline muxes of the Mux.
+//
+// Routes registered with Get, Post, etc. are inserted in place, as they're
+// expected to be set up before serving. AddRoute, RemoveRoute and Replace
+// copy the tree instead, modify the copy and swap it atomically, so that
+// in-flight requests keep using the tree they started with.
+type routingTree struct {
+	// methods supported by the routers, see Mux.RegisterMethod
+	methodSet uint64
+
+	// mu serializes the tree modifications
+	mu   sync.Mutex
+	root atomic.Value // *node
+
+	// strict mode, and the duplicate registrations reported by Validate
+	strict     bool
+	duplicates []Diagnostic
+
+	// frozen tree, and its table of static routes, see Mux.Freeze
+	frozen bool
+	static atomic.Value // *staticTable
+}
+
+func newRoutingTree() *routingTree {
+	t := &routingTree{methodSet: atomic.LoadUint64(&defaultMethods)}
+	t.root.Store(&node{})
+	return t
+}
+
+func (t *routingTree) load() *node {
+	return t.root.Load().(*node)
+}
+
+func (t *routingTree) staticTable() *staticTable {
+	st, _ := t.static.Load().(*staticTable)
+	return st
+}
+
+// AddRoute registers the route `pattern` for the `method` http method, or
+// for all of them with "*", while the router may be serving requests. It
+// fails with ErrRouteExists if the route is already registered.
+//
+// Like the other registration methods, it applies the inline middlewares
+// and metadata of the router, e.g. r.With(auth).AddRoute(...).
+func (mx *Mux) AddRoute(method, pattern string, handler HandlerFunc) error {
+	pattern, err := mx.prefixed(pattern)
+	if err != nil {
+		return err
+	}
+	return mx.updateTree(method, pattern, func(root *node, m methodTyp) error {
+		if root.hasRoute(m, pattern, mx.meta.Version) {
+			return ErrRouteExists
+		}
+		mx.insertRoute(root, m, pattern, handler)
+		return nil
+	})
+}
+
+// Replace swaps the handler of the route `pattern` for the `method` http
+// method, or for all the methods it's registered for with "*", while the
+// router may be serving requests. It fails with ErrRouteNotFound if the
+// route isn't registered.
+func (mx *Mux) Replace(method, pattern string, handler HandlerFunc) error {
+	pattern, err := mx.prefixed(pattern)
+	if err != nil {
+		return err
+	}
+	return mx.updateTree(method, pattern, func(root *node, m methodTyp) error {
+		methods, all := root.routeMethods(m, pattern, mx.meta.Version)
+		if methods == 0 {
+			return ErrRouteNotFound
+		}
+		dups := mx.tree.prunedDuplicates(root, methods, pattern)
+		if all {
+			mx.insertRoute(root, m, pattern, handler)
+		} else {
+			eachMethod(methods, func(m methodTyp) {
+				mx.insertRoute(root, m, pattern, handler)
+			})
+		}
+		mx.tree.duplicates = dups
+		return nil
+	})
+}
+
+// RemoveRoute removes the route `pattern` for the `method` http method, or
+// all of them with "*", while the router may be serving requests. It fails
+// with ErrRouteNotFound if the route isn't registered.
+func (mx *Mux) RemoveRoute(method, pattern string) error {
+	pattern, err := mx.prefixed(pattern)
+	if err != nil {
+		return err
+	}
+	return mx.updateTree(method, pattern, func(root *node, m methodTyp) error {
+		dups := mx.tree.prunedDuplicates(root, m, pattern)
+		if !root.removeRoute(m, pattern, mx.meta.Version) {
+			return ErrRouteNotFound
+		}
+		mx.tree.duplicates = dups
+		return nil
+	})
+}
+
+// updateTree applies fn to a copy of the routing tree, and swaps it in
+// unless fn fails. Invalid patterns, and in strict mode the problems
+// reported by Validate, are returned as errors.
+func (mx *Mux) updateTree(method, pattern string, fn func(root *node, m methodTyp) error) (err error) {
+	m := mx.tree.methods()
+	if method != "*" {
+		var ok bool
+		if m, ok = mx.method(method); !ok {
+			return fmt.Errorf("phi: '%s' http method is not supported", method)
+		}
+	}
+	if len(pattern) == 0 || pattern[0] != '/' {
+		return fmt.Errorf("phi: routing pattern must begin with '/' in '%s'", pattern)
+	}
+
+	mx.tree.mu.Lock()
+	defer mx.tree.mu.Unlock()
+	if mx.tree.frozen {
+		return ErrFrozen
+	}
+
+	// The mux handler is built on the first registration, like in handle.
+	if !mx.inline {
+		mx.buildRouteHandler()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	root := mx.tree.load().clone()
+	if err := fn(root, m); err != nil {
+		return err
+	}
+	if err := mx.strictCheck(root, isRouteDiagnostic(pattern), pattern); err != nil {
+		return err
+	}
+	mx.tree.root.Store(root)
+	return nil
+}
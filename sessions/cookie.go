@@ -0,0 +1,130 @@
+package sessions
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// maxCookieSize is the largest cookie value browsers reliably accept.
+const maxCookieSize = 4000
+
+// CookieStore keeps the whole session in the cookie itself, so it needs no
+// server side storage. The cookie is signed, and encrypted when a block key
+// is given.
+//
+// Deleting a cookie session can't revoke copies of the cookie held by the
+// client, they remain valid until they expire.
+type CookieStore struct {
+	hashKey []byte
+	aead    cipher.AEAD
+
+	// now is overridden in tests
+	now func() time.Time
+}
+
+// NewCookieStore returns a CookieStore signing cookies with `hashKey`, and
+// encrypting them with AES-GCM when `blockKey` is not nil. The block key
+// must be 16, 24 or 32 bytes long.
+func NewCookieStore(hashKey, blockKey []byte) (*CookieStore, error) {
+	if len(hashKey) == 0 {
+		return nil, errors.New("sessions: cookie store requires a hash key")
+	}
+	s := &CookieStore{hashKey: hashKey, now: time.Now}
+	if blockKey != nil {
+		block, err := aes.NewCipher(blockKey)
+		if err != nil {
+			return nil, err
+		}
+		if s.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Load implements Store.
+func (s *CookieStore) Load(token string) (string, []byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", nil, ErrNotFound
+	}
+
+	// Verify the signature of the (possibly encrypted) payload.
+	if len(b) < sha256.Size {
+		return "", nil, ErrNotFound
+	}
+	payload, sig := b[:len(b)-sha256.Size], b[len(b)-sha256.Size:]
+	if !hmac.Equal(sig, s.sign(payload)) {
+		return "", nil, ErrNotFound
+	}
+
+	if s.aead != nil {
+		ns := s.aead.NonceSize()
+		if len(payload) < ns {
+			return "", nil, ErrNotFound
+		}
+		payload, err = s.aead.Open(nil, payload[:ns], payload[ns:], nil)
+		if err != nil {
+			return "", nil, ErrNotFound
+		}
+	}
+
+	// The payload is the expiration time, the ID length and ID, and the
+	// session data.
+	if len(payload) < 9 {
+		return "", nil, ErrNotFound
+	}
+	expires := time.Unix(0, int64(binary.BigEndian.Uint64(payload[:8])))
+	if !s.now().Before(expires) {
+		return "", nil, ErrNotFound
+	}
+	n := int(payload[8])
+	if len(payload) < 9+n {
+		return "", nil, ErrNotFound
+	}
+	return string(payload[9 : 9+n]), payload[9+n:], nil
+}
+
+// Save implements Store.
+func (s *CookieStore) Save(id string, data []byte, maxAge time.Duration) (string, error) {
+	if len(id) > 255 {
+		return "", errors.New("sessions: session ID is too long")
+	}
+	payload := make([]byte, 9, 9+len(id)+len(data))
+	binary.BigEndian.PutUint64(payload[:8], uint64(s.now().Add(maxAge).UnixNano()))
+	payload[8] = byte(len(id))
+	payload = append(payload, id...)
+	payload = append(payload, data...)
+
+	if s.aead != nil {
+		nonce := make([]byte, s.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		payload = s.aead.Seal(nonce, nonce, payload, nil)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(append(payload, s.sign(payload)...))
+	if len(token) > maxCookieSize {
+		return "", ErrCookieTooLarge
+	}
+	return token, nil
+}
+
+// Delete implements Store. It's a no-op, the middleware expires the cookie.
+func (s *CookieStore) Delete(id string) error {
+	return nil
+}
+
+func (s *CookieStore) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.hashKey)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
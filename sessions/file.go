@@ -0,0 +1,112 @@
+package sessions
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileStore keeps each session in its own file of a directory, so sessions
+// survive restarts of a single server.
+type FileStore struct {
+	dir string
+
+	// now is overridden in tests
+	now func() time.Time
+}
+
+// NewFileStore returns a FileStore saving sessions in `dir`, which is
+// created if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir, now: time.Now}, nil
+}
+
+// Load implements Store.
+func (s *FileStore) Load(token string) (string, []byte, error) {
+	if !validID(token) {
+		return "", nil, ErrNotFound
+	}
+	b, err := ioutil.ReadFile(s.path(token))
+	if os.IsNotExist(err) {
+		return "", nil, ErrNotFound
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if len(b) < 8 {
+		return "", nil, ErrNotFound
+	}
+
+	// Files start with the expiration time, as unix nanoseconds.
+	expires := time.Unix(0, int64(binary.BigEndian.Uint64(b[:8])))
+	if !s.now().Before(expires) {
+		os.Remove(s.path(token))
+		return "", nil, ErrNotFound
+	}
+	return token, b[8:], nil
+}
+
+// Save implements Store. Files are written to a temporary file first and
+// renamed, so a concurrent Load never reads a partial session.
+func (s *FileStore) Save(id string, data []byte, maxAge time.Duration) (string, error) {
+	if !validID(id) {
+		return "", ErrNotFound
+	}
+
+	b := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint64(b[:8], uint64(s.now().Add(maxAge).UnixNano()))
+	copy(b[8:], data)
+
+	f, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	if _, err = f.Write(b); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path(id))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return id, nil
+}
+
+// Delete implements Store.
+func (s *FileStore) Delete(id string) error {
+	if !validID(id) {
+		return nil
+	}
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Cleanup removes the expired session files.
+func (s *FileStore) Cleanup() error {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range entries {
+		if validID(fi.Name()) {
+			// Load removes expired sessions.
+			s.Load(fi.Name()) // nolint: errcheck
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id)
+}
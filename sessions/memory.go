@@ -0,0 +1,113 @@
+package sessions
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const memoryShards = 32
+
+// MemoryStore keeps sessions in memory, sharded to reduce lock contention.
+// Sessions are lost on restart and aren't shared between processes.
+type MemoryStore struct {
+	shards [memoryShards]memoryShard
+	stop   chan struct{}
+
+	// now is overridden in tests
+	now func() time.Time
+}
+
+type memoryShard struct {
+	sync.RWMutex
+	items map[string]memoryItem
+}
+
+type memoryItem struct {
+	data    []byte
+	expires time.Time
+}
+
+// NewMemoryStore returns a MemoryStore which removes expired sessions
+// every `cleanupInterval`. Expired sessions are never loaded, so a zero
+// interval simply disables the background cleanup.
+func NewMemoryStore(cleanupInterval time.Duration) *MemoryStore {
+	s := &MemoryStore{stop: make(chan struct{}), now: time.Now}
+	for i := range s.shards {
+		s.shards[i].items = make(map[string]memoryItem)
+	}
+	if cleanupInterval > 0 {
+		go s.cleanupLoop(cleanupInterval)
+	}
+	return s
+}
+
+// Load implements Store.
+func (s *MemoryStore) Load(token string) (string, []byte, error) {
+	sh := s.shard(token)
+	sh.RLock()
+	item, ok := sh.items[token]
+	sh.RUnlock()
+
+	if !ok || !s.now().Before(item.expires) {
+		return "", nil, ErrNotFound
+	}
+	return token, item.data, nil
+}
+
+// Save implements Store.
+func (s *MemoryStore) Save(id string, data []byte, maxAge time.Duration) (string, error) {
+	sh := s.shard(id)
+	sh.Lock()
+	sh.items[id] = memoryItem{data: data, expires: s.now().Add(maxAge)}
+	sh.Unlock()
+	return id, nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(id string) error {
+	sh := s.shard(id)
+	sh.Lock()
+	delete(sh.items, id)
+	sh.Unlock()
+	return nil
+}
+
+// Cleanup removes the expired sessions.
+func (s *MemoryStore) Cleanup() {
+	now := s.now()
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.Lock()
+		for id, item := range sh.items {
+			if !now.Before(item.expires) {
+				delete(sh.items, id)
+			}
+		}
+		sh.Unlock()
+	}
+}
+
+// Close stops the background cleanup.
+func (s *MemoryStore) Close() {
+	close(s.stop)
+}
+
+func (s *MemoryStore) cleanupLoop(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.Cleanup()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *MemoryStore) shard(id string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return &s.shards[h.Sum32()%memoryShards]
+}
@@ -0,0 +1,291 @@
+// Package sessions provides per-user sessions for phi routers.
+//
+// The Middleware loads the session lazily, on the first call to Get, and
+// saves it once the handler returns, only if it was modified. Sessions are
+// kept in a Store: in the cookie itself (CookieStore), in memory
+// (MemoryStore) or in files (FileStore).
+//
+//  store := sessions.NewMemoryStore(time.Minute)
+//  r.Use(sessions.Middleware(sessions.Config{Store: store}))
+//
+//  r.Post("/login", func(ctx *fasthttp.RequestCtx) {
+//    s := sessions.Get(ctx)
+//    s.RenewID() // prevent session fixation
+//    s.Set("user", "alice")
+//    s.AddFlash("Welcome back!")
+//    ctx.Redirect("/", 303)
+//  })
+package sessions
+
+import (
+	"time"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	// SessionCtxKey is the context key holding the session state of the
+	// request.
+	SessionCtxKey = (&contextKey{"Session"}).String()
+)
+
+// flashesKey is the session key flash messages are stored under.
+const flashesKey = "_flashes"
+
+// Config configures the session Middleware.
+type Config struct {
+	// Store persists sessions. Required.
+	Store Store
+
+	// Cookie attributes of the session cookie. The name defaults to
+	// "session" and the path to "/". SameSite defaults to Lax when left
+	// to fasthttp.CookieSameSiteDisabled. The cookie is always HttpOnly.
+	CookieName   string
+	CookieDomain string
+	CookiePath   string
+	Secure       bool
+	SameSite     fasthttp.CookieSameSite
+
+	// MaxAge is the lifetime of sessions, 24 hours by default. It's
+	// renewed each time the session is saved.
+	MaxAge time.Duration
+
+	// ErrorHandler is called when the session can't be saved. The default
+	// handler responds with a 500 Internal Server Error.
+	ErrorHandler func(ctx *fasthttp.RequestCtx, err error)
+}
+
+// Middleware returns a middleware managing the session of each request,
+// see Get.
+func Middleware(cfg Config) phi.Middleware {
+	if cfg.Store == nil {
+		panic("sessions: Middleware requires a Store")
+	}
+	if cfg.CookieName == "" {
+		cfg.CookieName = "session"
+	}
+	if cfg.CookiePath == "" {
+		cfg.CookiePath = "/"
+	}
+	if cfg.SameSite == fasthttp.CookieSameSiteDisabled {
+		cfg.SameSite = fasthttp.CookieSameSiteLaxMode
+	}
+	if cfg.MaxAge == 0 {
+		cfg.MaxAge = 24 * time.Hour
+	}
+	if cfg.ErrorHandler == nil {
+		cfg.ErrorHandler = func(ctx *fasthttp.RequestCtx, err error) {
+			ctx.Error(fasthttp.StatusMessage(fasthttp.StatusInternalServerError), fasthttp.StatusInternalServerError)
+		}
+	}
+
+	return func(next phi.HandlerFunc) phi.HandlerFunc {
+		return func(ctx *fasthttp.RequestCtx) {
+			st := &state{cfg: &cfg, ctx: ctx}
+			ctx.SetUserValue(SessionCtxKey, st)
+			next(ctx)
+			if err := st.commit(); err != nil {
+				cfg.ErrorHandler(ctx, err)
+			}
+		}
+	}
+}
+
+// Get returns the session of the request, loading it on first use. It
+// panics if the session Middleware isn't installed.
+func Get(ctx *fasthttp.RequestCtx) *Session {
+	st, ok := ctx.UserValue(SessionCtxKey).(*state)
+	if !ok {
+		panic("sessions: Get called without the sessions Middleware")
+	}
+	return st.load()
+}
+
+// Session holds the values of a user session. It's not safe for concurrent
+// use, it's meant to be used by the request handler.
+type Session struct {
+	id        string
+	values    map[string]interface{}
+	isNew     bool
+	modified  bool
+	destroyed bool
+
+	// previous ID to remove from the store, after RenewID
+	oldID string
+}
+
+// ID returns the session ID.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// IsNew reports whether the session was created by this request.
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+// Get returns the value stored under `key`.
+func (s *Session) Get(key string) interface{} {
+	return s.values[key]
+}
+
+// GetString returns the value stored under `key` if it's a string.
+func (s *Session) GetString(key string) string {
+	v, _ := s.values[key].(string)
+	return v
+}
+
+// Set stores `value` under `key`.
+func (s *Session) Set(key string, value interface{}) {
+	s.values[key] = value
+	s.modified = true
+}
+
+// Delete removes the value stored under `key`.
+func (s *Session) Delete(key string) {
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.modified = true
+	}
+}
+
+// Clear removes every value of the session.
+func (s *Session) Clear() {
+	if len(s.values) > 0 {
+		s.values = map[string]interface{}{}
+		s.modified = true
+	}
+}
+
+// AddFlash adds a flash message, kept until it's read with Flashes.
+func (s *Session) AddFlash(msg string) {
+	flashes, _ := s.values[flashesKey].([]string)
+	s.Set(flashesKey, append(flashes, msg))
+}
+
+// Flashes returns and removes the pending flash messages.
+func (s *Session) Flashes() []string {
+	flashes, _ := s.values[flashesKey].([]string)
+	s.Delete(flashesKey)
+	return flashes
+}
+
+// RenewID gives the session a new ID, keeping its values, and removes the
+// old one from the store. Call it whenever the privilege level changes,
+// like on login or logout, to prevent session fixation.
+func (s *Session) RenewID() {
+	if !s.isNew && s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = newID()
+	s.modified = true
+}
+
+// Destroy removes the session from the store and expires its cookie.
+func (s *Session) Destroy() {
+	s.values = map[string]interface{}{}
+	s.destroyed = true
+}
+
+// state tracks the session of a request.
+type state struct {
+	cfg     *Config
+	ctx     *fasthttp.RequestCtx
+	session *Session
+}
+
+func (st *state) load() *Session {
+	if st.session != nil {
+		return st.session
+	}
+
+	if token := st.ctx.Request.Header.Cookie(st.cfg.CookieName); len(token) > 0 {
+		id, data, err := st.cfg.Store.Load(string(token))
+		if err == nil {
+			if values, err := decodeValues(data); err == nil {
+				st.session = &Session{id: id, values: values}
+				return st.session
+			}
+		}
+	}
+
+	// No usable session, start a new one.
+	st.session = &Session{id: newID(), values: map[string]interface{}{}, isNew: true}
+	return st.session
+}
+
+// commit saves the session if it was loaded and modified.
+func (st *state) commit() error {
+	s := st.session
+	if s == nil {
+		return nil
+	}
+
+	if s.destroyed {
+		if s.isNew && s.oldID == "" {
+			return nil
+		}
+		if err := st.cfg.Store.Delete(s.id); err != nil {
+			return err
+		}
+		if s.oldID != "" {
+			if err := st.cfg.Store.Delete(s.oldID); err != nil {
+				return err
+			}
+		}
+		st.setCookie("", -1)
+		return nil
+	}
+
+	if !s.modified {
+		return nil
+	}
+
+	if s.oldID != "" {
+		if err := st.cfg.Store.Delete(s.oldID); err != nil {
+			return err
+		}
+		s.oldID = ""
+	}
+	data, err := encodeValues(s.values)
+	if err != nil {
+		return err
+	}
+	token, err := st.cfg.Store.Save(s.id, data, st.cfg.MaxAge)
+	if err != nil {
+		return err
+	}
+	st.setCookie(token, st.cfg.MaxAge)
+	return nil
+}
+
+func (st *state) setCookie(value string, maxAge time.Duration) {
+	c := fasthttp.AcquireCookie()
+	defer fasthttp.ReleaseCookie(c)
+
+	c.SetKey(st.cfg.CookieName)
+	c.SetValue(value)
+	c.SetPath(st.cfg.CookiePath)
+	c.SetDomain(st.cfg.CookieDomain)
+	if maxAge < 0 {
+		c.SetExpire(fasthttp.CookieExpireDelete)
+	} else {
+		c.SetMaxAge(int(maxAge / time.Second))
+	}
+	c.SetSecure(st.cfg.Secure)
+	c.SetHTTPOnly(true)
+	c.SetSameSite(st.cfg.SameSite)
+	st.ctx.Response.Header.SetCookie(c)
+}
+
+// contextKey names the user values set by the sessions package, i.e.
+// SessionCtxKey. It formats to a prefixed string, so the session can't be
+// clobbered by a user value of the application.
+type contextKey struct {
+	name string
+}
+
+func (k *contextKey) String() string {
+	return "sessions context value " + k.name
+}
@@ -0,0 +1,169 @@
+package sessions
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fate-lovely/phi"
+	"github.com/gavv/httpexpect"
+	"github.com/valyala/fasthttp"
+)
+
+func TestSessions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileStore, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedStore, _ := NewCookieStore([]byte("hash-key"), nil)
+	encryptedStore, _ := NewCookieStore([]byte("hash-key"), []byte("0123456789abcdef"))
+	memoryStore := NewMemoryStore(0)
+
+	stores := map[string]Store{
+		"memory":           memoryStore,
+		"file":             fileStore,
+		"signed cookie":    signedStore,
+		"encrypted cookie": encryptedStore,
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			testSessionStore(t, store)
+		})
+	}
+}
+
+func testSessionStore(t *testing.T, store Store) {
+	r := phi.NewRouter()
+	r.Use(Middleware(Config{Store: store}))
+	r.Get("/", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("untouched")
+	})
+	r.Get("/user", func(ctx *fasthttp.RequestCtx) {
+		s := Get(ctx)
+		ctx.WriteString(s.GetString("user") + " " + strings.Join(s.Flashes(), ","))
+	})
+	r.Post("/login", func(ctx *fasthttp.RequestCtx) {
+		s := Get(ctx)
+		s.RenewID()
+		s.Set("user", "alice")
+		s.AddFlash("hi")
+		s.AddFlash("there")
+	})
+	r.Post("/logout", func(ctx *fasthttp.RequestCtx) {
+		Get(ctx).Destroy()
+	})
+
+	e := newFastHTTPTester(t, r)
+
+	// sessions are loaded lazily, and only saved when modified
+	e.GET("/").Expect().Status(200).Header("Set-Cookie").Empty()
+	e.GET("/user").Expect().Status(200).Header("Set-Cookie").Empty()
+
+	login := e.POST("/login").Expect().Status(200)
+	first := login.Cookie("session").Value().NotEmpty().Raw()
+	login.Header("Set-Cookie").Contains("HttpOnly").Contains("SameSite=Lax")
+
+	e.GET("/user").Expect().Status(200).Text().Equal("alice hi,there")
+	// reading flashes modifies the session, they're gone afterwards
+	e.GET("/user").Expect().Status(200).Text().Equal("alice ")
+
+	// logging in again rotates the ID, the old session is gone
+	second := e.POST("/login").Expect().Status(200).Cookie("session").Value().Raw()
+	if second == first {
+		t.Fatalf("expected session token to change")
+	}
+	oldID, _, err := store.Load(first)
+	if _, ok := store.(*CookieStore); !ok && err != ErrNotFound {
+		t.Errorf("expected rotated session to be removed, got %q (%v)", oldID, err)
+	}
+
+	e.POST("/logout").Expect().Status(200).Header("Set-Cookie").Contains("session=;")
+	if _, ok := store.(*CookieStore); !ok {
+		if _, _, err := store.Load(second); err != ErrNotFound {
+			t.Errorf("expected destroyed session to be removed, got %v", err)
+		}
+	}
+
+	// tampered tokens start a fresh session
+	e.GET("/user").WithCookie("session", second+"x").Expect().Status(200).Text().Equal(" ")
+}
+
+func TestStoreExpiration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	memoryStore := NewMemoryStore(0)
+	memoryStore.now = clock
+	fileStore, _ := NewFileStore(dir)
+	fileStore.now = clock
+	cookieStore, _ := NewCookieStore([]byte("hash-key"), []byte("0123456789abcdef"))
+	cookieStore.now = clock
+
+	for name, store := range map[string]Store{"memory": memoryStore, "file": fileStore, "cookie": cookieStore} {
+		now = time.Now()
+		id := newID()
+		token, err := store.Save(id, []byte("data"), time.Minute)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		gotID, data, err := store.Load(token)
+		if err != nil || gotID != id || string(data) != "data" {
+			t.Errorf("%s: unexpected load %q %q %v", name, gotID, data, err)
+		}
+
+		now = now.Add(2 * time.Minute)
+		if _, _, err := store.Load(token); err != ErrNotFound {
+			t.Errorf("%s: expected expired session, got %v", name, err)
+		}
+	}
+
+	memoryStore.Cleanup()
+	for i := range memoryStore.shards {
+		if len(memoryStore.shards[i].items) != 0 {
+			t.Errorf("expected memory store to be empty after cleanup")
+		}
+	}
+}
+
+func TestFileStoreRejectsBadIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, _ := NewFileStore(dir)
+	if _, _, err := store.Load("../../etc/passwd"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := store.Save("../escape", nil, time.Minute); err == nil {
+		t.Errorf("expected an error saving an invalid ID")
+	}
+}
+
+func newFastHTTPTester(t *testing.T, h phi.Handler) *httpexpect.Expect {
+	return httpexpect.WithConfig(httpexpect.Config{
+		// Pass requests directly to FastHTTPHandler.
+		Client: &http.Client{
+			Transport: httpexpect.NewFastBinder(fasthttp.RequestHandler(h.ServeFastHTTP)),
+			Jar:       httpexpect.NewJar(),
+		},
+		// Report errors using testify.
+		Reporter: httpexpect.NewAssertReporter(t),
+	})
+}
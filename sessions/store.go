@@ -0,0 +1,84 @@
+package sessions
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"time"
+)
+
+// Store errors.
+var (
+	// ErrNotFound is returned by a Store when the session doesn't exist,
+	// has expired or its token can't be verified.
+	ErrNotFound = errors.New("sessions: session not found")
+
+	// ErrCookieTooLarge is returned by CookieStore when the encoded session
+	// doesn't fit in a cookie.
+	ErrCookieTooLarge = errors.New("sessions: session is too large for a cookie")
+)
+
+// Store persists encoded sessions.
+//
+// The token is the value of the session cookie. Server side stores use the
+// session ID as token, while CookieStore seals the session data itself in
+// the token.
+type Store interface {
+	// Load returns the ID and data of the session referenced by `token`,
+	// or ErrNotFound.
+	Load(token string) (id string, data []byte, err error)
+
+	// Save stores the session data for `maxAge` and returns the token to
+	// send in the cookie.
+	Save(id string, data []byte, maxAge time.Duration) (token string, err error)
+
+	// Delete removes session `id`.
+	Delete(id string) error
+}
+
+// newID returns a random session ID.
+func newID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("sessions: failed to generate session ID: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// validID reports whether `id` looks like an ID made by newID, which makes
+// it safe to use as a file name.
+func validID(id string) bool {
+	if len(id) != 43 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeValues serializes session values with gob. Custom types stored
+// in sessions must be registered with gob.Register.
+func encodeValues(values map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(values); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeValues(data []byte) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if len(data) == 0 {
+		return values, nil
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
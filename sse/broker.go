@@ -0,0 +1,143 @@
+package sse
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+)
+
+var errClosed = errors.New("sse: stream closed")
+
+// BrokerConfig configures a Broker.
+type BrokerConfig struct {
+	// Replay is the number of events kept to replay to reconnecting
+	// clients, 100 by default, negative to disable.
+	Replay int
+
+	// ClientBuffer is the number of events queued for a client. A client
+	// falling further behind is disconnected, and can resume from its
+	// Last-Event-ID when it reconnects. 16 by default.
+	ClientBuffer int
+}
+
+// Broker fans out published events to every connected client.
+type Broker struct {
+	cfg BrokerConfig
+
+	mu      sync.Mutex
+	replay  *Buffer
+	lastID  uint64
+	clients map[chan Event]struct{}
+	closed  bool
+}
+
+// NewBroker returns a Broker configured by cfg.
+func NewBroker(cfg BrokerConfig) *Broker {
+	if cfg.Replay == 0 {
+		cfg.Replay = 100
+	} else if cfg.Replay < 0 {
+		cfg.Replay = 0
+	}
+	if cfg.ClientBuffer <= 0 {
+		cfg.ClientBuffer = 16
+	}
+	return &Broker{
+		cfg:     cfg,
+		replay:  NewBuffer(cfg.Replay),
+		clients: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish sends the event to every client. Events without an ID get a
+// sequential one, so that clients can resume after them.
+func (b *Broker) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	if e.ID == "" {
+		b.lastID++
+		e.ID = strconv.FormatUint(b.lastID, 10)
+	}
+	b.replay.Add(e)
+
+	for c := range b.clients {
+		select {
+		case c <- e:
+		default:
+			// The client is too slow, drop it rather than blocking the
+			// other ones.
+			b.unsubscribe(c)
+		}
+	}
+}
+
+// Serve is a HandlerFunc streaming the published events to the client,
+// starting with the ones it missed since its Last-Event-ID.
+func (b *Broker) Serve(s *Stream) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	var missed []Event
+	if s.LastEventID != "" {
+		missed, _ = b.replay.Since(s.LastEventID)
+	}
+	c := make(chan Event, b.cfg.ClientBuffer)
+	b.clients[c] = struct{}{}
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.unsubscribe(c)
+		b.mu.Unlock()
+	}()
+
+	for _, e := range missed {
+		if s.Send(e) != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case e, ok := <-c:
+			if !ok {
+				return
+			}
+			if s.Send(e) != nil {
+				return
+			}
+		case <-s.Done():
+			return
+		}
+	}
+}
+
+// Clients returns the number of connected clients.
+func (b *Broker) Clients() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.clients)
+}
+
+// Close disconnects every client, and drops further published events.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for c := range b.clients {
+		b.unsubscribe(c)
+	}
+}
+
+// unsubscribe must be called with b.mu held.
+func (b *Broker) unsubscribe(c chan Event) {
+	if _, ok := b.clients[c]; ok {
+		delete(b.clients, c)
+		close(c)
+	}
+}
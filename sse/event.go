@@ -0,0 +1,97 @@
+package sse
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is a server-sent event. Only its non empty fields are written.
+type Event struct {
+	// ID sets the client's last event ID, sent back in the Last-Event-ID
+	// header when it reconnects.
+	ID string
+
+	// Event is the event type, "message" when empty.
+	Event string
+
+	// Data is the event payload, multi-line data is split across several
+	// data fields.
+	Data string
+
+	// Retry sets the client's reconnection delay.
+	Retry time.Duration
+}
+
+// writeTo writes the event frame to w.
+func (e *Event) writeTo(w *bufio.Writer) {
+	if e.ID != "" {
+		writeField(w, "id", e.ID)
+	}
+	if e.Event != "" {
+		writeField(w, "event", e.Event)
+	}
+	if e.Retry > 0 {
+		writeField(w, "retry", strconv.FormatInt(int64(e.Retry/time.Millisecond), 10))
+	}
+	if e.Data != "" || (e.ID == "" && e.Event == "" && e.Retry == 0) {
+		data := strings.Replace(e.Data, "\r\n", "\n", -1)
+		for _, line := range strings.Split(data, "\n") {
+			writeField(w, "data", line)
+		}
+	}
+	w.WriteByte('\n')
+}
+
+func writeField(w *bufio.Writer, name, value string) {
+	// Line breaks would end the field early, IDs and types can't hold any.
+	value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+	w.WriteString(name)
+	w.WriteString(": ")
+	w.WriteString(value)
+	w.WriteByte('\n')
+}
+
+// Buffer is a ring buffer of the last published events, used to replay the
+// events a client missed when it reconnects with a Last-Event-ID. It isn't
+// safe for concurrent use, Broker guards its own.
+type Buffer struct {
+	events []Event
+	start  int
+	n      int
+}
+
+// NewBuffer returns a Buffer holding up to `size` events.
+func NewBuffer(size int) *Buffer {
+	return &Buffer{events: make([]Event, size)}
+}
+
+// Add appends an event, dropping the oldest one when the buffer is full.
+func (b *Buffer) Add(e Event) {
+	if len(b.events) == 0 {
+		return
+	}
+	if b.n < len(b.events) {
+		b.events[(b.start+b.n)%len(b.events)] = e
+		b.n++
+		return
+	}
+	b.events[b.start] = e
+	b.start = (b.start + 1) % len(b.events)
+}
+
+// Since returns the events published after the event with the given ID.
+// It reports false when that event isn't in the buffer anymore.
+func (b *Buffer) Since(id string) ([]Event, bool) {
+	for i := b.n - 1; i >= 0; i-- {
+		if b.events[(b.start+i)%len(b.events)].ID == id {
+			events := make([]Event, 0, b.n-i-1)
+			for j := i + 1; j < b.n; j++ {
+				events = append(events, b.events[(b.start+j)%len(b.events)])
+			}
+			return events, true
+		}
+	}
+	return nil, false
+}
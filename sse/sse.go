@@ -0,0 +1,214 @@
+// Package sse streams Server-Sent Events from phi routes.
+//
+// A HandlerFunc receives a Stream once the response headers are sent, and
+// writes events to it until it returns or the client goes away:
+//
+//  sse.Handle(r, "/clock", func(s *sse.Stream) {
+//    for {
+//      select {
+//      case t := <-time.After(time.Second):
+//        s.Send(sse.Event{Data: t.String()})
+//      case <-s.Done():
+//        return
+//      }
+//    }
+//  })
+//
+// To fan out events to many clients use a Broker, which also replays the
+// events missed by reconnecting clients:
+//
+//  broker := sse.NewBroker(sse.BrokerConfig{})
+//  sse.Handle(r, "/events", broker.Serve)
+//  broker.Publish(sse.Event{Event: "update", Data: "..."})
+package sse
+
+import (
+	"bufio"
+	"sync"
+	"time"
+
+	"github.com/fate-lovely/phi"
+	"github.com/valyala/fasthttp"
+)
+
+// HandlerFunc writes events to a client stream.
+type HandlerFunc func(s *Stream)
+
+// Config configures an event stream handler.
+type Config struct {
+	// Heartbeat is the interval of the comments sent to keep the connection
+	// alive through proxies and detect gone clients. 15 seconds by default,
+	// negative to disable.
+	Heartbeat time.Duration
+
+	// Retry is the reconnection delay sent to clients when the stream
+	// starts. The browser default is kept when zero.
+	Retry time.Duration
+}
+
+// Handle registers a GET route on r streaming events with fn.
+func Handle(r phi.Router, pattern string, fn HandlerFunc) {
+	r.Get(pattern, Handler(fn))
+}
+
+// Handler returns a phi.HandlerFunc streaming events with fn.
+func Handler(fn HandlerFunc) phi.HandlerFunc {
+	return HandlerWithConfig(Config{}, fn)
+}
+
+// HandlerWithConfig returns a phi.HandlerFunc streaming events with fn,
+// configured by cfg.
+//
+// fn runs from fasthttp's body stream writer, after the request handler
+// returned, so the request context can't be used there anymore. The
+// Stream holds a copy of the request and of its URL parameters instead.
+func HandlerWithConfig(cfg Config, fn HandlerFunc) phi.HandlerFunc {
+	if cfg.Heartbeat == 0 {
+		cfg.Heartbeat = 15 * time.Second
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		s := newStream(ctx)
+
+		ctx.SetContentType("text/event-stream; charset=utf-8")
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "no-cache")
+		// Disable response buffering in nginx.
+		ctx.Response.Header.Set("X-Accel-Buffering", "no")
+
+		ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+			s.w = w
+			if cfg.Retry > 0 {
+				s.Send(Event{Retry: cfg.Retry})
+			} else {
+				// Send the headers right away.
+				s.flush()
+			}
+
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			if cfg.Heartbeat > 0 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s.heartbeat(cfg.Heartbeat, stop)
+				}()
+			}
+
+			fn(s)
+
+			close(stop)
+			wg.Wait()
+		})
+	}
+}
+
+// Stream is an event stream to a single client. Its methods are safe for
+// concurrent use.
+type Stream struct {
+	// Request is a copy of the request which opened the stream.
+	Request fasthttp.Request
+
+	// LastEventID is the ID of the last event received by a reconnecting
+	// client, empty on the first connection.
+	LastEventID string
+
+	params phi.RouteParams
+
+	mu       sync.Mutex
+	w        *bufio.Writer
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func newStream(ctx *fasthttp.RequestCtx) *Stream {
+	s := &Stream{
+		LastEventID: string(ctx.Request.Header.Peek("Last-Event-ID")),
+		done:        make(chan struct{}),
+	}
+	ctx.Request.CopyTo(&s.Request)
+	if rctx, ok := ctx.UserValue(phi.RouteCtxKey).(*phi.Context); ok {
+		s.params.Keys = append(s.params.Keys, rctx.URLParams.Keys...)
+		// the values are views of the request, which the stream outlives
+		for _, v := range rctx.URLParams.Values {
+			s.params.Values = append(s.params.Values, string([]byte(v)))
+		}
+	}
+	return s
+}
+
+// URLParam returns the URL parameter `key` of the route which opened the
+// stream.
+func (s *Stream) URLParam(key string) string {
+	for k := len(s.params.Keys) - 1; k >= 0; k-- {
+		if s.params.Keys[k] == key {
+			return s.params.Values[k]
+		}
+	}
+	return ""
+}
+
+// Send writes the event and flushes it to the client.
+func (s *Stream) Send(e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.writeTo(s.w)
+	return s.flushLocked()
+}
+
+// Comment writes a comment line, which clients ignore.
+func (s *Stream) Comment(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if text == "" {
+		s.w.WriteString(":\n\n")
+	} else {
+		writeField(s.w, "", text)
+		s.w.WriteByte('\n')
+	}
+	return s.flushLocked()
+}
+
+// Done returns a channel closed once the client is gone.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Stream) close() {
+	s.doneOnce.Do(func() { close(s.done) })
+}
+
+func (s *Stream) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushLocked()
+}
+
+func (s *Stream) flushLocked() error {
+	select {
+	case <-s.done:
+		return errClosed
+	default:
+	}
+	if err := s.w.Flush(); err != nil {
+		s.close()
+		return err
+	}
+	return nil
+}
+
+func (s *Stream) heartbeat(interval time.Duration, stop <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			s.Comment("")
+		case <-stop:
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
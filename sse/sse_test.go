@@ -0,0 +1,187 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fate-lovely/phi"
+	"github.com/gavv/httpexpect"
+	"github.com/valyala/fasthttp"
+)
+
+func TestHandle(t *testing.T) {
+	r := phi.NewRouter()
+	Handle(r, "/rooms/{id}/events", func(s *Stream) {
+		s.Send(Event{ID: "1", Event: "join", Data: "room " + s.URLParam("id")})
+		s.Send(Event{Data: "line 1\nline 2"})
+		s.Comment("ping")
+		s.Send(Event{ID: "2", Data: "after " + s.LastEventID})
+	})
+	r.Get("/retry", HandlerWithConfig(Config{Retry: 3 * time.Second, Heartbeat: -1}, func(s *Stream) {
+		s.Send(Event{Data: "x"})
+	}))
+
+	e := newFastHTTPTester(t, r)
+
+	res := e.GET("/rooms/42/events").WithHeader("Last-Event-ID", "7").Expect()
+	res.Status(200)
+	res.Header("Content-Type").Equal("text/event-stream; charset=utf-8")
+	res.Header("Cache-Control").Equal("no-cache")
+	res.Body().Equal("id: 1\nevent: join\ndata: room 42\n\n" +
+		"data: line 1\ndata: line 2\n\n" +
+		": ping\n\n" +
+		"id: 2\ndata: after 7\n\n")
+
+	e.GET("/retry").Expect().Body().Equal("retry: 3000\n\ndata: x\n\n")
+}
+
+func TestHeartbeat(t *testing.T) {
+	h := HandlerWithConfig(Config{Heartbeat: 10 * time.Millisecond}, func(s *Stream) {
+		time.Sleep(35 * time.Millisecond)
+	})
+	var ctx fasthttp.RequestCtx
+	h(&ctx)
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	ctx.Response.BodyWriteTo(w)
+	w.Flush()
+	if !bytes.Contains(buf.Bytes(), []byte(":\n\n")) {
+		t.Fatalf("no heartbeat in %q", buf.String())
+	}
+}
+
+func TestBroker(t *testing.T) {
+	b := NewBroker(BrokerConfig{Replay: 2})
+	b.Publish(Event{Data: "a"})
+	b.Publish(Event{Data: "b"})
+	b.Publish(Event{Data: "c"})
+
+	// resume after "b", "a" isn't in the replay buffer anymore anyway
+	s1, out1 := testStream("2")
+	s2, out2 := testStream("")
+	done := serve(b, s1, s2)
+	waitClients(t, b, 2)
+
+	b.Publish(Event{Event: "custom", ID: "x", Data: "d"})
+	b.Close()
+	<-done
+
+	if got, want := out1.String(), "id: 3\ndata: c\n\nid: x\nevent: custom\ndata: d\n\n"; got != want {
+		t.Fatalf("resumed stream = %q, want %q", got, want)
+	}
+	if got, want := out2.String(), "id: x\nevent: custom\ndata: d\n\n"; got != want {
+		t.Fatalf("new stream = %q, want %q", got, want)
+	}
+}
+
+func TestBrokerSlowClient(t *testing.T) {
+	b := NewBroker(BrokerConfig{ClientBuffer: 1})
+
+	pr, pw := io.Pipe()
+	slow := &Stream{w: bufio.NewWriterSize(pw, 16), done: make(chan struct{})}
+	fast, out := testStream("")
+	done := serve(b, slow, fast)
+	waitClients(t, b, 2)
+
+	for i := 0; i < 5; i++ {
+		b.Publish(Event{Data: "0123456789abcdef"})
+		time.Sleep(time.Millisecond)
+	}
+	waitClients(t, b, 1)
+
+	pr.Close()
+	b.Close()
+	<-done
+	if n := bytes.Count(out.Bytes(), []byte("data: ")); n != 5 {
+		t.Fatalf("fast client got %d events, want 5", n)
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	b := NewBuffer(3)
+	for _, id := range []string{"1", "2", "3", "4"} {
+		b.Add(Event{ID: id})
+	}
+	if _, ok := b.Since("1"); ok {
+		t.Fatal("event 1 should be dropped")
+	}
+	events, ok := b.Since("2")
+	if !ok || len(events) != 2 || events[0].ID != "3" || events[1].ID != "4" {
+		t.Fatalf("Since(2) = %v, %v", events, ok)
+	}
+	if events, ok := b.Since("4"); !ok || len(events) != 0 {
+		t.Fatalf("Since(4) = %v, %v", events, ok)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func (b *syncBuffer) String() string {
+	return string(b.Bytes())
+}
+
+func testStream(lastEventID string) (*Stream, *syncBuffer) {
+	out := &syncBuffer{}
+	return &Stream{LastEventID: lastEventID, w: bufio.NewWriter(out), done: make(chan struct{})}, out
+}
+
+func serve(b *Broker, streams ...*Stream) <-chan struct{} {
+	var wg sync.WaitGroup
+	for _, s := range streams {
+		wg.Add(1)
+		go func(s *Stream) {
+			defer wg.Done()
+			b.Serve(s)
+		}(s)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+func waitClients(t *testing.T, b *Broker, n int) {
+	for i := 0; i < 100; i++ {
+		if b.Clients() == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("broker has %d clients, want %d", b.Clients(), n)
+}
+
+func newFastHTTPTester(t *testing.T, h phi.Handler) *httpexpect.Expect {
+	return httpexpect.WithConfig(httpexpect.Config{
+		// Pass requests directly to FastHTTPHandler.
+		Client: &http.Client{
+			Transport: httpexpect.NewFastBinder(fasthttp.RequestHandler(h.ServeFastHTTP)),
+			Jar:       httpexpect.NewJar(),
+		},
+		// Report errors using testify.
+		Reporter: httpexpect.NewAssertReporter(t),
+	})
+}
@@ -6,62 +6,14 @@ package phi
 
 import (
 	"fmt"
-	"math"
 	"regexp"
+	"regexp/syntax"
 	"sort"
-	"strconv"
 	"strings"
+	"sync/atomic"
+	"unicode"
 )
 
-type methodTyp int
-
-const (
-	mSTUB methodTyp = 1 << iota
-	mCONNECT
-	mDELETE
-	mGET
-	mHEAD
-	mOPTIONS
-	mPATCH
-	mPOST
-	mPUT
-	mTRACE
-)
-
-var mALL = mCONNECT | mDELETE | mGET | mHEAD |
-	mOPTIONS | mPATCH | mPOST | mPUT | mTRACE
-
-var methodMap = map[string]methodTyp{
-	"CONNECT": mCONNECT,
-	"DELETE":  mDELETE,
-	"GET":     mGET,
-	"HEAD":    mHEAD,
-	"OPTIONS": mOPTIONS,
-	"PATCH":   mPATCH,
-	"POST":    mPOST,
-	"PUT":     mPUT,
-	"TRACE":   mTRACE,
-}
-
-// RegisterMethod registers new methods which can be used in
-// `Router.Method` call
-func RegisterMethod(method string) {
-	if method == "" {
-		return
-	}
-	method = strings.ToUpper(method)
-	if _, ok := methodMap[method]; ok {
-		return
-	}
-	n := len(methodMap)
-	if n > strconv.IntSize {
-		panic(fmt.Sprintf("phi: max number of methods reached (%d)", strconv.IntSize))
-	}
-	mt := methodTyp(math.Exp2(float64(n)))
-	methodMap[method] = mt
-	mALL |= mt
-}
-
 type nodeTyp uint8
 
 const (
@@ -87,6 +39,15 @@ type node struct {
 	// regexp matcher for regexp nodes
 	rex *regexp.Regexp
 
+	// spans is set on the regexp nodes whose values may contain their
+	// tail delimiter, e.g. {path:.+} followed by '/'
+	spans bool
+
+	// highest priority of the routes below the node, and insertion
+	// sequence number, ordering param and regexp siblings
+	priority int
+	seq      uint64
+
 	// HTTP handler endpoints on the leaf node
 	endpoints endpoints
 
@@ -96,6 +57,11 @@ type node struct {
 	// child nodes should be stored in-order for iteration,
 	// in groups of the node type.
 	children [ntCatchAll + 1]nodes
+
+	// static children indexed by their label minus edgeBase, set once
+	// the tree is frozen, see Mux.Freeze
+	edges    nodes
+	edgeBase byte
 }
 
 // endpoints is a mapping of http method constants to handlers
@@ -111,6 +77,59 @@ type endpoint struct {
 
 	// parameter keys recorded on handler nodes
 	paramKeys []string
+
+	// values of the optional params left out of the route, set on the
+	// endpoints of the shorter forms of a pattern, see patExpand
+	defaults RouteParams
+
+	// position of the params of the route by key, the last one of a key
+	// repeated, see Context.URLParam
+	paramIndex map[string]int
+
+	// metadata declared for the route, see Mux.Describe
+	meta *RouteMeta
+
+	// endpoints registered for an API version, see Mux.Version
+	versions map[string]*endpoint
+
+	// priority of the route among its param and regexp siblings, see
+	// Mux.Priority
+	priority int
+}
+
+// set records the endpoint `v`, or one of its versions.
+func (e *endpoint) set(version string, v endpoint) {
+	if version != "" {
+		if e.versions == nil {
+			e.versions = make(map[string]*endpoint)
+		}
+		e.versions[version] = &v
+		return
+	}
+	versions := e.versions
+	*e = v
+	e.versions = versions
+}
+
+// forVersion selects the endpoint serving the API version of the request:
+// the one registered for that version, or the unversioned one, or the one
+// of the default version.
+func (e *endpoint) forVersion(rctx *Context) *endpoint {
+	if e == nil {
+		return nil
+	}
+	if len(e.versions) > 0 {
+		if v, ok := e.versions[rctx.Version]; ok {
+			return v
+		}
+		if e.handler == nil {
+			return e.versions[rctx.defaultVersion]
+		}
+	}
+	if e.handler == nil {
+		return nil
+	}
+	return e
 }
 
 func (s endpoints) Value(method methodTyp) *endpoint {
@@ -123,14 +142,35 @@ func (s endpoints) Value(method methodTyp) *endpoint {
 }
 
 func (n *node) InsertRoute(method methodTyp, pattern string, handler Handler) *node {
+	return n.insertRoute(method, pattern, nil, handler)
+}
+
+// insertRoute inserts the route handler, for the API version and with the
+// priority of its `meta` if any. The shorter forms of patterns with
+// optional params are inserted too, and the node of the full pattern is
+// returned.
+func (n *node) insertRoute(method methodTyp, pattern string, meta *RouteMeta, handler Handler) *node {
+	var hn *node
+	for i, key := range patExpand(pattern) {
+		kn := n.insertNode(method, key, pattern, meta, handler)
+		n.updatePriorities(key)
+		if i == 0 {
+			hn = kn
+		}
+	}
+	return hn
+}
+
+// insertNode inserts the route `pattern` along the tree `key`.
+func (n *node) insertNode(method methodTyp, key, pattern string, meta *RouteMeta, handler Handler) *node {
 	var parent *node
-	search := pattern
+	search := key
 
 	for {
 		// Handle key exhaustion
 		if len(search) == 0 {
 			// Insert or update the node's leaf handler
-			n.setEndpoint(method, handler, pattern)
+			n.setEndpoint(method, handler, key, pattern, meta)
 			return n
 		}
 
@@ -146,9 +186,12 @@ func (n *node) InsertRoute(method methodTyp, pattern string, handler Handler) *n
 		}
 
 		var prefix string
-		if segTyp == ntRegexp {
+		if segTyp == ntRegexp || segTyp == ntCatchAll {
 			prefix = segRexpat
 		}
+		if segTyp == ntCatchAll {
+			label = '*'
+		}
 
 		// Look for the edge to attach to
 		parent = n
@@ -158,7 +201,7 @@ func (n *node) InsertRoute(method methodTyp, pattern string, handler Handler) *n
 		if n == nil {
 			child := &node{label: label, tail: segTail, prefix: search}
 			hn := parent.addChild(child, search)
-			hn.setEndpoint(method, handler, pattern)
+			hn.setEndpoint(method, handler, key, pattern, meta)
 
 			return hn
 		}
@@ -198,7 +241,7 @@ func (n *node) InsertRoute(method methodTyp, pattern string, handler Handler) *n
 		// If the new key is a subset, set the method/handler on this node and finish.
 		search = search[commonPrefix:]
 		if len(search) == 0 {
-			child.setEndpoint(method, handler, pattern)
+			child.setEndpoint(method, handler, key, pattern, meta)
 			return child
 		}
 
@@ -209,7 +252,7 @@ func (n *node) InsertRoute(method methodTyp, pattern string, handler Handler) *n
 			prefix: search,
 		}
 		hn := child.addChild(subchild, search)
-		hn.setEndpoint(method, handler, pattern)
+		hn.setEndpoint(method, handler, key, pattern, meta)
 		return hn
 	}
 }
@@ -238,13 +281,14 @@ func (n *node) addChild(child *node, prefix string) *node {
 	default:
 		// Search prefix contains a param, regexp or wildcard
 
-		if segTyp == ntRegexp {
+		if segRexpat != "" {
 			rex, err := regexp.Compile(segRexpat)
 			if err != nil {
 				panic(fmt.Sprintf("phi: invalid regexp pattern '%s' in route param", segRexpat))
 			}
 			child.prefix = segRexpat
 			child.rex = rex
+			child.spans = segTyp == ntRegexp && rexMatchesByte(segRexpat, segTail)
 		}
 
 		if segStartIdx == 0 {
@@ -252,6 +296,8 @@ func (n *node) addChild(child *node, prefix string) *node {
 			child.typ = segTyp
 
 			if segTyp == ntCatchAll {
+				child.label = '*'
+				child.prefix = segRexpat
 				segStartIdx = -1
 			} else {
 				segStartIdx = segEndIdx
@@ -283,6 +329,7 @@ func (n *node) addChild(child *node, prefix string) *node {
 			child.typ = ntStatic
 			child.prefix = search[:segStartIdx]
 			child.rex = nil
+			child.spans = false
 
 			// add the param edge node
 			search = search[segStartIdx:]
@@ -292,11 +339,15 @@ func (n *node) addChild(child *node, prefix string) *node {
 				label: search[0],
 				tail:  segTail,
 			}
+			if segTyp == ntCatchAll {
+				nn.label = '*'
+			}
 			hn = child.addChild(nn, search)
 
 		}
 	}
 
+	child.seq = atomic.AddUint64(&nodeSeq, 1)
 	n.children[child.typ] = append(n.children[child.typ], child)
 	n.children[child.typ].Sort()
 	return hn
@@ -318,7 +369,7 @@ func (n *node) getEdge(ntyp nodeTyp, label, tail byte, prefix string) *node {
 	nds := n.children[ntyp]
 	for i := 0; i < len(nds); i++ {
 		if nds[i].label == label && nds[i].tail == tail {
-			if ntyp == ntRegexp && nds[i].prefix != prefix {
+			if (ntyp == ntRegexp || ntyp == ntCatchAll) && nds[i].prefix != prefix {
 				continue
 			}
 			return nds[i]
@@ -327,34 +378,347 @@ func (n *node) getEdge(ntyp nodeTyp, label, tail byte, prefix string) *node {
 	return nil
 }
 
-func (n *node) setEndpoint(method methodTyp, handler Handler, pattern string) {
+func (n *node) setEndpoint(method methodTyp, handler Handler, key, pattern string, meta *RouteMeta) {
 	// Set the handler for the method type on the node
 	if n.endpoints == nil {
 		n.endpoints = make(endpoints)
 	}
 
-	paramKeys := patParamKeys(pattern)
+	e := endpoint{handler: handler, pattern: pattern, paramKeys: patParamKeys(key), meta: meta}
+	var version string
+	if meta != nil {
+		version, e.priority = meta.Version, meta.Priority
+	}
+	if key != pattern {
+		e.defaults = patDefaults(pattern, e.paramKeys)
+	}
+	e.paramIndex = paramIndex(e.paramKeys, e.defaults.Keys)
 
 	if method&mSTUB == mSTUB {
 		n.endpoints.Value(mSTUB).handler = handler
 	}
 	if method&mALL == mALL {
-		h := n.endpoints.Value(mALL)
-		h.handler = handler
-		h.pattern = pattern
-		h.paramKeys = paramKeys
-		for _, m := range methodMap {
-			h := n.endpoints.Value(m)
-			h.handler = handler
-			h.pattern = pattern
-			h.paramKeys = paramKeys
+		n.endpoints.Value(mALL).set(version, e)
+		eachMethod(method, func(m methodTyp) {
+			n.endpoints.Value(m).set(version, e)
+		})
+	} else {
+		n.endpoints.Value(method).set(version, e)
+	}
+}
+
+// clone returns a deep copy of the tree rooted at n, sharing only the
+// handlers, regexps and subroutes.
+func (n *node) clone() *node {
+	cn := *n
+	cn.edges = nil
+	if n.endpoints != nil {
+		cn.endpoints = make(endpoints, len(n.endpoints))
+		for m, e := range n.endpoints {
+			ce := *e
+			if e.versions != nil {
+				ce.versions = make(map[string]*endpoint, len(e.versions))
+				for v, ve := range e.versions {
+					cve := *ve
+					ce.versions[v] = &cve
+				}
+			}
+			cn.endpoints[m] = &ce
+		}
+	}
+	for t, nds := range n.children {
+		if nds == nil {
+			continue
+		}
+		cn.children[t] = make(nodes, len(nds))
+		for i, child := range nds {
+			cn.children[t][i] = child.clone()
+		}
+	}
+	return &cn
+}
+
+// findPatternPath returns the nodes leading from n to the node holding the
+// endpoints of `pattern`, or nil if there's none. Param names aren't
+// compared, the caller checks the endpoint patterns.
+func (n *node) findPatternPath(pattern string) []*node {
+	var path []*node
+	search := pattern
+
+	for len(search) > 0 {
+		var label = search[0]
+		var segTail byte
+		var segEndIdx int
+		var segTyp nodeTyp
+		var segRexpat string
+		if label == '{' || label == '*' {
+			segTyp, _, segRexpat, segTail, _, segEndIdx = patNextSegment(search)
+		}
+
+		var prefix string
+		if segTyp == ntRegexp || segTyp == ntCatchAll {
+			prefix = segRexpat
+		}
+		if segTyp == ntCatchAll {
+			label = '*'
+		}
+
+		n = n.getEdge(segTyp, label, segTail, prefix)
+		if n == nil {
+			return nil
 		}
+		path = append(path, n)
+
+		if n.typ > ntStatic {
+			search = search[segEndIdx:]
+			continue
+		}
+		if !strings.HasPrefix(search, n.prefix) {
+			return nil
+		}
+		search = search[len(n.prefix):]
+	}
+
+	return path
+}
+
+// hasRoute reports whether a `method` handler is registered for `pattern`
+// and the API `version`, or the unversioned one if empty. Param names
+// aren't compared, as patterns differing by them share their endpoints.
+func (n *node) hasRoute(method methodTyp, pattern, version string) bool {
+	return n.routePattern(method, pattern, version) != ""
+}
+
+// routeMethods returns which of the `methods` a handler is registered for
+// with `pattern` and the API `version`, and whether the route was
+// registered for all the methods at once, like with Handle.
+func (n *node) routeMethods(methods methodTyp, pattern, version string) (methodTyp, bool) {
+	var found methodTyp
+	eachMethod(methods, func(m methodTyp) {
+		if n.hasRoute(m, pattern, version) {
+			found |= m
+		}
+	})
+
+	all := false
+	if route := n.routeNode(pattern); route != nil && methods&mALL == mALL {
+		e := route.endpoints[mALL]
+		if e != nil && version != "" {
+			e = e.versions[version]
+		}
+		all = e != nil && e.handler != nil
+	}
+	return found, all
+}
+
+// routePattern returns the pattern of the `method` handler registered for
+// `pattern` and the API `version`, which may differ from `pattern` by its
+// param names, or "" if there's none. With mALL, any method is matched.
+func (n *node) routePattern(method methodTyp, pattern, version string) string {
+	path := n.findPatternPath(pattern)
+	if len(path) == 0 {
+		return ""
+	}
+	eps := path[len(path)-1].endpoints
+	get := func(e *endpoint) string {
+		if e == nil {
+			return ""
+		}
+		if version != "" {
+			if e = e.versions[version]; e == nil {
+				return ""
+			}
+		}
+		if e.handler == nil {
+			return ""
+		}
+		return e.pattern
+	}
+	if method&mALL == mALL {
+		var p string
+		eachMethod(method, func(m methodTyp) {
+			if p == "" {
+				p = get(eps[m])
+			}
+		})
+		return p
+	}
+	return get(eps[method])
+}
+
+// removeRoute removes the `method` handler of `pattern` for the API
+// `version`, or the unversioned one if empty. Emptied nodes are pruned
+// from the tree, and static nodes left with a single static child are
+// merged back with it. It reports whether the route was found.
+func (n *node) removeRoute(method methodTyp, pattern, version string) bool {
+	found := false
+	for _, key := range patExpand(pattern) {
+		if n.removeKey(method, key, pattern, version) {
+			found = true
+		}
+	}
+	return found
+}
+
+// removeKey removes the route `pattern` inserted along the tree `key`.
+func (n *node) removeKey(method methodTyp, key, pattern, version string) bool {
+	path := n.findPatternPath(key)
+	if len(path) == 0 {
+		return false
+	}
+	target := path[len(path)-1]
+
+	// remove reports whether the endpoint held the route
+	remove := func(m methodTyp) bool {
+		e := target.endpoints[m]
+		if e == nil {
+			return false
+		}
+		if version != "" {
+			if v, ok := e.versions[version]; !ok || v.pattern != pattern {
+				return false
+			}
+			delete(e.versions, version)
+		} else {
+			if e.handler == nil || e.pattern != pattern {
+				return false
+			}
+			e.handler, e.pattern, e.paramKeys, e.paramIndex, e.meta = nil, "", nil, nil, nil
+		}
+		if e.handler == nil && len(e.versions) == 0 {
+			delete(target.endpoints, m)
+		}
+		return true
+	}
+
+	found := false
+	if method&mALL == mALL {
+		found = remove(mALL)
+		eachMethod(method, func(m methodTyp) {
+			if remove(m) {
+				found = true
+			}
+		})
 	} else {
-		h := n.endpoints.Value(method)
-		h.handler = handler
-		h.pattern = pattern
-		h.paramKeys = paramKeys
+		found = remove(method)
+	}
+	if !found {
+		return false
+	}
+
+	// Drop the mount stub along with the last endpoint
+	if len(target.endpoints) == 1 && target.endpoints[mSTUB] != nil {
+		delete(target.endpoints, mSTUB)
+		target.subroutes = nil
+	}
+	if len(target.endpoints) == 0 {
+		target.endpoints = nil
+	}
+
+	// Prune the emptied nodes bottom-up, then re-merge the prefixes
+	for i := len(path) - 1; i >= 0; i-- {
+		nn := path[i]
+		parent := n
+		if i > 0 {
+			parent = path[i-1]
+		}
+		if nn.endpoints == nil && nn.subroutes == nil && !nn.hasChildren() {
+			parent.removeChild(nn)
+			continue
+		}
+		nn.mergeChild()
+		if p := nn.computePriority(); p != nn.priority {
+			nn.priority = p
+			parent.children[nn.typ].Sort()
+		}
+	}
+
+	return true
+}
+
+// updatePriorities recomputes the priorities of the nodes along
+// `pattern`, bottom-up, and re-sorts their siblings accordingly.
+func (n *node) updatePriorities(pattern string) {
+	path := n.findPatternPath(pattern)
+	for i := len(path) - 1; i >= 0; i-- {
+		nn := path[i]
+		parent := n
+		if i > 0 {
+			parent = path[i-1]
+		}
+		if p := nn.computePriority(); p != nn.priority {
+			nn.priority = p
+			parent.children[nn.typ].Sort()
+		}
+	}
+}
+
+// computePriority returns the highest priority of the routes below n.
+func (n *node) computePriority() int {
+	p, found := 0, false
+	max := func(v int) {
+		if !found || v > p {
+			p, found = v, true
+		}
+	}
+	for m, e := range n.endpoints {
+		if m == mSTUB {
+			continue
+		}
+		if e.handler != nil {
+			max(e.priority)
+		}
+		for _, ve := range e.versions {
+			max(ve.priority)
+		}
+	}
+	for _, nds := range n.children {
+		for _, cn := range nds {
+			max(cn.priority)
+		}
 	}
+	return p
+}
+
+func (n *node) hasChildren() bool {
+	for _, nds := range n.children {
+		if len(nds) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *node) removeChild(child *node) {
+	nds := n.children[child.typ]
+	for i := range nds {
+		if nds[i] == child {
+			n.children[child.typ] = append(nds[:i:i], nds[i+1:]...)
+			return
+		}
+	}
+}
+
+// mergeChild merges a static node without endpoints into its only child,
+// when that one is static too, undoing the split done by InsertRoute.
+func (n *node) mergeChild() {
+	if n.typ != ntStatic || n.endpoints != nil || n.subroutes != nil {
+		return
+	}
+	if len(n.children[ntStatic]) != 1 {
+		return
+	}
+	for t := ntRegexp; t <= ntCatchAll; t++ {
+		if len(n.children[t]) > 0 {
+			return
+		}
+	}
+
+	child := n.children[ntStatic][0]
+	n.prefix += child.prefix
+	n.endpoints = child.endpoints
+	n.subroutes = child.subroutes
+	n.children = child.children
 }
 
 func (n *node) FindRoute(rctx *Context, method methodTyp, path string) (*node, endpoints, Handler) {
@@ -362,24 +726,34 @@ func (n *node) FindRoute(rctx *Context, method methodTyp, path string) (*node, e
 	rctx.routePattern = ""
 	rctx.routeParams.Keys = rctx.routeParams.Keys[:0]
 	rctx.routeParams.Values = rctx.routeParams.Values[:0]
+	rctx.routeWildcard = ""
 
 	// Find the routing handlers for the path
 	rn := n.findRoute(rctx, method, path)
 	if rn == nil {
 		return nil, nil, nil
 	}
+	if rn.typ == ntCatchAll {
+		rctx.routeWildcard = rctx.routeParams.Values[len(rctx.routeParams.Values)-1]
+	}
 
-	// Record the routing params in the request lifecycle
+	// Record the routing params in the request lifecycle, along with their
+	// index unless some params were added since the last ones recorded
+	h := rn.endpoints[method].forVersion(rctx)
+	if off := len(rctx.URLParams.Keys); len(rctx.routeParams.Keys) > 0 && off == rctx.paramsIndexed {
+		rctx.paramFrames = append(rctx.paramFrames, paramFrame{off: off, index: h.paramIndex})
+		rctx.paramsIndexed += len(rctx.routeParams.Keys)
+	}
 	rctx.URLParams.Keys = append(rctx.URLParams.Keys, rctx.routeParams.Keys...)
 	rctx.URLParams.Values = append(rctx.URLParams.Values, rctx.routeParams.Values...)
 
 	// Record the routing pattern in the request lifecycle
-	if rn.endpoints[method].pattern != "" {
-		rctx.routePattern = rn.endpoints[method].pattern
+	if h.pattern != "" {
+		rctx.routePattern = h.pattern
 		rctx.RoutePatterns = append(rctx.RoutePatterns, rctx.routePattern)
 	}
 
-	return rn, rn.endpoints, rn.endpoints[method].handler
+	return rn, rn.endpoints, h.handler
 }
 
 // nolint: gocyclo
@@ -405,7 +779,7 @@ func (n *node) findRoute(rctx *Context, method methodTyp, path string) *node {
 
 		switch ntyp {
 		case ntStatic:
-			xn = nds.findEdge(label)
+			xn = nn.staticEdge(label)
 			if xn == nil || !strings.HasPrefix(xsearch, xn.prefix) {
 				continue
 			}
@@ -421,6 +795,16 @@ func (n *node) findRoute(rctx *Context, method methodTyp, path string) *node {
 			for idx := 0; idx < len(nds); idx++ {
 				xn = nds[idx]
 
+				// regexps matching their delimiter try each of its
+				// positions, the longest value first
+				if xn.spans {
+					if fin := xn.findSpanningRoute(rctx, method, xsearch); fin != nil {
+						return fin
+					}
+					xn = nil
+					continue
+				}
+
 				// label for param nodes is the delimiter byte
 				p := strings.IndexByte(xsearch, xn.tail)
 
@@ -428,16 +812,19 @@ func (n *node) findRoute(rctx *Context, method methodTyp, path string) *node {
 					if xn.tail == '/' {
 						p = len(xsearch)
 					} else {
+						xn = nil
 						continue
 					}
 				}
 
 				if ntyp == ntRegexp && xn.rex != nil {
-					if !xn.rex.Match([]byte(xsearch[:p])) {
+					if !xn.rex.MatchString(xsearch[:p]) {
+						xn = nil
 						continue
 					}
 				} else if strings.IndexByte(xsearch[:p], '/') != -1 {
 					// avoid a match across path segments
+					xn = nil
 					continue
 				}
 
@@ -447,9 +834,17 @@ func (n *node) findRoute(rctx *Context, method methodTyp, path string) *node {
 			}
 
 		default:
-			// catch-all nodes
+			// catch-all nodes, the first one whose constraint matches
+			for _, cn := range nds {
+				if cn.rex == nil || cn.rex.MatchString(search) {
+					xn = cn
+					break
+				}
+			}
+			if xn == nil {
+				continue
+			}
 			rctx.routeParams.Values = append(rctx.routeParams.Values, search)
-			xn = nds[0]
 			xsearch = ""
 		}
 
@@ -458,18 +853,8 @@ func (n *node) findRoute(rctx *Context, method methodTyp, path string) *node {
 		}
 
 		// did we find it yet?
-		if len(xsearch) == 0 {
-			if xn.isLeaf() {
-				h := xn.endpoints[method]
-				if h != nil && h.handler != nil {
-					rctx.routeParams.Keys = append(rctx.routeParams.Keys, h.paramKeys...)
-					return xn
-				}
-
-				// flag that the routing context found a route, but not a corresponding
-				// supported method
-				rctx.methodNotAllowed = true
-			}
+		if len(xsearch) == 0 && xn.matchLeaf(rctx, method) {
+			return xn
 		}
 
 		// recursively find the next node..
@@ -490,6 +875,58 @@ func (n *node) findRoute(rctx *Context, method methodTyp, path string) *node {
 	return nil
 }
 
+// matchLeaf reports whether n holds a `method` handler, recording its
+// param keys.
+func (n *node) matchLeaf(rctx *Context, method methodTyp) bool {
+	if !n.isLeaf() {
+		return false
+	}
+	ep := n.endpoints[method]
+	if h := ep.forVersion(rctx); h != nil {
+		rctx.routeParams.Keys = append(rctx.routeParams.Keys, h.paramKeys...)
+		if len(h.defaults.Keys) > 0 {
+			rctx.routeParams.Keys = append(rctx.routeParams.Keys, h.defaults.Keys...)
+			rctx.routeParams.Values = append(rctx.routeParams.Values, h.defaults.Values...)
+		}
+		return true
+	}
+
+	// flag that the routing context found a route, but not a corresponding
+	// supported method
+	if ep == nil || (ep.handler == nil && len(ep.versions) == 0) {
+		rctx.methodNotAllowed = true
+	}
+	return false
+}
+
+// findSpanningRoute matches the regexp node n, whose values may contain
+// its tail delimiter, ending them at each of its positions in `search`,
+// or at the end of the path for the '/' tail, the longest first.
+func (n *node) findSpanningRoute(rctx *Context, method methodTyp, search string) *node {
+	for p := len(search); p > 0; p-- {
+		if p == len(search) {
+			if n.tail != '/' {
+				continue
+			}
+		} else if search[p] != n.tail {
+			continue
+		}
+		if !n.rex.MatchString(search[:p]) {
+			continue
+		}
+
+		rctx.routeParams.Values = append(rctx.routeParams.Values, search[:p])
+		if p == len(search) && n.matchLeaf(rctx, method) {
+			return n
+		}
+		if fin := n.findRoute(rctx, method, search[p:]); fin != nil {
+			return fin
+		}
+		rctx.routeParams.Values = rctx.routeParams.Values[:len(rctx.routeParams.Values)-1]
+	}
+	return nil
+}
+
 func (n *node) findEdge(ntyp nodeTyp, label byte) *node {
 	nds := n.children[ntyp]
 	num := len(nds)
@@ -573,23 +1010,49 @@ func (n *node) routes() []Route {
 			return false
 		}
 
-		// Group methodHandlers by unique patterns
-		pats := make(map[string]endpoints)
-
-		for mt, h := range eps {
-			if h.pattern == "" {
-				continue
+		// Group methodHandlers by unique patterns and API versions
+		type routeKey struct{ pattern, version string }
+		pats := make(map[routeKey]endpoints)
+		add := func(k routeKey, mt methodTyp, h *endpoint) {
+			if k.pattern == "" {
+				return
 			}
-			p, ok := pats[h.pattern]
+			p, ok := pats[k]
 			if !ok {
 				p = endpoints{}
-				pats[h.pattern] = p
+				pats[k] = p
 			}
 			p[mt] = h
 		}
 
-		for p, mh := range pats {
+		for mt, h := range eps {
+			// the shorter forms of patterns are reported along the
+			// full patterns
+			if len(h.defaults.Keys) == 0 {
+				add(routeKey{h.pattern, ""}, mt, h)
+			}
+			for v, vh := range h.versions {
+				if len(vh.defaults.Keys) == 0 {
+					add(routeKey{vh.pattern, v}, mt, vh)
+				}
+			}
+		}
+
+		keys := make([]routeKey, 0, len(pats))
+		for k := range pats {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].pattern != keys[j].pattern {
+				return keys[i].pattern < keys[j].pattern
+			}
+			return keys[i].version < keys[j].version
+		})
+
+		for _, k := range keys {
+			mh := pats[k]
 			hs := make(map[string]Handler)
+			var metas map[string]RouteMeta
 			if mh[mALL] != nil && mh[mALL].handler != nil {
 				hs["*"] = mh[mALL].handler
 			}
@@ -603,9 +1066,15 @@ func (n *node) routes() []Route {
 					continue
 				}
 				hs[m] = h.handler
+				if h.meta != nil {
+					if metas == nil {
+						metas = make(map[string]RouteMeta)
+					}
+					metas[m] = *h.meta
+				}
 			}
 
-			rt := Route{p, hs, subroutes}
+			rt := Route{Pattern: k.pattern, Version: k.version, Handlers: hs, SubRoutes: subroutes, Meta: metas, Precedence: len(rts)}
 			rts = append(rts, rt)
 		}
 
@@ -686,6 +1155,24 @@ func patNextSegment(pattern string) (nodeTyp, string, string, byte, int, int) {
 			key = key[:idx]
 		}
 
+		// Strip the optional and default value markers, see patExpand
+		if idx := strings.IndexAny(key, "?="); idx >= 0 {
+			key = key[:idx]
+		}
+
+		// Named catch-all, e.g. {path...} or {path...:[a-z/]+}
+		if strings.HasSuffix(key, "...") {
+			key = key[:len(key)-3]
+			if pe != len(pattern) {
+				panic(fmt.Sprintf("phi: catch-all param '%s' must be the last pattern in a route", key))
+			}
+			nt, tail = ntCatchAll, 0
+		}
+
+		if tail == '{' {
+			panic(fmt.Sprintf("phi: route param '%s' must be followed by a delimiter before the next param", key))
+		}
+
 		if len(rexpat) > 0 {
 			if rexpat[0] != '^' {
 				rexpat = "^" + rexpat
@@ -698,9 +1185,17 @@ func patNextSegment(pattern string) (nodeTyp, string, string, byte, int, int) {
 		return nt, key, rexpat, tail, ps, pe
 	}
 
-	// Wildcard pattern as finale
-	// TODO: should we panic if there is stuff after the * ???
-	return ntCatchAll, "*", "", 0, ws, len(pattern)
+	// Wildcard pattern as finale, optionally named, e.g. /*path
+	key := "*"
+	if name := pattern[ws+1:]; name != "" {
+		for _, c := range name {
+			if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' {
+				panic(fmt.Sprintf("phi: wildcard '*' must be the last pattern in a route, or be followed by a param name, in '%s'", pattern))
+			}
+		}
+		key = name
+	}
+	return ntCatchAll, key, "", 0, ws, len(pattern)
 }
 
 func patParamKeys(pattern string) []string {
@@ -721,6 +1216,173 @@ func patParamKeys(pattern string) []string {
 	}
 }
 
+// rexMatchesByte reports whether the regexp `rexpat` may match strings
+// containing the byte `b`.
+func rexMatchesByte(rexpat string, b byte) bool {
+	re, err := syntax.Parse(rexpat, syntax.Perl)
+	if err != nil {
+		return false
+	}
+	var matches func(re *syntax.Regexp) bool
+	matches = func(re *syntax.Regexp) bool {
+		switch re.Op {
+		case syntax.OpAnyChar:
+			return true
+		case syntax.OpAnyCharNotNL:
+			return b != '\n'
+		case syntax.OpLiteral, syntax.OpCharClass:
+			r := rune(b)
+			if re.Op == syntax.OpLiteral {
+				for _, lr := range re.Rune {
+					if lr == r || (re.Flags&syntax.FoldCase != 0 && unicode.SimpleFold(lr) == r) {
+						return true
+					}
+				}
+				return false
+			}
+			for i := 0; i < len(re.Rune); i += 2 {
+				if re.Rune[i] <= r && r <= re.Rune[i+1] {
+					return true
+				}
+			}
+			return false
+		}
+		for _, sub := range re.Sub {
+			if matches(sub) {
+				return true
+			}
+		}
+		return false
+	}
+	return matches(re)
+}
+
+// patParam is a param of a routing pattern, with its position in it.
+type patParam struct {
+	key      string
+	rexpat   string // as written in the pattern
+	optional bool
+	def      string
+	start    int
+	end      int
+}
+
+// patParams returns the params of `pattern`. A param is optional when its
+// key ends with '?', or has a default value after '=', e.g. {slug?},
+// {page=1} or {page=1:[0-9]+}.
+func patParams(pattern string) []patParam {
+	var params []patParam
+	for pos := 0; ; {
+		ptyp, key, _, _, ps, pe := patNextSegment(pattern[pos:])
+		if ptyp == ntStatic || ptyp == ntCatchAll {
+			return params
+		}
+		p := patParam{key: key, start: pos + ps, end: pos + pe}
+
+		name := pattern[p.start+1 : p.end-1]
+		if idx := strings.Index(name, ":"); idx >= 0 {
+			p.rexpat = name[idx+1:]
+			name = name[:idx]
+		}
+		if idx := strings.IndexAny(name, "?="); idx >= 0 {
+			p.optional = true
+			if name[idx] == '?' {
+				idx++
+			}
+			if idx < len(name) && name[idx] == '=' {
+				p.def = name[idx+1:]
+			}
+		}
+
+		params = append(params, p)
+		pos = p.end
+	}
+}
+
+// patExpand returns the keys `pattern` is inserted in the tree with: the
+// pattern itself, without the optional and default value markers of its
+// params, then its shorter forms, leaving out its optional params one by
+// one from the end, along with the delimiter before them. Optional params
+// may only be followed by other optional params, e.g.
+// /archive/{year?}/{month?} is inserted as /archive/{year}/{month},
+// /archive/{year} and /archive.
+func patExpand(pattern string) []string {
+	params := patParams(pattern)
+
+	var (
+		key  strings.Builder
+		ends []int
+		pos  int
+	)
+	for i, p := range params {
+		key.WriteString(pattern[pos:p.start])
+		if i > 0 && params[i-1].optional && p.start-pos != 1 {
+			panic(fmt.Sprintf("phi: optional route param '%s' must be followed only by optional params in '%s'", params[i-1].key, pattern))
+		}
+		if p.optional {
+			ends = append(ends, key.Len()-1)
+		}
+		key.WriteString("{" + p.key)
+		if p.rexpat != "" {
+			key.WriteString(":" + p.rexpat)
+		}
+		key.WriteString("}")
+		pos = p.end
+	}
+	if len(ends) > 0 && (!params[len(params)-1].optional || pos != len(pattern)) {
+		panic(fmt.Sprintf("phi: optional route param '%s' must be at the end of '%s'", params[len(params)-1].key, pattern))
+	}
+	key.WriteString(pattern[pos:])
+
+	full := key.String()
+	keys := []string{full}
+	for i := len(ends) - 1; i >= 0; i-- {
+		k := full[:ends[i]]
+		if k == "" {
+			k = "/"
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// paramIndex returns the position of each of the route params `keys`,
+// followed by the keys of its defaults.
+func paramIndex(keys, defaults []string) map[string]int {
+	if len(keys)+len(defaults) == 0 {
+		return nil
+	}
+	index := make(map[string]int, len(keys)+len(defaults))
+	for i, k := range keys {
+		index[k] = i
+	}
+	for i, k := range defaults {
+		index[k] = len(keys) + i
+	}
+	return index
+}
+
+// patDefaults returns the values of the optional params of `pattern`
+// which aren't part of `paramKeys`.
+func patDefaults(pattern string, paramKeys []string) RouteParams {
+	var defaults RouteParams
+	for _, p := range patParams(pattern) {
+		if p.optional && !stringsContain(paramKeys, p.key) {
+			defaults.Add(p.key, p.def)
+		}
+	}
+	return defaults
+}
+
+func stringsContain(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 // longestPrefix finds the length of the shared prefix
 // of two strings
 func longestPrefix(k1, k2 string) int {
@@ -737,32 +1399,31 @@ func longestPrefix(k1, k2 string) int {
 	return i
 }
 
-func methodTypString(method methodTyp) string {
-	for s, t := range methodMap {
-		if method == t {
-			return s
-		}
-	}
-	return ""
-}
-
 type nodes []*node
 
-// Sort the list of nodes by label
-func (ns nodes) Sort()              { sort.Sort(ns); ns.tailSort() }
-func (ns nodes) Len() int           { return len(ns) }
-func (ns nodes) Swap(i, j int)      { ns[i], ns[j] = ns[j], ns[i] }
-func (ns nodes) Less(i, j int) bool { return ns[i].label < ns[j].label }
-
-// tailSort pushes nodes with '/' as the tail to the end of the list for param nodes.
-// The list order determines the traversal order.
-func (ns nodes) tailSort() {
-	for i := len(ns) - 1; i >= 0; i-- {
-		if ns[i].typ > ntStatic && ns[i].tail == '/' {
-			ns.Swap(i, len(ns)-1)
-			return
-		}
+// nodeSeq numbers the nodes in insertion order
+var nodeSeq uint64
+
+// Sort the list of nodes, which determines the traversal order of
+// findRoute within a node type. Static nodes are sorted by label for the
+// edge lookups. Param and regexp nodes are sorted by priority, then the
+// ones with '/' as the tail come last, as they'd match the segments of
+// the others, and then by insertion order.
+func (ns nodes) Sort()         { sort.Stable(ns) }
+func (ns nodes) Len() int      { return len(ns) }
+func (ns nodes) Swap(i, j int) { ns[i], ns[j] = ns[j], ns[i] }
+func (ns nodes) Less(i, j int) bool {
+	a, b := ns[i], ns[j]
+	if a.typ == ntStatic || b.typ == ntStatic {
+		return a.label < b.label
+	}
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	if (a.tail == '/') != (b.tail == '/') {
+		return b.tail == '/'
 	}
+	return a.seq < b.seq
 }
 
 func (ns nodes) findEdge(label byte) *node {
@@ -790,6 +1451,66 @@ type Route struct {
 	Pattern   string
 	Handlers  map[string]Handler
 	SubRoutes Routes
+
+	// Version is the API version the route was registered for with
+	// Mux.Version, empty for unversioned routes.
+	Version string
+
+	// Meta holds the metadata declared for each method handler,
+	// keyed like Handlers. It's nil if no metadata was declared.
+	Meta map[string]RouteMeta
+
+	// Precedence is the rank of the route in the order routes are tried
+	// when matching a request path, see Mux.Priority. Routes() returns
+	// the routes in that order.
+	Precedence int
+}
+
+// RouteMeta describes a route beyond its handler, such as the media types
+// it consumes and produces. It's declared with Mux.Describe and reported by
+// Routes(), so tools like docgen can document a router.
+type RouteMeta struct {
+	// Version is the API version the route is registered for, see
+	// Mux.Version.
+	Version string
+
+	// Priority orders the route among its param and regexp siblings, see
+	// Mux.Priority.
+	Priority int
+
+	// Consumes lists the request media types accepted by the route.
+	Consumes []string
+
+	// Produces lists the response media types the route can render.
+	Produces []string
+
+	// Encodings lists the request Content-Encodings accepted by the route.
+	Encodings []string
+
+	// Middlewares installed by Use, not reported by Routes()
+	middlewares Middlewares
+}
+
+// Use appends middlewares to the stack of the routes described, so that
+// the metadata and the middleware enforcing it are declared at once, e.g.
+// by middleware.DescribeContentType.
+func (m *RouteMeta) Use(middlewares ...Middleware) {
+	m.middlewares = append(m.middlewares, middlewares...)
+}
+
+func (m RouteMeta) clone() RouteMeta {
+	return RouteMeta{
+		Version:   m.Version,
+		Priority:  m.Priority,
+		Consumes:  append([]string(nil), m.Consumes...),
+		Produces:  append([]string(nil), m.Produces...),
+		Encodings: append([]string(nil), m.Encodings...),
+	}
+}
+
+func (m RouteMeta) isZero() bool {
+	return m.Version == "" && m.Priority == 0 && len(m.Consumes) == 0 && len(m.Produces) == 0 &&
+		len(m.Encodings) == 0
 }
 
 // WalkFunc is the type of the function called for each method and route visited by Walk.
@@ -3,6 +3,8 @@ package phi
 import (
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/valyala/fasthttp"
@@ -62,7 +64,7 @@ func TestTree(t *testing.T) {
 	tr.InsertRoute(mGET, "/admin/user/{id}", hUserShow)
 
 	tr.InsertRoute(mGET, "/admin/apps/{id}", hAdminAppShow)
-	tr.InsertRoute(mGET, "/admin/apps/{id}/*ff", hAdminAppShowCatchall) // TODO: ALLOWED...? prob not.. panic..?
+	tr.InsertRoute(mGET, "/admin/apps/{id}/*ff", hAdminAppShowCatchall) // named catch-all
 
 	tr.InsertRoute(mGET, "/admin/*ff", hStub) // catchall segment will get replaced by next route
 	tr.InsertRoute(mGET, "/admin/*", hAdminCatchall)
@@ -110,7 +112,7 @@ func TestTree(t *testing.T) {
 		{r: "/admin/hi", h: hAdminCatchall, k: []string{"*"}, v: []string{"hi"}},
 		{r: "/admin/lots/of/:fun", h: hAdminCatchall, k: []string{"*"}, v: []string{"lots/of/:fun"}},
 		{r: "/admin/apps/333", h: hAdminAppShow, k: []string{"id"}, v: []string{"333"}},
-		{r: "/admin/apps/333/woot", h: hAdminAppShowCatchall, k: []string{"id", "*"}, v: []string{"333", "woot"}},
+		{r: "/admin/apps/333/woot", h: hAdminAppShowCatchall, k: []string{"id", "ff"}, v: []string{"333", "woot"}},
 
 		{r: "/hubs/123/view", h: hHubView1, k: []string{"hubID"}, v: []string{"123"}},
 		{r: "/hubs/123/view/index.html", h: hHubView2, k: []string{"hubID", "*"}, v: []string{"123", "index.html"}},
@@ -430,6 +432,369 @@ func stringSliceEqual(a, b []string) bool {
 	return true
 }
 
+func TestTreeParamPatterns(t *testing.T) {
+	patterns := []string{
+		"/files/{name}.{ext}",
+		"/files/{name}",
+		"/img/{a}-{b}.{ext}",
+		"/posts/{id}/{slug?}",
+		"/archive/{year:[0-9]{4}}/{month=01:[0-9]{2}}/{day?=01}",
+		"/pages/{page=1}",
+		"/tags/{a?}-{b?}",
+		"/repos/{path:.+}/info",
+		"/raw/{path:.+}",
+		"/dl/{name:.+}.{ext:[a-z]+}",
+		"/v{major:[0-9]+}.{minor:[0-9]+}/status",
+	}
+	tr := &node{}
+	for _, p := range patterns {
+		tr.InsertRoute(mGET, p, newStub())
+	}
+
+	tests := []struct {
+		path    string
+		pattern string
+		params  map[string]string
+	}{
+		{"/files/a.png", "/files/{name}.{ext}", map[string]string{"name": "a", "ext": "png"}},
+		{"/files/a.b.c", "/files/{name}.{ext}", map[string]string{"name": "a", "ext": "b.c"}},
+		{"/files/a", "/files/{name}", map[string]string{"name": "a"}},
+		{"/img/x-y.png", "/img/{a}-{b}.{ext}", map[string]string{"a": "x", "b": "y", "ext": "png"}},
+		{"/img/x-y-z.png", "/img/{a}-{b}.{ext}", map[string]string{"a": "x", "b": "y-z", "ext": "png"}},
+		{"/img/x.png", "", nil},
+		{"/posts/1", "/posts/{id}/{slug?}", map[string]string{"id": "1", "slug": ""}},
+		{"/posts/1/hello", "/posts/{id}/{slug?}", map[string]string{"id": "1", "slug": "hello"}},
+		{"/posts/1/", "", nil},
+		{"/archive/2020", "/archive/{year:[0-9]{4}}/{month=01:[0-9]{2}}/{day?=01}", map[string]string{"year": "2020", "month": "01", "day": "01"}},
+		{"/archive/2020/05", "/archive/{year:[0-9]{4}}/{month=01:[0-9]{2}}/{day?=01}", map[string]string{"year": "2020", "month": "05", "day": "01"}},
+		{"/archive/2020/05/17", "/archive/{year:[0-9]{4}}/{month=01:[0-9]{2}}/{day?=01}", map[string]string{"year": "2020", "month": "05", "day": "17"}},
+		{"/archive/2020/5", "", nil},
+		{"/archive/20", "", nil},
+		{"/pages", "/pages/{page=1}", map[string]string{"page": "1"}},
+		{"/pages/3", "/pages/{page=1}", map[string]string{"page": "3"}},
+		{"/tags", "/tags/{a?}-{b?}", map[string]string{"a": "", "b": ""}},
+		{"/tags/x", "/tags/{a?}-{b?}", map[string]string{"a": "x", "b": ""}},
+		{"/tags/x-y", "/tags/{a?}-{b?}", map[string]string{"a": "x", "b": "y"}},
+		{"/repos/a/b/c/info", "/repos/{path:.+}/info", map[string]string{"path": "a/b/c"}},
+		{"/repos/info", "", nil},
+		{"/raw/a/b", "/raw/{path:.+}", map[string]string{"path": "a/b"}},
+		{"/dl/a.b.tar.gz", "/dl/{name:.+}.{ext:[a-z]+}", map[string]string{"name": "a.b.tar", "ext": "gz"}},
+		{"/dl/a/b.tar", "/dl/{name:.+}.{ext:[a-z]+}", map[string]string{"name": "a/b", "ext": "tar"}},
+		{"/dl/a.7z", "", nil},
+		{"/v1.2/status", "/v{major:[0-9]+}.{minor:[0-9]+}/status", map[string]string{"major": "1", "minor": "2"}},
+		{"/v1/status", "", nil},
+	}
+
+	for _, tt := range tests {
+		rctx := NewRouteContext()
+		_, _, h := tr.FindRoute(rctx, mGET, tt.path)
+		if tt.pattern == "" {
+			if h != nil {
+				t.Errorf("find '%s' expecting no route, got %s", tt.path, rctx.routePattern)
+			}
+			continue
+		}
+		if h == nil || rctx.routePattern != tt.pattern {
+			t.Errorf("find '%s' expecting pattern %s, got %s", tt.path, tt.pattern, rctx.routePattern)
+			continue
+		}
+		params := map[string]string{}
+		for i, k := range rctx.URLParams.Keys {
+			params[k] = rctx.URLParams.Values[i]
+		}
+		if len(rctx.URLParams.Keys) != len(rctx.URLParams.Values) || fmt.Sprintf("%v", params) != fmt.Sprintf("%v", tt.params) {
+			t.Errorf("find '%s' expecting params %v, got %v", tt.path, tt.params, rctx.URLParams)
+		}
+	}
+
+	// the shorter forms of patterns aren't reported as routes
+	if got := len(tr.routes()); got != len(patterns) {
+		t.Errorf("expected %d routes, got %d", len(patterns), got)
+	}
+
+	// and are removed along with the routes
+	if !tr.removeRoute(mGET, "/posts/{id}/{slug?}", "") {
+		t.Fatal("GET /posts/{id}/{slug?} should be removed")
+	}
+	for _, path := range []string{"/posts/1", "/posts/1/hello"} {
+		if _, _, h := tr.FindRoute(NewRouteContext(), mGET, path); h != nil {
+			t.Errorf("find '%s' expecting no route", path)
+		}
+	}
+}
+
+func TestTreeCatchAll(t *testing.T) {
+	patterns := []string{
+		"/files/{path...}",
+		"/docs/{page...:[a-z/]+\\.md}",
+		"/docs/*",
+		"/static/*asset",
+	}
+	tr := &node{}
+	for _, p := range patterns {
+		tr.InsertRoute(mGET, p, newStub())
+	}
+
+	tests := []struct {
+		path    string
+		pattern string
+		key     string
+		value   string
+	}{
+		{"/files/", "/files/{path...}", "path", ""},
+		{"/files/a/b.txt", "/files/{path...}", "path", "a/b.txt"},
+		{"/docs/guide/intro.md", "/docs/{page...:[a-z/]+\\.md}", "page", "guide/intro.md"},
+		{"/docs/guide/intro.html", "/docs/*", "*", "guide/intro.html"},
+		{"/static/css/main.css", "/static/*asset", "asset", "css/main.css"},
+	}
+
+	for _, tt := range tests {
+		rctx := NewRouteContext()
+		_, _, h := tr.FindRoute(rctx, mGET, tt.path)
+		if h == nil || rctx.routePattern != tt.pattern {
+			t.Errorf("find '%s' expecting pattern %s, got %s", tt.path, tt.pattern, rctx.routePattern)
+			continue
+		}
+		nx := len(rctx.URLParams.Keys) - 1
+		if nx < 0 || rctx.URLParams.Keys[nx] != tt.key || rctx.URLParams.Values[nx] != tt.value {
+			t.Errorf("find '%s' expecting %s=%s, got %v", tt.path, tt.key, tt.value, rctx.URLParams)
+		}
+		if rctx.routeWildcard != tt.value {
+			t.Errorf("find '%s' expecting wildcard %s, got %s", tt.path, tt.value, rctx.routeWildcard)
+		}
+	}
+
+	if !tr.removeRoute(mGET, "/docs/{page...:[a-z/]+\\.md}", "") {
+		t.Fatal("the constrained catch-all should be removed")
+	}
+	rctx := NewRouteContext()
+	if _, _, h := tr.FindRoute(rctx, mGET, "/docs/intro.md"); h == nil || rctx.routePattern != "/docs/*" {
+		t.Errorf("expecting /docs/*, got %s", rctx.routePattern)
+	}
+}
+
+func TestTreeInvalidPatterns(t *testing.T) {
+	patterns := []string{
+		"/{a}{b}",
+		"/posts/{slug?}/edit",
+		"/posts/{slug?}.json",
+		"/posts/{id?}/{slug}",
+		"/files/{name?}/*",
+		"/files/{path...}/raw",
+		"/files/*pa-th",
+	}
+	for _, p := range patterns {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected '%s' to panic", p)
+				}
+			}()
+			(&node{}).InsertRoute(mGET, p, newStub())
+		}()
+	}
+}
+
+func TestTreeRemoveRoute(t *testing.T) {
+	hIndex := newStub()
+	hUsers := newStub()
+	hUserList := newStub()
+	hUser := newStub()
+	hUserPosts := newStub()
+	hUserPost := newStub()
+	hFiles := newStub()
+
+	patterns := []string{"/", "/users", "/users/list", "/users/{id}", "/users/{id}/posts", "/users/{id:[0-9]+}/posts/{post}", "/files/*"}
+	build := func(skip ...string) *node {
+		tr := &node{}
+		for i, p := range patterns {
+			if stringsContain(skip, p) {
+				continue
+			}
+			tr.InsertRoute(mGET, p, []Handler{hIndex, hUsers, hUserList, hUser, hUserPosts, hUserPost, hFiles}[i])
+		}
+		return tr
+	}
+
+	tr := build()
+	tr.InsertRoute(mPOST, "/users", hUsers)
+
+	if tr.removeRoute(mGET, "/nope", "") || tr.removeRoute(mPUT, "/users", "") || tr.removeRoute(mGET, "/users/{name}/posts/{post}", "") {
+		t.Fatal("removed a route which isn't registered")
+	}
+
+	if !tr.removeRoute(mGET, "/users", "") {
+		t.Fatal("GET /users should be removed")
+	}
+	// the POST handler remains
+	if _, _, h := tr.FindRoute(NewRouteContext(), mPOST, "/users"); fmt.Sprintf("%v", h) != fmt.Sprintf("%v", hUsers) {
+		t.Fatal("POST /users should remain")
+	}
+	if !tr.removeRoute(mPOST, "/users", "") {
+		t.Fatal("POST /users should be removed")
+	}
+
+	// param names don't need to match the node, but the pattern does
+	if tr.removeRoute(mGET, "/users/{uid}", "") {
+		t.Fatal("removed a route with another param name")
+	}
+	if !tr.removeRoute(mGET, "/users/{id}", "") {
+		t.Fatal("GET /users/{id} should be removed")
+	}
+	if !tr.removeRoute(mGET, "/files/*", "") {
+		t.Fatal("GET /files/* should be removed")
+	}
+
+	tests := []struct {
+		path string
+		h    Handler
+	}{
+		{"/", hIndex},
+		{"/users", nil},
+		{"/users/list", hUserList},
+		{"/users/1", nil},
+		{"/users/1/posts", hUserPosts},
+		{"/users/1/posts/2", hUserPost},
+		{"/files/a", nil},
+	}
+	for _, tt := range tests {
+		_, _, h := tr.FindRoute(NewRouteContext(), mGET, tt.path)
+		if fmt.Sprintf("%v", h) != fmt.Sprintf("%v", tt.h) {
+			t.Errorf("find '%s' expecting handler:%v , got:%v", tt.path, tt.h, h)
+		}
+	}
+
+	// prefixes are merged back, as if the routes never existed
+	want := build("/users", "/users/{id}", "/files/*")
+	if got, want := dumpTree(tr), dumpTree(want); got != want {
+		t.Fatalf("unexpected tree after removal:\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, p := range []string{"/", "/users/list", "/users/{id}/posts", "/users/{id:[0-9]+}/posts/{post}"} {
+		if !tr.removeRoute(mGET, p, "") {
+			t.Fatalf("GET %s should be removed", p)
+		}
+	}
+	if got := dumpTree(tr); got != dumpTree(&node{}) {
+		t.Fatalf("expected an empty tree, got:\n%s", got)
+	}
+}
+
+func TestTreePriority(t *testing.T) {
+	type route struct {
+		pattern  string
+		priority int
+	}
+	routes := []route{
+		{"/articles/{slug:[a-z0-9-]+}", 0},
+		{"/articles/{date:[0-9-]+}", 1},
+		{"/articles/{id}", 0},
+		{"/articles/{name}.json", 0},
+		{"/articles/*", 5},
+		{"/articles/new", -1},
+	}
+	tests := []struct {
+		path    string
+		pattern string
+	}{
+		{"/articles/new", "/articles/new"},
+		{"/articles/2020-01-01", "/articles/{date:[0-9-]+}"},
+		{"/articles/hello-world", "/articles/{slug:[a-z0-9-]+}"},
+		{"/articles/Hello", "/articles/{id}"},
+		{"/articles/Hello.json", "/articles/{name}.json"},
+		{"/articles/Hello/x", "/articles/*"},
+	}
+
+	// the insertion order doesn't matter but for equal priorities
+	perms := [][]int{{0, 1, 2, 3, 4, 5}, {5, 4, 3, 2, 1, 0}, {3, 2, 1, 5, 0, 4}}
+	for _, perm := range perms {
+		tr := &node{}
+		for _, i := range perm {
+			rt := routes[i]
+			tr.insertRoute(mGET, rt.pattern, &RouteMeta{Priority: rt.priority}, newStub())
+		}
+		for _, tt := range tests {
+			rctx := NewRouteContext()
+			if _, _, h := tr.FindRoute(rctx, mGET, tt.path); h == nil || rctx.routePattern != tt.pattern {
+				t.Errorf("order %v: find '%s' expecting pattern %s, got %s", perm, tt.path, tt.pattern, rctx.routePattern)
+			}
+		}
+	}
+
+	// the first regexp matching the segment is used
+	tr := &node{}
+	tr.insertRoute(mGET, "/{any:[^/]+}/raw", &RouteMeta{Priority: 1}, newStub())
+	tr.InsertRoute(mGET, "/{id:[0-9]+}", newStub())
+	tr.InsertRoute(mGET, "/{name}", newStub())
+	rctx := NewRouteContext()
+	if tr.FindRoute(rctx, mGET, "/1"); rctx.routePattern != "/{name}" {
+		t.Fatalf("expected the param route, got %s", rctx.routePattern)
+	}
+
+	// equal priorities keep the insertion order
+	tr = &node{}
+	tr.InsertRoute(mGET, "/{b:[a-z]+}", newStub())
+	tr.InsertRoute(mGET, "/{a:[a-c]+}", newStub())
+	if tr.FindRoute(rctx, mGET, "/abc"); rctx.routePattern != "/{b:[a-z]+}" {
+		t.Fatalf("expected the first route registered, got %s", rctx.routePattern)
+	}
+
+	// the priorities are updated along with the routes
+	tr.insertRoute(mGET, "/{a:[a-c]+}/x", &RouteMeta{Priority: 1}, newStub())
+	if tr.FindRoute(rctx, mGET, "/abc"); rctx.routePattern != "/{a:[a-c]+}" {
+		t.Fatalf("expected the route with the highest priority, got %s", rctx.routePattern)
+	}
+	tr.removeRoute(mGET, "/{a:[a-c]+}/x", "")
+	if tr.FindRoute(rctx, mGET, "/abc"); rctx.routePattern != "/{b:[a-z]+}" {
+		t.Fatalf("expected the first route registered, got %s", rctx.routePattern)
+	}
+}
+
+func TestTreeClone(t *testing.T) {
+	h1, h2 := newStub(), newStub()
+	tr := &node{}
+	tr.InsertRoute(mGET, "/users/{id}", h1)
+
+	cl := tr.clone()
+	cl.InsertRoute(mGET, "/users/{id}", h2)
+	cl.InsertRoute(mGET, "/users/list", h2)
+
+	if _, _, h := tr.FindRoute(NewRouteContext(), mGET, "/users/1"); fmt.Sprintf("%v", h) != fmt.Sprintf("%v", h1) {
+		t.Fatal("clone modified the original endpoints")
+	}
+	if _, _, h := tr.FindRoute(NewRouteContext(), mGET, "/users/list"); fmt.Sprintf("%v", h) != fmt.Sprintf("%v", h1) {
+		t.Fatal("clone modified the original nodes")
+	}
+}
+
+// dumpTree renders the structure of the tree, to compare trees.
+func dumpTree(n *node) string {
+	var b strings.Builder
+	var dump func(n *node, depth int)
+	dump = func(n *node, depth int) {
+		var methods []string
+		for m, e := range n.endpoints {
+			if e.handler != nil {
+				methods = append(methods, methodTypString(m)+" "+e.pattern)
+			}
+		}
+		sort.Strings(methods)
+		prefix := n.prefix
+		if n.typ == ntParam || n.typ == ntCatchAll {
+			// param prefixes keep the pattern they were inserted with
+			prefix = ""
+		}
+		fmt.Fprintf(&b, "%s%d %q %q %v\n", strings.Repeat("  ", depth), n.typ, n.label, prefix, methods)
+		for _, nds := range n.children {
+			for _, c := range nds {
+				dump(c, depth+1)
+			}
+		}
+	}
+	dump(n, 0)
+	return b.String()
+}
+
 func BenchmarkTreeGet(b *testing.B) {
 	h1 := newStub()
 	h2 := newStub()
@@ -444,14 +809,21 @@ func BenchmarkTreeGet(b *testing.B) {
 	tr.InsertRoute(mGET, "/pinggggg", h2)
 	tr.InsertRoute(mGET, "/hello", h1)
 
-	mctx := NewRouteContext()
+	for name, path := range map[string]string{"static": "/ping", "param": "/ping/123/456"} {
+		b.Run(name, func(b *testing.B) {
+			mctx := NewRouteContext()
+			rpath := []byte(path)
 
-	b.ReportAllocs()
-	b.ResetTimer()
+			b.ReportAllocs()
+			b.ResetTimer()
 
-	for i := 0; i < b.N; i++ {
-		mctx.Reset()
-		tr.FindRoute(mctx, mGET, "/ping/123/456")
+			for i := 0; i < b.N; i++ {
+				mctx.Reset()
+				mctx.path = append(mctx.path[:0], rpath...)
+				tr.FindRoute(mctx, mGET, b2s(mctx.path))
+				mctx.RoutePattern()
+			}
+		})
 	}
 }
 
@@ -0,0 +1,504 @@
+package phi
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// DiagnosticKind is the kind of routing problem reported by Mux.Validate.
+type DiagnosticKind int
+
+const (
+	// DuplicateRoute is a method and pattern registered more than once, the
+	// last handler registered replaced the previous ones. Patterns only
+	// differing by their param names are the same route.
+	DuplicateRoute DiagnosticKind = iota + 1
+
+	// ShadowedRoute is a route which is never matched, as another route is
+	// matched first for all of its request paths.
+	ShadowedRoute
+
+	// OverlappingRoute is a static route whose path segment also matches
+	// the regexp param of a sibling route. The static route takes
+	// precedence, which may not be intended.
+	OverlappingRoute
+
+	// UnreachableRoute is a route whose pattern can't match any request
+	// path, e.g. a regexp param only matching an empty value.
+	UnreachableRoute
+)
+
+func (k DiagnosticKind) String() string {
+	switch k {
+	case DuplicateRoute:
+		return "duplicate"
+	case ShadowedRoute:
+		return "shadowed"
+	case OverlappingRoute:
+		return "overlapping"
+	case UnreachableRoute:
+		return "unreachable"
+	}
+	return "unknown"
+}
+
+// Diagnostic is a routing problem reported by Mux.Validate.
+type Diagnostic struct {
+	Kind DiagnosticKind
+
+	// Method is the http method of the route concerned, or "" if all of
+	// its methods are.
+	Method string
+
+	// Pattern is the route concerned, and Other the conflicting route
+	// if any. They're full patterns, including the Mount patterns.
+	Pattern string
+	Other   string
+}
+
+func (d Diagnostic) String() string {
+	route := d.Pattern
+	if d.Method != "" {
+		route = d.Method + " " + route
+	}
+	switch d.Kind {
+	case DuplicateRoute:
+		return fmt.Sprintf("%s is registered twice, replacing %s", route, d.Other)
+	case ShadowedRoute:
+		return fmt.Sprintf("%s is shadowed by %s", route, d.Other)
+	case OverlappingRoute:
+		return fmt.Sprintf("%s overlaps the regexp param of %s", route, d.Other)
+	case UnreachableRoute:
+		return fmt.Sprintf("%s can't match any request path", route)
+	}
+	return route
+}
+
+// Strict enables the strict mode of the router and of the routers later
+// created with Route: registering a route which Validate reports a
+// problem for panics, and AddRoute returns it as an error.
+func (mx *Mux) Strict() {
+	mx.tree.strict = true
+}
+
+// Validate reports the duplicate, shadowed, overlapping and unreachable
+// routes of the router and its mounted sub-routers.
+func (mx *Mux) Validate() []Diagnostic {
+	mx.tree.mu.Lock()
+	duplicates := append([]Diagnostic(nil), mx.tree.duplicates...)
+	mx.tree.mu.Unlock()
+
+	root := mx.tree.load()
+	diags := root.validate()
+
+	// Only report the duplicates which are still registered
+	for _, d := range duplicates {
+		if root.findPatternPath(d.Pattern) != nil {
+			diags = append(diags, d)
+		}
+	}
+
+	sortDiagnostics(diags)
+	return diags
+}
+
+// strictCheck returns the first problem of the routes of `root` matched
+// by `match`, if the router is in strict mode. Only the problems found
+// along the `patterns` just registered are looked for, see validatePatterns.
+func (mx *Mux) strictCheck(root *node, match func(d Diagnostic) bool, patterns ...string) error {
+	if !mx.tree.strict {
+		return nil
+	}
+	diags := root.validatePatterns(patterns...)
+	sortDiagnostics(diags)
+	for _, d := range diags {
+		if match(d) {
+			return fmt.Errorf("phi: %s", d)
+		}
+	}
+	return nil
+}
+
+// isRouteDiagnostic matches the problems concerning the `pattern` route.
+func isRouteDiagnostic(pattern string) func(d Diagnostic) bool {
+	return func(d Diagnostic) bool {
+		return d.Pattern == pattern || d.Other == pattern
+	}
+}
+
+// recordDuplicate records the route about to be replaced by registering
+// `pattern` for `method`, failing in strict mode.
+func (mx *Mux) recordDuplicate(root *node, method methodTyp, pattern string) error {
+	if method&mSTUB == mSTUB {
+		return nil
+	}
+	var other string
+	for _, key := range patExpand(pattern) {
+		if other = root.routePattern(method, key, mx.meta.Version); other != "" {
+			break
+		}
+	}
+	if other == "" {
+		return nil
+	}
+	d := Diagnostic{Kind: DuplicateRoute, Method: methodTypString(method), Pattern: pattern, Other: other}
+	if mx.tree.strict {
+		return fmt.Errorf("phi: %s", d)
+	}
+	mx.tree.duplicates = append(mx.tree.duplicates, d)
+	return nil
+}
+
+// prunedDuplicates returns the duplicates recorded but the ones of the
+// `method` route `pattern` of `root`, which is about to be replaced or
+// removed.
+func (t *routingTree) prunedDuplicates(root *node, method methodTyp, pattern string) []Diagnostic {
+	route := root.routeNode(pattern)
+	if route == nil {
+		return t.duplicates
+	}
+	var dups []Diagnostic
+	for _, d := range t.duplicates {
+		mt := mALL
+		if d.Method != "" {
+			mt, _ = lookupMethod(d.Method)
+		}
+		if mt&method != 0 && root.routeNode(d.Pattern) == route {
+			continue
+		}
+		dups = append(dups, d)
+	}
+	return dups
+}
+
+// routeNode returns the node of the tree rooted at n which holds the
+// endpoints of `pattern`, if any.
+func (n *node) routeNode(pattern string) *node {
+	path := n.findPatternPath(pattern)
+	if len(path) == 0 {
+		return nil
+	}
+	return path[len(path)-1]
+}
+
+func sortDiagnostics(diags []Diagnostic) {
+	sort.Slice(diags, func(i, j int) bool {
+		a, b := diags[i], diags[j]
+		if a.Pattern != b.Pattern {
+			return a.Pattern < b.Pattern
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Other < b.Other
+	})
+}
+
+// validate reports the problems of the routes of the tree rooted at n,
+// following the traversal order of findRoute.
+func (n *node) validate() []Diagnostic {
+	v := newValidator(n)
+	var visit func(n *node)
+	visit = func(n *node) {
+		v.check(n)
+		for _, nds := range n.children {
+			for _, cn := range nds {
+				visit(cn)
+			}
+		}
+	}
+	visit(n)
+	return v.diags
+}
+
+// validatePatterns reports the problems of the tree rooted at n which may
+// concern the routes of `patterns`: the ones of the nodes along their
+// paths, and the mounted routes they shadow. It spares checking the whole
+// tree on each registration in strict mode.
+func (n *node) validatePatterns(patterns ...string) []Diagnostic {
+	v := newValidator(n)
+
+	path := []*node{n}
+	onPath := map[*node]bool{n: true}
+	for _, pattern := range patterns {
+		for _, key := range patExpand(pattern) {
+			for _, pn := range n.findPatternPath(key) {
+				if !onPath[pn] {
+					onPath[pn] = true
+					path = append(path, pn)
+				}
+			}
+		}
+	}
+
+	for _, pn := range path {
+		v.check(pn)
+
+		// The routes mounted along the path may be shadowed by the new ones
+		for _, cn := range pn.children[ntCatchAll] {
+			if !onPath[cn] && cn.subroutes != nil {
+				v.checkMount(cn, false)
+			}
+		}
+	}
+	return v.diags
+}
+
+// validator collects the problems of the routes of a routing tree.
+type validator struct {
+	root  *node
+	diags []Diagnostic
+	seen  map[Diagnostic]bool
+}
+
+func newValidator(root *node) *validator {
+	return &validator{root: root, seen: make(map[Diagnostic]bool)}
+}
+
+func (v *validator) report(d Diagnostic) {
+	if !v.seen[d] {
+		v.seen[d] = true
+		v.diags = append(v.diags, d)
+	}
+}
+
+// check reports the problems of the children of n, and of the router
+// mounted on n.
+func (v *validator) check(n *node) {
+	rexs := n.children[ntRegexp]
+	for j, rn := range rexs {
+		re := segmentRegexp(rn.prefix)
+		if re == nil {
+			continue
+		}
+
+		// The first regexp matching the segment is used, later ones
+		// matching a subset of its values are never tried. Regexps
+		// spanning their delimiter are skipped if their routes fail.
+		if re.Op == syntax.OpEmptyMatch || re.Op == syntax.OpNoMatch || (re.Op == syntax.OpCharClass && len(re.Rune) == 0) {
+			for _, p := range rn.patterns() {
+				v.report(Diagnostic{Kind: UnreachableRoute, Pattern: p})
+			}
+		} else {
+			for _, prev := range rexs[:j] {
+				if !prev.spans && prev.tail == rn.tail && coversRegexp(segmentRegexp(prev.prefix), re, prev.tail) {
+					other := prev.patterns()
+					for _, p := range rn.patterns() {
+						if len(other) > 0 {
+							v.report(Diagnostic{Kind: ShadowedRoute, Pattern: p, Other: other[0]})
+						}
+					}
+					break
+				}
+			}
+		}
+
+		// Static segments take precedence over the regexp
+		other := rn.patterns()
+		if len(other) == 0 {
+			continue
+		}
+		n.staticSegments(rn.tail, "", func(seg string, patterns []string) {
+			if rn.rex.MatchString(seg) {
+				for _, p := range patterns {
+					v.report(Diagnostic{Kind: OverlappingRoute, Pattern: p, Other: other[0]})
+				}
+			}
+		})
+	}
+
+	// Routes of mounted routers matched by the router first
+	if n.subroutes != nil && n.typ == ntCatchAll {
+		v.checkMount(n, true)
+	}
+}
+
+// checkMount reports the routes of the router mounted on n which are
+// shadowed by the routes of the tree, along with the problems of the
+// mounted router itself when `deep` is true.
+func (v *validator) checkMount(n *node, deep bool) {
+	e := n.endpoints[mALL]
+	if e == nil {
+		return
+	}
+	prefix := strings.TrimSuffix(e.pattern, "/*")
+
+	for _, rt := range n.subroutes.Routes() {
+		if rt.SubRoutes != nil || rt.Pattern == "/" {
+			continue
+		}
+		pattern := prefix + rt.Pattern
+		for _, m := range sortedMethods(rt.Handlers) {
+			mt, ok := lookupMethod(m)
+			if !ok {
+				continue
+			}
+			if other := v.root.routePattern(mt, pattern, rt.Version); other != "" {
+				v.report(Diagnostic{Kind: ShadowedRoute, Method: m, Pattern: pattern, Other: other})
+			}
+		}
+	}
+	if !deep {
+		return
+	}
+
+	// Problems of the mounted router itself
+	if sub, ok := n.subroutes.(*Mux); ok {
+		for _, d := range sub.Validate() {
+			d.Pattern = prefix + d.Pattern
+			if d.Other != "" {
+				d.Other = prefix + d.Other
+			}
+			v.report(d)
+		}
+	}
+}
+
+// staticSegments calls fn with the static path segments below n ending at
+// the `tail` delimiter, or at the end of a route when `tail` is '/', along
+// with the patterns of the routes they lead to.
+func (n *node) staticSegments(tail byte, prefix string, fn func(seg string, patterns []string)) {
+	for _, cn := range n.children[ntStatic] {
+		seg := prefix + cn.prefix
+		if i := strings.IndexByte(seg, tail); i >= 0 {
+			if i > 0 {
+				fn(seg[:i], cn.patterns())
+			}
+			continue
+		}
+		if tail == '/' && cn.endpoints != nil {
+			fn(seg, cn.endpointPatterns())
+		}
+		cn.staticSegments(tail, seg, fn)
+	}
+}
+
+// patterns returns the sorted patterns of the routes below n.
+func (n *node) patterns() []string {
+	var pats []string
+	n.walk(func(eps endpoints, subroutes Routes) bool {
+		pats = append(pats, eps.patterns()...)
+		return false
+	})
+	sort.Strings(pats)
+	return uniqueStrings(pats)
+}
+
+// endpointPatterns returns the sorted patterns of the routes of n.
+func (n *node) endpointPatterns() []string {
+	pats := n.endpoints.patterns()
+	sort.Strings(pats)
+	return uniqueStrings(pats)
+}
+
+func (eps endpoints) patterns() []string {
+	var pats []string
+	for m, e := range eps {
+		if m == mSTUB {
+			continue
+		}
+		if e.handler != nil {
+			pats = append(pats, e.pattern)
+		}
+		for _, ve := range e.versions {
+			pats = append(pats, ve.pattern)
+		}
+	}
+	return pats
+}
+
+func uniqueStrings(ss []string) []string {
+	out := ss[:0]
+	for i, s := range ss {
+		if i == 0 || s != ss[i-1] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func sortedMethods(handlers map[string]Handler) []string {
+	methods := make([]string, 0, len(handlers))
+	for m := range handlers {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// segmentRegexp parses the regexp of a param, without its anchors.
+func segmentRegexp(rexpat string) *syntax.Regexp {
+	re, err := syntax.Parse(rexpat, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	re = re.Simplify()
+
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+	for len(subs) > 0 && isAnchor(subs[0]) {
+		subs = subs[1:]
+	}
+	for len(subs) > 0 && isAnchor(subs[len(subs)-1]) {
+		subs = subs[:len(subs)-1]
+	}
+	switch len(subs) {
+	case 0:
+		return &syntax.Regexp{Op: syntax.OpEmptyMatch}
+	case 1:
+		return subs[0]
+	}
+	return &syntax.Regexp{Op: syntax.OpConcat, Flags: re.Flags, Sub: subs}
+}
+
+func isAnchor(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpBeginText, syntax.OpEndText, syntax.OpBeginLine, syntax.OpEndLine:
+		return true
+	}
+	return false
+}
+
+// coversRegexp reports whether the param values matched by `b` are all
+// matched by `a` too, as far as it can tell: when they're the same
+// regexp, or when `a` matches anything but the `tail` delimiter.
+func coversRegexp(a, b *syntax.Regexp, tail byte) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	if a.Equal(b) {
+		return true
+	}
+
+	switch a.Op {
+	case syntax.OpStar, syntax.OpPlus:
+	default:
+		return false
+	}
+	sub := a.Sub[0]
+	switch sub.Op {
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return true
+	case syntax.OpCharClass:
+		// the runes left out of the class may only be delimiters
+		next := rune(0)
+		for i := 0; i < len(sub.Rune); i += 2 {
+			for r := next; r < sub.Rune[i]; r++ {
+				if r != '/' && r != '\n' && r != rune(tail) {
+					return false
+				}
+			}
+			next = sub.Rune[i+1] + 1
+		}
+		return next > unicode.MaxRune
+	}
+	return false
+}
@@ -0,0 +1,228 @@
+package phi
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestMuxValidate(t *testing.T) {
+	h := func(ctx *fasthttp.RequestCtx) {}
+
+	tests := []struct {
+		name  string
+		setup func(r *Mux)
+		want  []Diagnostic
+	}{
+		{
+			name: "valid",
+			setup: func(r *Mux) {
+				r.Get("/users", h)
+				r.Post("/users", h)
+				r.Get("/users/me", h)
+				r.Get("/users/{id}", h)
+				r.Get("/users/{id:[0-9]+}/posts", h)
+				r.Get("/files/*", h)
+			},
+		},
+		{
+			name: "duplicate",
+			setup: func(r *Mux) {
+				r.Get("/users/{id}", h)
+				r.Post("/users/{id}", h)
+				r.Get("/users/{uid}", h)
+				r.Handle("/all", h)
+				r.Handle("/all", h)
+			},
+			want: []Diagnostic{
+				{Kind: DuplicateRoute, Pattern: "/all", Other: "/all"},
+				{Kind: DuplicateRoute, Method: "GET", Pattern: "/users/{uid}", Other: "/users/{id}"},
+			},
+		},
+		{
+			name: "shadowed by an equivalent regexp",
+			setup: func(r *Mux) {
+				r.Get("/users/{id:[0-9]+}", h)
+				r.Get("/users/{num:\\d+}/posts", h)
+			},
+			want: []Diagnostic{
+				{Kind: ShadowedRoute, Pattern: "/users/{num:\\d+}/posts", Other: "/users/{id:[0-9]+}"},
+			},
+		},
+		{
+			name: "shadowed by a catch-all regexp",
+			setup: func(r *Mux) {
+				r.Get("/users/{any:[^/]+}", h)
+				r.Get("/users/{name:[a-z]+}", h)
+				r.Get("/users/{id}", h)
+			},
+			want: []Diagnostic{
+				{Kind: ShadowedRoute, Pattern: "/users/{name:[a-z]+}", Other: "/users/{any:[^/]+}"},
+			},
+		},
+		{
+			name: "shadowed mounted route",
+			setup: func(r *Mux) {
+				r.Get("/api/users", h)
+				r.Route("/api", func(r Router) {
+					r.Get("/", h)
+					r.Get("/users", h)
+					r.Post("/users", h)
+				})
+			},
+			want: []Diagnostic{
+				{Kind: ShadowedRoute, Method: "GET", Pattern: "/api/users", Other: "/api/users"},
+			},
+		},
+		{
+			name: "regexp overlapping static",
+			setup: func(r *Mux) {
+				r.Get("/users/list", h)
+				r.Get("/users/1/edit", h)
+				r.Get("/users/{name:[a-z]+}", h)
+				r.Get("/users/{id:[0-9]+}/edit", h)
+			},
+			want: []Diagnostic{
+				{Kind: OverlappingRoute, Pattern: "/users/1/edit", Other: "/users/{id:[0-9]+}/edit"},
+				{Kind: OverlappingRoute, Pattern: "/users/list", Other: "/users/{name:[a-z]+}"},
+			},
+		},
+		{
+			name: "overlapping in a mounted router",
+			setup: func(r *Mux) {
+				r.Route("/api", func(r Router) {
+					r.Get("/new", h)
+					r.Get("/{slug:[a-z]+}", h)
+				})
+			},
+			want: []Diagnostic{
+				{Kind: OverlappingRoute, Pattern: "/api/new", Other: "/api/{slug:[a-z]+}"},
+			},
+		},
+		{
+			name: "unreachable",
+			setup: func(r *Mux) {
+				r.Get("/files/{none:[^\\x00-\\x{10FFFF}]}", h)
+				r.Get("/files/{path:[a-z]+/[a-z]+}", h)
+				r.Get("/files/{name:^$}/x", h)
+				r.Get("/docs/{name:[a-z]+\\.md}", h)
+				r.Get("/docs/{name:[a-z]+}.html", h)
+				r.Get("/docs/{name:[a-z]+-[0-9]+}.pdf", h)
+			},
+			want: []Diagnostic{
+				{Kind: UnreachableRoute, Pattern: "/files/{name:^$}/x"},
+				{Kind: UnreachableRoute, Pattern: "/files/{none:[^\\x00-\\x{10FFFF}]}"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRouter()
+			tt.setup(r)
+			if got := r.Validate(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected diagnostics:\n%v\nwant:\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMuxValidateRemovedRoutes(t *testing.T) {
+	h := func(ctx *fasthttp.RequestCtx) {}
+	r := NewRouter()
+	r.Get("/a", h)
+	r.Get("/a", h)
+	if len(r.Validate()) != 1 {
+		t.Fatalf("expected a duplicate, got %v", r.Validate())
+	}
+	if err := r.RemoveRoute("GET", "/a"); err != nil {
+		t.Fatal(err)
+	}
+	if diags := r.Validate(); len(diags) != 0 {
+		t.Fatalf("expected no diagnostics, got %v", diags)
+	}
+
+	// the duplicates of replaced or removed routes are forgotten
+	r.Get("/b/{id}", h)
+	r.Get("/b/{uid}", h)
+	r.Post("/b/{id}", h)
+	r.Post("/b/{id}", h)
+	if err := r.Replace("GET", "/b/{x}", h); err != nil {
+		t.Fatal(err)
+	}
+	if diags := r.Validate(); len(diags) != 1 || diags[0].Method != "POST" {
+		t.Fatalf("expected the POST duplicate only, got %v", diags)
+	}
+	if err := r.RemoveRoute("POST", "/b/{id}"); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.tree.duplicates) != 0 {
+		t.Fatalf("expected the duplicates to be pruned, got %v", r.tree.duplicates)
+	}
+}
+
+func TestMuxStrict(t *testing.T) {
+	h := func(ctx *fasthttp.RequestCtx) {}
+
+	expectPanic := func(t *testing.T, contains string, fn func()) {
+		t.Helper()
+		defer func() {
+			t.Helper()
+			r := recover()
+			if r == nil {
+				t.Fatalf("expected a panic")
+			}
+			if msg, _ := r.(string); !strings.Contains(msg, contains) {
+				t.Fatalf("unexpected panic %v", r)
+			}
+		}()
+		fn()
+	}
+
+	r := NewRouter()
+	r.Strict()
+	r.Get("/users/{id}", h)
+	r.Get("/users/me", h)
+
+	expectPanic(t, "GET /users/{uid} is registered twice, replacing /users/{id}", func() {
+		r.Get("/users/{uid}", h)
+	})
+	expectPanic(t, "/users/list overlaps the regexp param of /users/{name:[a-z]+}", func() {
+		r.Group(func(r Router) {
+			r.Get("/users/list", h)
+			r.Get("/users/{name:[a-z]+}", h)
+		})
+	})
+	expectPanic(t, "/api/users is shadowed by /api/users", func() {
+		r.Get("/api/users", h)
+		r.Route("/api", func(r Router) {
+			r.Get("/users", h)
+		})
+	})
+	expectPanic(t, "/v1/items is shadowed by /v1/items", func() {
+		r.Route("/v1", func(r Router) {
+			r.Get("/items", h)
+		})
+		r.Get("/v1/items", h)
+	})
+	expectPanic(t, "phi: /{a:^$} can't match any request path", func() {
+		r.Route("/x", func(r Router) {
+			r.Get("/{a:^$}", h)
+		})
+	})
+
+	// runtime registrations fail instead
+	err := r.AddRoute("GET", "/files/{id:[0-9]+}", h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.AddRoute("GET", "/files/{num:\\d+}/raw", h)
+	if err == nil || !strings.Contains(err.Error(), "is shadowed by /files/{id:[0-9]+}") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if r.Match(NewRouteContext(), "GET", "/files/1/raw") {
+		t.Fatal("the route shouldn't be registered")
+	}
+}
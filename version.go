@@ -0,0 +1,175 @@
+package phi
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// VersionConfig configures how a Mux resolves the API version requested,
+// see Mux.Versioning. The version is looked up in the path prefix, then in
+// the Accept header, then in the custom header, for the enabled ones.
+type VersionConfig struct {
+	// PathPrefix resolves the version from a leading path segment made of
+	// a "v" followed by the version, e.g. "/v2/users". The segment is
+	// stripped before routing.
+	PathPrefix bool
+
+	// Vendor resolves the version from Accept media types like
+	// "application/vnd.<Vendor>.v2+json".
+	Vendor string
+
+	// Header resolves the version from a custom request header, e.g.
+	// "X-API-Version", with or without a leading "v".
+	Header string
+
+	// Default is the version of the requests which don't ask for one. It's
+	// also served when a route isn't registered for the version asked,
+	// nor without a version.
+	Default string
+
+	// Deprecations lists the deprecated versions, whose responses get
+	// Deprecation, Sunset and Link headers.
+	Deprecations map[string]Deprecation
+}
+
+// Deprecation describes a deprecated API version.
+type Deprecation struct {
+	// Date is when the version got deprecated, "Deprecation: true" is
+	// sent when it's zero.
+	Date time.Time
+
+	// Sunset is when the version stops being served, if known.
+	Sunset time.Time
+
+	// Link points to the deprecation or migration documentation.
+	Link string
+}
+
+// Versioning enables API versions on the router, routes are then registered
+// for a version with Version:
+//
+//  r.Versioning(phi.VersionConfig{
+//    Vendor:  "acme",
+//    Header:  "X-API-Version",
+//    Default: "1",
+//    Deprecations: map[string]phi.Deprecation{
+//      "1": {Sunset: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+//    },
+//  })
+//  r.Version("1").Get("/users/{id}", getUserV1)
+//  r.Version("2").Get("/users/{id}", getUserV2)
+//  r.Get("/health", health) // served for every version
+//
+// The version is resolved once per request, by the first router configured
+// along the routing path, and recorded in Context.Version so sub-routers
+// dispatch on it too.
+func (mx *Mux) Versioning(cfg VersionConfig) {
+	m := mx
+	for m.inline && m.parent != nil {
+		m = m.parent
+	}
+	m.versioning = &cfg
+}
+
+// Version creates a new inline-Mux, like With, whose routes are registered
+// for the API `version`. They're only served to the requests resolved to
+// that version by Versioning. Their RouteMeta.Version is set.
+func (mx *Mux) Version(version string) Router {
+	return mx.Describe(func(m *RouteMeta) {
+		m.Version = version
+	})
+}
+
+// resolveVersion records the API version of the request in rctx, and
+// returns the routing path without its version prefix.
+func (mx *Mux) resolveVersion(ctx *fasthttp.RequestCtx, rctx *Context, routePath string) string {
+	cfg := mx.versioning
+	var version string
+
+	if cfg.PathPrefix {
+		seg := routePath[1:]
+		if i := strings.IndexByte(seg, '/'); i >= 0 {
+			seg = seg[:i]
+		}
+		if len(seg) > 1 && seg[0] == 'v' && isVersion(seg[1:]) {
+			// copied, as the routing path only lives as long as the request
+			version = string([]byte(seg[1:]))
+			routePath = routePath[1+len(seg):]
+			if routePath == "" {
+				routePath = "/"
+			}
+		}
+	}
+	if cfg.Vendor != "" {
+		ctx.Response.Header.Add(fasthttp.HeaderVary, fasthttp.HeaderAccept)
+		if version == "" {
+			version = vendorVersion(ctx.Request.Header.Peek(fasthttp.HeaderAccept), cfg.Vendor)
+		}
+	}
+	if cfg.Header != "" {
+		ctx.Response.Header.Add(fasthttp.HeaderVary, cfg.Header)
+		if version == "" {
+			version = strings.TrimPrefix(string(ctx.Request.Header.Peek(cfg.Header)), "v")
+		}
+	}
+	if version == "" {
+		version = cfg.Default
+	}
+	rctx.Version = version
+	rctx.defaultVersion = cfg.Default
+
+	if d, ok := cfg.Deprecations[version]; ok {
+		if d.Date.IsZero() {
+			ctx.Response.Header.Set("Deprecation", "true")
+		} else {
+			ctx.Response.Header.Set("Deprecation", "@"+strconv.FormatInt(d.Date.Unix(), 10))
+		}
+		if !d.Sunset.IsZero() {
+			ctx.Response.Header.Set("Sunset", string(fasthttp.AppendHTTPDate(nil, d.Sunset)))
+		}
+		if d.Link != "" {
+			ctx.Response.Header.Add(fasthttp.HeaderLink, "<"+d.Link+`>; rel="deprecation"`)
+		}
+	}
+	return routePath
+}
+
+// vendorVersion returns the version of the first Accept media type like
+// "application/vnd.<vendor>.v2+json".
+func vendorVersion(accept []byte, vendor string) string {
+	prefix := "application/vnd." + strings.ToLower(vendor) + ".v"
+	for _, mt := range strings.Split(string(accept), ",") {
+		if i := strings.IndexByte(mt, ';'); i >= 0 {
+			mt = mt[:i]
+		}
+		mt = strings.ToLower(strings.TrimSpace(mt))
+		if !strings.HasPrefix(mt, prefix) {
+			continue
+		}
+		v := mt[len(prefix):]
+		if i := strings.IndexByte(v, '+'); i >= 0 {
+			v = v[:i]
+		}
+		if isVersion(v) {
+			return v
+		}
+	}
+	return ""
+}
+
+// isVersion reports whether v is made of digits and dots, like "2" or
+// "2.1".
+func isVersion(v string) bool {
+	if v == "" || v[0] < '0' || v[0] > '9' {
+		return false
+	}
+	for i := 0; i < len(v); i++ {
+		if (v[i] < '0' || v[i] > '9') && v[i] != '.' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,35 @@
+package phi
+
+import (
+	"github.com/fate-lovely/phi/websocket"
+	"github.com/valyala/fasthttp"
+)
+
+// WebSocket adds the route `pattern` that matches a GET http method and
+// upgrades the request to a WebSocket connection served by `handler`.
+// See WebSocketHandler to configure the handshake.
+func (mx *Mux) WebSocket(pattern string, handler websocket.HandlerFunc) {
+	mx.Get(pattern, WebSocketHandler(&websocket.Upgrader{}, handler))
+}
+
+// WebSocketHandler returns a HandlerFunc upgrading requests with `u`, and
+// serving the connections with `handler`.
+//
+// The connection handler runs after the request one returned, once the
+// pooled routing context is reused by another request. A copy of it is
+// kept on the connection instead, so that URLParam and RouteContext work
+// from the handler:
+//
+//  u := &websocket.Upgrader{Subprotocols: []string{"chat.v1"}}
+//  r.Get("/rooms/{id}/ws", phi.WebSocketHandler(u, func(conn *websocket.Conn) {
+//    room := phi.URLParam(conn, "id")
+//    ...
+//  }))
+func WebSocketHandler(u *websocket.Upgrader, handler websocket.HandlerFunc) HandlerFunc {
+	return func(ctx *fasthttp.RequestCtx) {
+		if rctx, ok := ctx.UserValue(routeCtxKey).(*Context); ok {
+			ctx.SetUserValue(RouteCtxKey, rctx.clone())
+		}
+		u.Upgrade(ctx, handler)
+	}
+}
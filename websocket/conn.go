@@ -0,0 +1,434 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Message types, they match the frame opcodes of RFC 6455.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// Close codes defined in RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+)
+
+const continuationFrame = 0
+
+// ErrClosed is returned when using a closed connection.
+var ErrClosed = errors.New("websocket: connection closed")
+
+// CloseError is returned by ReadMessage when the peer closed the
+// connection, or when it violated the protocol.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return "websocket: close " + strconv.Itoa(e.Code) + " " + e.Text
+}
+
+// Conn is an upgraded WebSocket connection. One goroutine may read while
+// others write concurrently.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	subprotocol  string
+	compress     bool
+	readLimit    int64
+	pingInterval time.Duration
+	values       map[interface{}]interface{}
+
+	readErr     error
+	pongHandler func(data []byte)
+
+	wmu       sync.Mutex
+	bw        *bufio.Writer
+	closeSent bool
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (c *Conn) init(conn net.Conn) {
+	c.conn = conn
+	c.br = bufio.NewReader(conn)
+	c.bw = bufio.NewWriter(conn)
+	c.closed = make(chan struct{})
+
+	if c.pingInterval > 0 {
+		go c.pingLoop()
+	}
+}
+
+// Subprotocol returns the negotiated subprotocol, if any.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// UserValue returns the user value `key` of the upgraded request context.
+func (c *Conn) UserValue(key interface{}) interface{} {
+	return c.values[key]
+}
+
+// RemoteAddr returns the client network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetReadDeadline sets the deadline of the underlying connection reads.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline of the underlying connection writes.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// SetReadLimit sets the maximum size of a message read, after
+// decompression. Larger messages close the connection with
+// CloseMessageTooBig.
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// SetPongHandler sets the function called with the payload of the pong
+// frames read. It runs from ReadMessage.
+func (c *Conn) SetPongHandler(fn func(data []byte)) {
+	c.pongHandler = fn
+}
+
+// ReadMessage reads the next data message, answering the pings and
+// handling the close frames read meanwhile. Once it returned an error,
+// every later call returns it too.
+func (c *Conn) ReadMessage() (messageType int, p []byte, err error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+
+	compressed := false
+	for {
+		fin, rsv1, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		switch opcode {
+		case PingMessage:
+			if err := c.WriteControl(PongMessage, payload); err != nil && err != ErrClosed {
+				return 0, nil, c.fail(err)
+			}
+			continue
+		case PongMessage:
+			if c.pongHandler != nil {
+				c.pongHandler(payload)
+			}
+			continue
+		case CloseMessage:
+			return 0, nil, c.fail(parseClose(payload))
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.fail(&CloseError{CloseProtocolError, "unexpected data frame"})
+			}
+			if rsv1 && !c.compress {
+				return 0, nil, c.fail(&CloseError{CloseProtocolError, "unexpected rsv1 bit"})
+			}
+			messageType, compressed = opcode, rsv1
+		case continuationFrame:
+			if messageType == 0 || rsv1 {
+				return 0, nil, c.fail(&CloseError{CloseProtocolError, "unexpected continuation frame"})
+			}
+		default:
+			return 0, nil, c.fail(&CloseError{CloseProtocolError, "unknown opcode"})
+		}
+
+		if int64(len(p)+len(payload)) > c.readLimit {
+			return 0, nil, c.fail(&CloseError{CloseMessageTooBig, "message too big"})
+		}
+		p = append(p, payload...)
+		if fin {
+			break
+		}
+	}
+
+	if compressed {
+		if p, err = decompress(p, c.readLimit); err != nil {
+			return 0, nil, c.fail(err)
+		}
+	}
+	if messageType == TextMessage && !utf8.Valid(p) {
+		return 0, nil, c.fail(&CloseError{CloseInvalidPayload, "invalid utf-8"})
+	}
+	return messageType, p, nil
+}
+
+// readFrame reads a single frame, checking the framing rules.
+func (c *Conn) readFrame() (fin, rsv1 bool, opcode int, payload []byte, err error) {
+	if c.pingInterval > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(2 * c.pingInterval))
+	}
+
+	var h [14]byte
+	if _, err = io.ReadFull(c.br, h[:2]); err != nil {
+		return
+	}
+	fin = h[0]&0x80 != 0
+	rsv1 = h[0]&0x40 != 0
+	opcode = int(h[0] & 0x0f)
+	masked := h[1]&0x80 != 0
+	length := int64(h[1] & 0x7f)
+
+	if h[0]&0x30 != 0 {
+		err = &CloseError{CloseProtocolError, "unexpected rsv bits"}
+		return
+	}
+	if !masked {
+		err = &CloseError{CloseProtocolError, "unmasked client frame"}
+		return
+	}
+	if opcode >= CloseMessage && (!fin || rsv1 || length > 125) {
+		err = &CloseError{CloseProtocolError, "invalid control frame"}
+		return
+	}
+
+	switch length {
+	case 126:
+		if _, err = io.ReadFull(c.br, h[2:4]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(h[2:4]))
+	case 127:
+		if _, err = io.ReadFull(c.br, h[2:10]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(h[2:10]))
+		if length < 0 {
+			err = &CloseError{CloseProtocolError, "invalid frame length"}
+			return
+		}
+	}
+	if length > c.readLimit {
+		err = &CloseError{CloseMessageTooBig, "message too big"}
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i&3]
+	}
+	return
+}
+
+// fail records the read error, and answers close frames and protocol
+// errors with a close frame.
+func (c *Conn) fail(err error) error {
+	if ce, ok := err.(*CloseError); ok {
+		code := ce.Code
+		if code == CloseNoStatusReceived {
+			code = CloseNormalClosure
+		}
+		c.WriteClose(code, "")
+	}
+	c.readErr = err
+	return err
+}
+
+func parseClose(payload []byte) error {
+	if len(payload) == 0 {
+		return &CloseError{Code: CloseNoStatusReceived}
+	}
+	if len(payload) < 2 {
+		return &CloseError{CloseProtocolError, "invalid close payload"}
+	}
+	code := int(binary.BigEndian.Uint16(payload))
+	if !validCloseCode(code) || !utf8.Valid(payload[2:]) {
+		return &CloseError{CloseProtocolError, "invalid close payload"}
+	}
+	return &CloseError{code, string(payload[2:])}
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// WriteMessage writes a data message, compressed when permessage-deflate
+// was negotiated. Control message types are passed to WriteControl.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	switch messageType {
+	case TextMessage, BinaryMessage:
+	case CloseMessage, PingMessage, PongMessage:
+		return c.WriteControl(messageType, data)
+	default:
+		return errors.New("websocket: unknown message type")
+	}
+
+	rsv1 := false
+	if c.compress && len(data) > 0 {
+		data, rsv1 = compress(data), true
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	return c.writeFrame(messageType, rsv1, data)
+}
+
+// WriteControl writes a ping, pong or close frame of at most 125 bytes.
+func (c *Conn) WriteControl(messageType int, data []byte) error {
+	if len(data) > 125 {
+		return errors.New("websocket: control frame too long")
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	if messageType == CloseMessage {
+		c.closeSent = true
+	}
+	return c.writeFrame(messageType, false, data)
+}
+
+// Ping sends a ping, the client answers with a pong.
+func (c *Conn) Ping(data []byte) error {
+	return c.WriteControl(PingMessage, data)
+}
+
+// WriteClose sends a close frame, no message can be written after it.
+func (c *Conn) WriteClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	return c.WriteControl(CloseMessage, payload)
+}
+
+// Close sends a normal closure frame if none was sent yet, and closes the
+// underlying connection.
+func (c *Conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.WriteClose(CloseNormalClosure, "")
+		close(c.closed)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// writeFrame must be called with c.wmu held. Server frames aren't masked.
+func (c *Conn) writeFrame(opcode int, rsv1 bool, payload []byte) error {
+	var h [10]byte
+	h[0] = 0x80 | byte(opcode)
+	if rsv1 {
+		h[0] |= 0x40
+	}
+	n := 2
+	switch {
+	case len(payload) <= 125:
+		h[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		h[1] = 126
+		binary.BigEndian.PutUint16(h[2:], uint16(len(payload)))
+		n = 4
+	default:
+		h[1] = 127
+		binary.BigEndian.PutUint64(h[2:], uint64(len(payload)))
+		n = 10
+	}
+	c.bw.Write(h[:n])
+	c.bw.Write(payload)
+	return c.bw.Flush()
+}
+
+func (c *Conn) pingLoop() {
+	t := time.NewTicker(c.pingInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if c.Ping(nil) != nil {
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+var flateWriterPool sync.Pool
+
+// deflateTail ends a message compressed with a sync flush. The final empty
+// stored block makes the flate reader stop cleanly.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+func compress(data []byte) []byte {
+	var buf bytes.Buffer
+	fw, _ := flateWriterPool.Get().(*flate.Writer)
+	if fw == nil {
+		fw, _ = flate.NewWriter(&buf, flate.BestSpeed)
+	} else {
+		fw.Reset(&buf)
+	}
+	fw.Write(data)
+	fw.Flush()
+	flateWriterPool.Put(fw)
+
+	// Strip the sync flush marker, as RFC 7692 section 7.2.1 requires.
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail[:4])
+}
+
+func decompress(data []byte, limit int64) ([]byte, error) {
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail)))
+	defer fr.Close()
+
+	p, err := ioutil.ReadAll(io.LimitReader(fr, limit+1))
+	if err != nil {
+		return nil, &CloseError{CloseInvalidPayload, "invalid compressed data"}
+	}
+	if int64(len(p)) > limit {
+		return nil, &CloseError{CloseMessageTooBig, "message too big"}
+	}
+	return p, nil
+}
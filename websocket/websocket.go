@@ -0,0 +1,219 @@
+// Package websocket implements the server side of the WebSocket protocol
+// (RFC 6455) on top of fasthttp connection hijacking, with subprotocol
+// negotiation, origin checks, ping/pong and the permessage-deflate
+// extension (RFC 7692).
+//
+// Most applications register endpoints with phi's Mux.WebSocket, which
+// keeps the route URL parameters available on the connection:
+//
+//  r.WebSocket("/rooms/{id}/ws", func(conn *websocket.Conn) {
+//    room := phi.URLParam(conn, "id")
+//    for {
+//      typ, msg, err := conn.ReadMessage()
+//      if err != nil {
+//        return
+//      }
+//      conn.WriteMessage(typ, msg)
+//    }
+//  })
+//
+// An Upgrader configures the handshake, use it with phi.WebSocketHandler:
+//
+//  u := &websocket.Upgrader{Subprotocols: []string{"chat.v1"}, EnableCompression: true}
+//  r.Get("/rooms/{id}/chat", phi.WebSocketHandler(u, chat))
+package websocket
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// HandlerFunc serves an upgraded connection. The connection is closed when
+// it returns.
+type HandlerFunc func(conn *Conn)
+
+// ErrBadHandshake is returned by Upgrade when the request isn't a valid
+// WebSocket handshake.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// ErrOriginDenied is returned by Upgrade when CheckOrigin rejects the
+// request.
+var ErrOriginDenied = errors.New("websocket: origin not allowed")
+
+// Upgrader upgrades HTTP requests to WebSocket connections.
+type Upgrader struct {
+	// Subprotocols lists the supported subprotocols, by order of
+	// preference. The first one offered by the client is selected.
+	Subprotocols []string
+
+	// CheckOrigin reports whether the request Origin is allowed. By
+	// default requests without an Origin header, or whose Origin host
+	// matches the Host header, are allowed.
+	CheckOrigin func(ctx *fasthttp.RequestCtx) bool
+
+	// EnableCompression negotiates the permessage-deflate extension when
+	// the client offers it.
+	EnableCompression bool
+
+	// ReadLimit is the maximum size of a message read, after
+	// decompression. 32MB by default.
+	ReadLimit int64
+
+	// PingInterval makes the server ping the client at this interval, and
+	// close the connection when nothing was read for two intervals.
+	PingInterval time.Duration
+}
+
+// Handler returns a fasthttp handler upgrading requests and serving them
+// with fn. Failed handshakes get a 400, 403 or 426 response.
+//
+// Within phi routers, use phi.WebSocketHandler instead, which keeps the
+// routing context available on the connection.
+func (u *Upgrader) Handler(fn HandlerFunc) func(ctx *fasthttp.RequestCtx) {
+	return func(ctx *fasthttp.RequestCtx) {
+		u.Upgrade(ctx, fn)
+	}
+}
+
+// Upgrade validates the handshake, responds with a 101 Switching Protocols
+// and serves the hijacked connection with fn once the request handler
+// returned. On failure, an error response is written and an error is
+// returned.
+//
+// The request context can't be used anymore from fn, its user values are
+// copied on the Conn instead.
+func (u *Upgrader) Upgrade(ctx *fasthttp.RequestCtx, fn HandlerFunc) error {
+	h := &ctx.Request.Header
+	if !ctx.IsGet() ||
+		!headerContainsToken(h.Peek(fasthttp.HeaderConnection), "upgrade") ||
+		!headerContainsToken(h.Peek(fasthttp.HeaderUpgrade), "websocket") {
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusBadRequest), fasthttp.StatusBadRequest)
+		return ErrBadHandshake
+	}
+	if string(h.Peek("Sec-WebSocket-Version")) != "13" {
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusUpgradeRequired), fasthttp.StatusUpgradeRequired)
+		ctx.Response.Header.Set("Sec-WebSocket-Version", "13")
+		return ErrBadHandshake
+	}
+	key := h.Peek("Sec-WebSocket-Key")
+	if k, err := base64.StdEncoding.DecodeString(string(key)); err != nil || len(k) != 16 {
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusBadRequest), fasthttp.StatusBadRequest)
+		return ErrBadHandshake
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(ctx) {
+		ctx.Error(fasthttp.StatusMessage(fasthttp.StatusForbidden), fasthttp.StatusForbidden)
+		return ErrOriginDenied
+	}
+
+	conn := &Conn{
+		readLimit:    u.ReadLimit,
+		pingInterval: u.PingInterval,
+		values:       make(map[interface{}]interface{}),
+	}
+	if conn.readLimit <= 0 {
+		conn.readLimit = 32 << 20
+	}
+	ctx.VisitUserValuesAll(func(k, v interface{}) {
+		conn.values[k] = v
+	})
+	conn.subprotocol = u.selectSubprotocol(h.Peek("Sec-WebSocket-Protocol"))
+
+	ctx.SetStatusCode(fasthttp.StatusSwitchingProtocols)
+	ctx.Response.Header.SetNoDefaultContentType(true)
+	ctx.Response.Header.Set(fasthttp.HeaderUpgrade, "websocket")
+	ctx.Response.Header.Set(fasthttp.HeaderConnection, "Upgrade")
+	ctx.Response.Header.Set("Sec-WebSocket-Accept", acceptKey(key))
+	if conn.subprotocol != "" {
+		ctx.Response.Header.Set("Sec-WebSocket-Protocol", conn.subprotocol)
+	}
+	if u.EnableCompression && offersDeflate(h.Peek("Sec-WebSocket-Extensions")) {
+		conn.compress = true
+		// Without context takeover every message is compressed on its own,
+		// which keeps the per connection memory low.
+		ctx.Response.Header.Set("Sec-WebSocket-Extensions",
+			"permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+	}
+
+	ctx.Hijack(func(c net.Conn) {
+		conn.init(c)
+		defer conn.Close()
+		fn(conn)
+	})
+	return nil
+}
+
+func (u *Upgrader) selectSubprotocol(offered []byte) string {
+	for _, p := range u.Subprotocols {
+		if headerContainsToken(offered, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func acceptKey(key []byte) string {
+	h := sha1.New()
+	h.Write(key)
+	h.Write([]byte(acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func sameOrigin(ctx *fasthttp.RequestCtx) bool {
+	origin := ctx.Request.Header.Peek(fasthttp.HeaderOrigin)
+	if len(origin) == 0 {
+		return true
+	}
+	u, err := url.Parse(string(origin))
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, string(ctx.Host()))
+}
+
+// headerContainsToken reports whether the comma separated header value
+// holds `token`, case insensitively.
+func headerContainsToken(v []byte, token string) bool {
+	for _, t := range bytes.Split(v, []byte{','}) {
+		if strings.EqualFold(string(bytes.TrimSpace(t)), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// offersDeflate reports whether the client offers permessage-deflate with
+// parameters the server can honor: compress/flate always uses a 32KB
+// window, so offers restricting the server window are declined.
+func offersDeflate(v []byte) bool {
+	for _, ext := range strings.Split(string(v), ",") {
+		params := strings.Split(ext, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		ok := true
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "server_max_window_bits") && p != "server_max_window_bits=15" && p != `server_max_window_bits="15"` {
+				ok = false
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
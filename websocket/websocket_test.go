@@ -0,0 +1,268 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func echo(conn *Conn) {
+	for {
+		typ, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(typ, msg)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	u := &Upgrader{Subprotocols: []string{"chat.v2", "chat.v1"}}
+	c, resp := dial(t, u.Handler(func(conn *Conn) {
+		conn.WriteMessage(TextMessage, []byte(conn.Subprotocol()+" "+conn.UserValue("user").(string)))
+		echo(conn)
+	}), "Sec-WebSocket-Protocol: chat.v1, chat.v2\r\n")
+
+	if resp.StatusCode() != fasthttp.StatusSwitchingProtocols {
+		t.Fatalf("status = %d", resp.StatusCode())
+	}
+	if got := string(resp.Peek("Sec-WebSocket-Accept")); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("accept = %q", got)
+	}
+	if got := string(resp.Peek("Sec-WebSocket-Protocol")); got != "chat.v2" {
+		t.Fatalf("subprotocol = %q", got)
+	}
+
+	c.expect(t, TextMessage, "chat.v2 alice")
+
+	c.writeFrame(true, false, TextMessage, []byte("hello"))
+	c.expect(t, TextMessage, "hello")
+
+	// fragmented message with an interleaved ping
+	c.writeFrame(false, false, BinaryMessage, []byte("frag"))
+	c.writeFrame(true, false, PingMessage, []byte("p"))
+	c.writeFrame(true, false, continuationFrame, []byte("mented"))
+	c.expect(t, PongMessage, "p")
+	c.expect(t, BinaryMessage, "fragmented")
+
+	c.writeFrame(true, false, CloseMessage, closePayload(CloseGoingAway, "bye"))
+	c.expect(t, CloseMessage, string(closePayload(CloseGoingAway, "")))
+}
+
+func TestUpgradeErrors(t *testing.T) {
+	u := &Upgrader{}
+	valid := func(ctx *fasthttp.RequestCtx) {
+		ctx.Request.Header.Set("Upgrade", "websocket")
+		ctx.Request.Header.Set("Connection", "keep-alive, Upgrade")
+		ctx.Request.Header.Set("Sec-WebSocket-Version", "13")
+		ctx.Request.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		ctx.Request.Header.SetHost("example.com")
+	}
+
+	tests := []struct {
+		name   string
+		modify func(ctx *fasthttp.RequestCtx)
+		status int
+		err    error
+	}{
+		{"valid", func(ctx *fasthttp.RequestCtx) {}, 101, nil},
+		{"method", func(ctx *fasthttp.RequestCtx) { ctx.Request.Header.SetMethod("POST") }, 400, ErrBadHandshake},
+		{"upgrade", func(ctx *fasthttp.RequestCtx) { ctx.Request.Header.Del("Upgrade") }, 400, ErrBadHandshake},
+		{"version", func(ctx *fasthttp.RequestCtx) { ctx.Request.Header.Set("Sec-WebSocket-Version", "8") }, 426, ErrBadHandshake},
+		{"key", func(ctx *fasthttp.RequestCtx) { ctx.Request.Header.Set("Sec-WebSocket-Key", "short") }, 400, ErrBadHandshake},
+		{"same origin", func(ctx *fasthttp.RequestCtx) { ctx.Request.Header.Set("Origin", "https://example.com") }, 101, nil},
+		{"cross origin", func(ctx *fasthttp.RequestCtx) { ctx.Request.Header.Set("Origin", "https://evil.com") }, 403, ErrOriginDenied},
+	}
+	for _, tt := range tests {
+		var ctx fasthttp.RequestCtx
+		valid(&ctx)
+		tt.modify(&ctx)
+		err := u.Upgrade(&ctx, echo)
+		if err != tt.err || ctx.Response.StatusCode() != tt.status {
+			t.Errorf("%s: got %d %v, want %d %v", tt.name, ctx.Response.StatusCode(), err, tt.status, tt.err)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	u := &Upgrader{EnableCompression: true}
+	c, resp := dial(t, u.Handler(echo), "Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n")
+	if got := string(resp.Peek("Sec-WebSocket-Extensions")); !strings.HasPrefix(got, "permessage-deflate") {
+		t.Fatalf("extensions = %q", got)
+	}
+
+	msg := strings.Repeat("compress me ", 100)
+	c.writeFrame(true, true, TextMessage, compress([]byte(msg)))
+	_, rsv1, opcode, payload := c.readFrame(t)
+	if !rsv1 || opcode != TextMessage || len(payload) >= len(msg) {
+		t.Fatalf("rsv1 = %v, opcode = %d, len = %d", rsv1, opcode, len(payload))
+	}
+	p, err := decompress(payload, 1<<20)
+	if err != nil || string(p) != msg {
+		t.Fatalf("decompressed = %q, %v", p, err)
+	}
+
+	// declined when the server window is restricted
+	u2 := &Upgrader{EnableCompression: true}
+	_, resp = dial(t, u2.Handler(echo), "Sec-WebSocket-Extensions: permessage-deflate; server_max_window_bits=10\r\n")
+	if got := resp.Peek("Sec-WebSocket-Extensions"); len(got) > 0 {
+		t.Fatalf("extensions = %q", got)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(c *testClient)
+		code int
+	}{
+		{"invalid utf-8", func(c *testClient) { c.writeFrame(true, false, TextMessage, []byte{0xff}) }, CloseInvalidPayload},
+		{"rsv1 without compression", func(c *testClient) { c.writeFrame(true, true, TextMessage, []byte("x")) }, CloseProtocolError},
+		{"orphan continuation", func(c *testClient) { c.writeFrame(true, false, continuationFrame, []byte("x")) }, CloseProtocolError},
+		{"fragmented ping", func(c *testClient) { c.writeFrame(false, false, PingMessage, nil) }, CloseProtocolError},
+		{"too big", func(c *testClient) { c.writeFrame(true, false, BinaryMessage, make([]byte, 200)) }, CloseMessageTooBig},
+		{"unmasked", func(c *testClient) { c.conn.Write([]byte{0x81, 0x01, 'x'}) }, CloseProtocolError},
+	}
+	for _, tt := range tests {
+		errc := make(chan error, 1)
+		u := &Upgrader{ReadLimit: 100}
+		c, _ := dial(t, u.Handler(func(conn *Conn) {
+			_, _, err := conn.ReadMessage()
+			errc <- err
+		}), "")
+		tt.send(c)
+		_, _, opcode, payload := c.readFrame(t)
+		if opcode != CloseMessage || int(binary.BigEndian.Uint16(payload)) != tt.code {
+			t.Errorf("%s: got opcode %d payload %v, want close %d", tt.name, opcode, payload, tt.code)
+		}
+		if ce, ok := (<-errc).(*CloseError); !ok || ce.Code != tt.code {
+			t.Errorf("%s: ReadMessage error = %v", tt.name, ce)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	pongs := make(chan string, 1)
+	u := &Upgrader{PingInterval: 20 * time.Millisecond}
+	c, _ := dial(t, u.Handler(func(conn *Conn) {
+		conn.SetPongHandler(func(data []byte) { pongs <- string(data) })
+		echo(conn)
+	}), "")
+
+	_, _, opcode, _ := c.readFrame(t)
+	if opcode != PingMessage {
+		t.Fatalf("opcode = %d, want a ping", opcode)
+	}
+	c.writeFrame(true, false, PongMessage, []byte("pong"))
+	c.writeFrame(true, false, TextMessage, []byte("x"))
+	c.expect(t, TextMessage, "x")
+	if got := <-pongs; got != "pong" {
+		t.Fatalf("pong = %q", got)
+	}
+}
+
+type testClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// dial serves handler on an in-memory listener, and performs a handshake
+// with the given extra header lines. A "user" user value is set.
+func dial(t *testing.T, handler func(ctx *fasthttp.RequestCtx), header string) (*testClient, *fasthttp.ResponseHeader) {
+	ln := fasthttputil.NewInmemoryListener()
+	t.Cleanup(func() { ln.Close() })
+	go fasthttp.Serve(ln, func(ctx *fasthttp.RequestCtx) {
+		ctx.SetUserValue("user", "alice")
+		handler(ctx)
+	})
+
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n"+header+"\r\n")
+
+	c := &testClient{conn: conn, br: bufio.NewReader(conn)}
+	var resp fasthttp.ResponseHeader
+	if err := resp.Read(c.br); err != nil {
+		t.Fatal(err)
+	}
+	return c, &resp
+}
+
+func (c *testClient) writeFrame(fin, rsv1 bool, opcode int, payload []byte) {
+	var b bytes.Buffer
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	if rsv1 {
+		b0 |= 0x40
+	}
+	b.WriteByte(b0)
+	switch {
+	case len(payload) <= 125:
+		b.WriteByte(0x80 | byte(len(payload)))
+	default:
+		b.WriteByte(0x80 | 126)
+		binary.Write(&b, binary.BigEndian, uint16(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	b.Write(mask)
+	for i, v := range payload {
+		b.WriteByte(v ^ mask[i&3])
+	}
+	c.conn.Write(b.Bytes())
+}
+
+func (c *testClient) readFrame(t *testing.T) (fin, rsv1 bool, opcode int, payload []byte) {
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		t.Fatal(err)
+	}
+	fin, rsv1, opcode = h[0]&0x80 != 0, h[0]&0x40 != 0, int(h[0]&0x0f)
+	if h[1]&0x80 != 0 {
+		t.Fatal("server frames must not be masked")
+	}
+	n := int(h[1] & 0x7f)
+	if n == 126 {
+		var l uint16
+		binary.Read(c.br, binary.BigEndian, &l)
+		n = int(l)
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func (c *testClient) expect(t *testing.T, opcode int, payload string) {
+	t.Helper()
+	_, _, op, p := c.readFrame(t)
+	if op != opcode || string(p) != payload {
+		t.Fatalf("got frame %d %q, want %d %q", op, p, opcode, payload)
+	}
+}
+
+func closePayload(code int, reason string) []byte {
+	p := make([]byte, 2)
+	binary.BigEndian.PutUint16(p, uint16(code))
+	return append(p, reason...)
+}
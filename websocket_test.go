@@ -0,0 +1,70 @@
+package phi
+
+import (
+	"bufio"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/fate-lovely/phi/websocket"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestMuxWebSocket(t *testing.T) {
+	r := NewRouter()
+	r.Get("/rooms/{id}", func(ctx *fasthttp.RequestCtx) {
+		ctx.WriteString("room " + URLParam(ctx, "id"))
+	})
+	r.Route("/rooms/{id}", func(r Router) {
+		r.WebSocket("/ws", func(conn *websocket.Conn) {
+			// let other requests reuse the pooled routing context
+			time.Sleep(10 * time.Millisecond)
+			msg := URLParam(conn, "id") + " " + RouteContext(conn).RoutePattern()
+			conn.WriteMessage(websocket.TextMessage, []byte(msg))
+		})
+	})
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go fasthttp.Serve(ln, r.ServeFastHTTP)
+
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(conn, "GET /rooms/42/ws HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(conn)
+	var resp fasthttp.ResponseHeader
+	if err := resp.Read(br); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != fasthttp.StatusSwitchingProtocols {
+		t.Fatalf("status = %d", resp.StatusCode())
+	}
+
+	// concurrent plain requests while the connection is open
+	for i := 0; i < 10; i++ {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.SetRequestURI("/rooms/7")
+		r.ServeFastHTTP(&ctx)
+	}
+
+	var h [2]byte
+	if _, err := io.ReadFull(br, h[:]); err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, h[1]&0x7f)
+	io.ReadFull(br, payload)
+	if got, want := string(payload), "42 /rooms/{id}/ws"; got != want {
+		t.Fatalf("message = %q, want %q", got, want)
+	}
+}